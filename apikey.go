@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/albertwidi/ftest/auth"
+)
+
+// runAPIKey runs the apikey subcommand. The supported commands are:
+//
+//	apikey create <name> <scopes...> creates an API key and prints the key into the stdout.
+//	apikey revoke <api_key_id>       revokes the API key.
+//
+// The subcommand is used to create the first API key with the keys:admin scope, the other keys can then be managed with
+// the admin endpoints.
+func runAPIKey(ctx context.Context, keys *auth.Keys, args []string) error {
+	if len(args) == 0 {
+		return errors.New("apikey command is required, must be one of create or revoke")
+	}
+	switch args[0] {
+	case "create":
+		if len(args) < 3 {
+			return errors.New("usage: apikey create <name> <scopes...>")
+		}
+		// The keys of the subcommand are the keys of the platform, so they don't have an owner.
+		key, apiKey, err := keys.Create(ctx, args[1], "", args[2:])
+		if err != nil {
+			return err
+		}
+		// Only the key is printed into the stdout, so the key can be used by the scripts.
+		fmt.Fprintf(os.Stderr, "api_key_id: %s\n", apiKey.ID)
+		fmt.Println(key)
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New("usage: apikey revoke <api_key_id>")
+		}
+		return keys.Revoke(ctx, args[1])
+	default:
+		return errors.New("unknown apikey command, must be one of create or revoke")
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// apiKeyPrefix is the prefix of all API keys, so a leaked key can be recognized by the secret scanners.
+	apiKeyPrefix = "ldg_"
+	// apiKeyDisplayLength is the length of the key that is stored as the key_prefix.
+	apiKeyDisplayLength = 12
+	// apiKeyCacheTTL is how long an authenticated key is cached, so not every request needs to query the database. A
+	// revoked key might still be used for this duration by the replicas that already cached the key.
+	apiKeyCacheTTL = 10 * time.Second
+	// maxOwnerLength is the maximum length of the owner of the key, the same with the owner of the accounts.
+	maxOwnerLength = 255
+)
+
+// APIKey is the API key of a client. The key itself is never stored, only the hash of the key.
+type APIKey struct {
+	ID   string
+	Name string
+	// Prefix is the first characters of the key, so the owner can recognize the key.
+	Prefix string
+	// OwnerID is the owner that the key is bound to, the key without owner can access all accounts.
+	OwnerID   string
+	Scopes    []string
+	CreatedAt time.Time
+	RevokedAt sql.NullTime
+
+	hash string
+}
+
+// keyStorage stores the API keys. The storage returns ErrAPIKeyNotFound if the key doesn't exist.
+type keyStorage interface {
+	createAPIKey(ctx context.Context, key APIKey) error
+	getAPIKeyByHash(ctx context.Context, hash string) (APIKey, error)
+	listAPIKeys(ctx context.Context) ([]APIKey, error)
+	revokeAPIKey(ctx context.Context, id string, revokedAt time.Time) error
+}
+
+// cachedPrincipal is the principal of an authenticated key, the principal is used until expiresAt.
+type cachedPrincipal struct {
+	principal Principal
+	expiresAt time.Time
+}
+
+// Keys manages the API keys and authenticates the clients by their API key.
+type Keys struct {
+	storage keyStorage
+
+	mu    sync.Mutex
+	cache map[string]cachedPrincipal
+}
+
+// NewKeys creates the API keys that are stored in Postgres.
+func NewKeys(db *sql.DB) *Keys {
+	return newKeys(&postgresKeys{db: db})
+}
+
+// NewMemoryKeys creates the API keys that are stored in the memory, the keys are gone when the service is stopped.
+func NewMemoryKeys() *Keys {
+	return newKeys(&memoryKeys{keys: make(map[string]APIKey)})
+}
+
+func newKeys(storage keyStorage) *Keys {
+	return &Keys{
+		storage: storage,
+		cache:   make(map[string]cachedPrincipal),
+	}
+}
+
+// hashAPIKey returns the SHA-256 hash of the key. The key is random with 256 bits of entropy, so it doesn't need a slow
+// password hash and the hash can be used to find the key.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create creates a new API key with the scopes, the key is bound to the owner if the owner is not empty. The key is
+// returned along with its record, and the key cannot be retrieved again after this.
+func (k *Keys) Create(ctx context.Context, name, owner string, scopes []string) (string, APIKey, error) {
+	if name == "" {
+		return "", APIKey{}, fmt.Errorf("%w: name cannot be empty", ErrInvalidAPIKey)
+	}
+	if len(owner) > maxOwnerLength {
+		return "", APIKey{}, fmt.Errorf("%w: owner cannot be longer than %d characters", ErrInvalidAPIKey, maxOwnerLength)
+	}
+	if err := validateScopes(scopes); err != nil {
+		return "", APIKey{}, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIKey{}, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	apiKey := APIKey{
+		ID:        uuid.NewString(),
+		Name:      name,
+		Prefix:    key[:apiKeyDisplayLength],
+		OwnerID:   owner,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		hash:      hashAPIKey(key),
+	}
+	if err := k.storage.createAPIKey(ctx, apiKey); err != nil {
+		return "", APIKey{}, err
+	}
+	return key, apiKey, nil
+}
+
+// Authenticate returns the principal of the key. ErrUnauthenticated is returned if the key doesn't exist or is revoked.
+func (k *Keys) Authenticate(ctx context.Context, key string) (Principal, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return Principal{}, ErrUnauthenticated
+	}
+	hash := hashAPIKey(key)
+
+	k.mu.Lock()
+	cached, ok := k.cache[hash]
+	k.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.principal, nil
+	}
+
+	apiKey, err := k.storage.getAPIKeyByHash(ctx, hash)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return Principal{}, ErrUnauthenticated
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+	if apiKey.RevokedAt.Valid {
+		return Principal{}, fmt.Errorf("%w: api key %s is revoked", ErrUnauthenticated, apiKey.ID)
+	}
+
+	principal := Principal{
+		ID:     apiKey.ID,
+		Name:   apiKey.Name,
+		Scopes: apiKey.Scopes,
+		Owner:  apiKey.OwnerID,
+	}
+	now := time.Now()
+	k.mu.Lock()
+	// Remove the expired keys, so the revoked keys don't stay in the cache forever.
+	for hash, cached := range k.cache {
+		if now.After(cached.expiresAt) {
+			delete(k.cache, hash)
+		}
+	}
+	k.cache[hash] = cachedPrincipal{principal: principal, expiresAt: now.Add(apiKeyCacheTTL)}
+	k.mu.Unlock()
+	return principal, nil
+}
+
+// List returns all API keys including the revoked keys, ordered by the creation time.
+func (k *Keys) List(ctx context.Context) ([]APIKey, error) {
+	return k.storage.listAPIKeys(ctx)
+}
+
+// Revoke revokes the API key, the key cannot be used anymore. Revoking a revoked key doesn't change anything.
+func (k *Keys) Revoke(ctx context.Context, id string) error {
+	if err := k.storage.revokeAPIKey(ctx, id, time.Now()); err != nil {
+		return err
+	}
+	// The key is only removed from the cache of this replica, other replicas use the cached key until it expires.
+	k.mu.Lock()
+	for hash, cached := range k.cache {
+		if cached.principal.ID == id {
+			delete(k.cache, hash)
+		}
+	}
+	k.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,101 @@
+// auth authenticates the clients of the ledger service, and checks whether the client has the scope for the request.
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// The scopes of the clients. A client can only do the requests that are allowed by its scopes.
+const (
+	// ScopeRead allows the client to read the accounts, the balances and the transactions.
+	ScopeRead = "read"
+	// ScopeAccountsWrite allows the client to create user accounts and change the shards of the accounts.
+	ScopeAccountsWrite = "accounts:write"
+	// ScopeTransfersWrite allows the client to move money between the accounts.
+	ScopeTransfersWrite = "transfers:write"
+	// ScopeFundingAdmin allows the client to create the funding accounts and debit the accounts that allow negative
+	// balance, as both create money. It also allows the client to create the FX rates.
+	ScopeFundingAdmin = "funding:admin"
+	// ScopeKeysAdmin allows the client to create, list and revoke the API keys.
+	ScopeKeysAdmin = "keys:admin"
+)
+
+// Scopes is the list of all scopes.
+var Scopes = []string{ScopeRead, ScopeAccountsWrite, ScopeTransfersWrite, ScopeFundingAdmin, ScopeKeysAdmin}
+
+var (
+	// ErrUnauthenticated is returned when the client doesn't send valid credentials.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden is returned when the client doesn't have the scope for the request.
+	ErrForbidden      = errors.New("forbidden")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrInvalidAPIKey  = errors.New("invalid api key")
+)
+
+// Principal is the authenticated client of a request.
+type Principal struct {
+	// ID is the id of the API key, or the subject of the token of the client. The id is recorded in the transactions
+	// created by the client.
+	ID     string
+	Name   string
+	Scopes []string
+	// Owner is the owner identity of an end user, or the tenant of an API key. The client with an owner can only access
+	// the accounts of the owner and the accounts that are granted to the owner, while the clients without owner, like the
+	// platform services, can access all accounts.
+	Owner string
+}
+
+// HasScope returns true if the principal has the scope.
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// Authenticator authenticates the client by the bearer token of the request. ErrUnauthenticated is returned if the token
+// is not valid.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (Principal, error)
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of the context with the principal.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal of the context. The context doesn't have a principal if the operation is not
+// requested by a client, for example the background workers of the service.
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// Require returns ErrUnauthenticated if the context doesn't have a principal, or ErrForbidden if the principal doesn't
+// have the scope.
+func Require(ctx context.Context, scope string) error {
+	principal, ok := FromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	if !principal.HasScope(scope) {
+		return fmt.Errorf("%w: scope %s is required", ErrForbidden, scope)
+	}
+	return nil
+}
+
+// validateScopes returns ErrInvalidAPIKey if the scopes are empty or one of the scopes is unknown.
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrInvalidAPIKey)
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return fmt.Errorf("%w: unknown scope %s", ErrInvalidAPIKey, scope)
+		}
+	}
+	return nil
+}
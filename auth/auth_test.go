@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestKeys(t *testing.T) {
+	t.Parallel()
+
+	keys := NewMemoryKeys()
+	key, apiKey, err := keys.Create(context.Background(), "payments", "", []string{ScopeRead, ScopeTransfersWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, apiKeyPrefix) || apiKey.Prefix != key[:apiKeyDisplayLength] {
+		t.Fatalf("unexpected key %s with prefix %s", key, apiKey.Prefix)
+	}
+
+	principal, err := keys.Authenticate(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := Principal{ID: apiKey.ID, Name: "payments", Scopes: []string{ScopeRead, ScopeTransfersWrite}}
+	if diff := cmp.Diff(expect, principal); diff != "" {
+		t.Fatalf("(-want/+got)\n%s", diff)
+	}
+
+	// The list never returns the hash of the key.
+	list, err := keys.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != apiKey.ID || list[0].hash != "" {
+		t.Fatalf("unexpected api keys %+v", list)
+	}
+
+	if err := keys.Revoke(context.Background(), apiKey.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Authenticate(context.Background(), key); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expecting error %v but got %v", ErrUnauthenticated, err)
+	}
+	if err := keys.Revoke(context.Background(), "unknown"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("expecting error %v but got %v", ErrAPIKeyNotFound, err)
+	}
+}
+
+func TestKeysAuthenticateUnknownKey(t *testing.T) {
+	t.Parallel()
+
+	keys := NewMemoryKeys()
+	for _, key := range []string{"", "ldg_unknown", "unknown"} {
+		if _, err := keys.Authenticate(context.Background(), key); !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("%q: expecting error %v but got %v", key, ErrUnauthenticated, err)
+		}
+	}
+}
+
+func TestKeysCreateInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		keyName string
+		scopes  []string
+	}{
+		{name: "empty name", keyName: "", scopes: []string{ScopeRead}},
+		{name: "no scope", keyName: "payments"},
+		{name: "unknown scope", keyName: "payments", scopes: []string{ScopeRead, "admin"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, _, err := NewMemoryKeys().Create(context.Background(), test.keyName, "", test.scopes)
+			if !errors.Is(err, ErrInvalidAPIKey) {
+				t.Fatalf("expecting error %v but got %v", ErrInvalidAPIKey, err)
+			}
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	t.Parallel()
+
+	if err := Require(context.Background(), ScopeRead); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expecting error %v but got %v", ErrUnauthenticated, err)
+	}
+	ctx := WithPrincipal(context.Background(), Principal{ID: "a", Scopes: []string{ScopeRead}})
+	if err := Require(ctx, ScopeRead); err != nil {
+		t.Fatal(err)
+	}
+	if err := Require(ctx, ScopeTransfersWrite); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+}
+
+func TestKeysOwner(t *testing.T) {
+	t.Parallel()
+
+	keys := NewMemoryKeys()
+	key, _, err := keys.Create(context.Background(), "tenant", "tenant-1", []string{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal, err := keys.Authenticate(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.Owner != "tenant-1" {
+		t.Fatalf("expecting owner tenant-1 but got %q", principal.Owner)
+	}
+}
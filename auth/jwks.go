@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/albertwidi/ftest/logging"
+)
+
+const (
+	// jwksMinRefreshInterval is the minimum time between two refreshes of the JWKS that are triggered by an unknown kid, so
+	// the tokens with random kids cannot make the service fetch the JWKS on every request.
+	jwksMinRefreshInterval = 10 * time.Second
+	// jwksRefreshTimeout is the maximum duration of a refresh of the JWKS.
+	jwksRefreshTimeout = 10 * time.Second
+)
+
+// jwk is a JSON Web Key as defined by RFC 7517. Only the public RSA and EC keys are supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// N and E are the modulus and the exponent of the RSA key.
+	N string `json:"n"`
+	E string `json:"e"`
+	// Crv, X and Y are the curve and the coordinates of the EC key.
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a parsed key of the JWKS.
+type publicKey struct {
+	key crypto.PublicKey
+	// alg is the algorithm of the key, the key can be used with all algorithms of its type if it is empty.
+	alg string
+}
+
+// JWKS is a JSON Web Key Set that is loaded from a file or a URL. The keys are loaded again after the refresh interval,
+// or when a token is signed by an unknown key, so the signing keys can be rotated without restarting the service.
+type JWKS struct {
+	source          string
+	refreshInterval time.Duration
+	client          *http.Client
+	// refreshes makes the concurrent requests share one refresh of the JWKS.
+	refreshes singleflight.Group
+
+	mu          sync.Mutex
+	keys        map[string]publicKey
+	refreshedAt time.Time
+}
+
+// LoadJWKS loads the JWKS from the source. The source is a http(s) URL or a path of a file.
+func LoadJWKS(ctx context.Context, source string, refreshInterval time.Duration) (*JWKS, error) {
+	jwks := &JWKS{
+		source:          source,
+		refreshInterval: refreshInterval,
+		client:          &http.Client{Timeout: jwksRefreshTimeout},
+	}
+	if err := jwks.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return jwks, nil
+}
+
+// key returns the key by its kid. The JWKS is refreshed if it is older than the refresh interval, or if the kid is not
+// known as the kid might be a new key. The current keys are still used if the refresh fails.
+//
+// The refresh is done in the background and is shared by the concurrent requests, so a slow JWKS source doesn't block
+// the requests with a known kid. Only the requests with an unknown kid wait for the refresh, until their context is done.
+func (j *JWKS) key(ctx context.Context, kid string) (publicKey, error) {
+	j.mu.Lock()
+	key, ok := j.lookup(kid)
+	age := time.Since(j.refreshedAt)
+	j.mu.Unlock()
+
+	if age >= j.refreshInterval || (!ok && age >= jwksMinRefreshInterval) {
+		refreshed := j.refreshes.DoChan("refresh", func() (any, error) {
+			// The refresh doesn't use the deadline of the request, as a canceled request must not cancel the refresh
+			// for the other requests.
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jwksRefreshTimeout)
+			defer cancel()
+			if err := j.refresh(ctx); err != nil {
+				logging.FromContext(ctx).Warn("failed to refresh jwks", "error", err)
+			}
+			return nil, nil
+		})
+		if !ok {
+			select {
+			case <-refreshed:
+			case <-ctx.Done():
+				return publicKey{}, ctx.Err()
+			}
+			j.mu.Lock()
+			key, ok = j.lookup(kid)
+			j.mu.Unlock()
+		}
+	}
+	if !ok {
+		return publicKey{}, fmt.Errorf("%w: unknown signing key %q", ErrUnauthenticated, kid)
+	}
+	return key, nil
+}
+
+// lookup returns the key by its kid, it must be called with the lock held. A token without kid can only be verified if
+// the JWKS has a single key.
+func (j *JWKS) lookup(kid string) (publicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+// refresh loads the keys from the source. The keys are fetched without holding the lock, and are only swapped in after
+// they are parsed.
+func (j *JWKS) refresh(ctx context.Context) error {
+	// The refresh time is updated even if the refresh fails, so a broken source is not fetched on every request.
+	j.mu.Lock()
+	j.refreshedAt = time.Now()
+	j.mu.Unlock()
+
+	out, err := j.read(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read jwks from %s: %w", j.source, err)
+	}
+	keys, err := parseJWKS(out)
+	if err != nil {
+		return fmt.Errorf("failed to parse jwks from %s: %w", j.source, err)
+	}
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+	return nil
+}
+
+func (j *JWKS) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(j.source, "http://") && !strings.HasPrefix(j.source, "https://") {
+		return os.ReadFile(j.source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseJWKS parses the public signing keys of the JWKS by their kid. The keys for encryption and the keys with unsupported
+// types are ignored, so the JWKS can have other keys.
+func parseJWKS(out []byte) (map[string]publicKey, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(out, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = parseRSAKey(k)
+		case "EC":
+			key, err = parseECKey(k)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = publicKey{key: key, alg: k.Alg}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks doesn't have any signing key")
+	}
+	return keys, nil
+}
+
+func parseRSAKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid rsa modulus or exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+func parseECKey(k jwk) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	var ecdhCurve ecdh.Curve
+	switch k.Crv {
+	case "P-256":
+		curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(x) != size || len(y) != size {
+		return nil, errors.New("invalid ec coordinates")
+	}
+	// The point is validated by parsing it as an uncompressed ECDH public key.
+	if _, err := ecdhCurve.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
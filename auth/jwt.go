@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtMethods are the signing methods that are accepted by the JWTAuthenticator. The HMAC methods are not accepted, as
+// the keys of the JWKS are public.
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// JWTConfig is the configuration of the JWTAuthenticator.
+type JWTConfig struct {
+	// Issuer and Audience must match the iss and aud claims of the token.
+	Issuer   string
+	Audience string
+	// OwnerClaim is the claim that has the owner identity of the end user, the sub claim is used if it is empty.
+	OwnerClaim string
+	// Leeway is the allowed clock skew when validating the exp, nbf and iat claims.
+	Leeway time.Duration
+}
+
+// JWTAuthenticator authenticates the end users by the JWTs issued by the gateway. The tokens are verified with the keys
+// of the JWKS.
+type JWTAuthenticator struct {
+	jwks       *JWKS
+	parser     *jwt.Parser
+	ownerClaim string
+}
+
+func NewJWTAuthenticator(jwks *JWKS, config JWTConfig) (*JWTAuthenticator, error) {
+	if config.Issuer == "" || config.Audience == "" {
+		return nil, errors.New("jwt issuer and audience cannot be empty")
+	}
+	if config.OwnerClaim == "" {
+		config.OwnerClaim = "sub"
+	}
+	return &JWTAuthenticator{
+		jwks: jwks,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(jwtMethods),
+			jwt.WithIssuer(config.Issuer),
+			jwt.WithAudience(config.Audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+			jwt.WithLeeway(config.Leeway),
+		),
+		ownerClaim: config.OwnerClaim,
+	}, nil
+}
+
+// Authenticate verifies the token and returns the end user of the token. The principal has the owner from the owner claim
+// and the scopes from the scope claim, the unknown scopes are ignored.
+func (j *JWTAuthenticator) Authenticate(ctx context.Context, token string) (Principal, error) {
+	// The token that is not a JWT is left for the other authenticators, like the API keys.
+	if strings.Count(token, ".") != 2 {
+		return Principal{}, ErrUnauthenticated
+	}
+	claims := jwt.MapClaims{}
+	_, err := j.parser.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := j.jwks.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.alg != "" && key.alg != token.Method.Alg() {
+			return nil, fmt.Errorf("signing key %q cannot be used with %s", kid, token.Method.Alg())
+		}
+		switch key.key.(type) {
+		case *rsa.PublicKey:
+			if !strings.HasPrefix(token.Method.Alg(), "RS") && !strings.HasPrefix(token.Method.Alg(), "PS") {
+				return nil, fmt.Errorf("rsa signing key %q cannot be used with %s", kid, token.Method.Alg())
+			}
+		case *ecdsa.PublicKey:
+			if !strings.HasPrefix(token.Method.Alg(), "ES") {
+				return nil, fmt.Errorf("ec signing key %q cannot be used with %s", kid, token.Method.Alg())
+			}
+		}
+		return key.key, nil
+	})
+	if err != nil {
+		// The request is gone while waiting for the refresh of the JWKS, so the token is not rejected.
+		if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+			return Principal{}, ctxErr
+		}
+		if errors.Is(err, ErrUnauthenticated) {
+			return Principal{}, err
+		}
+		return Principal{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return Principal{}, fmt.Errorf("%w: token doesn't have the sub claim", ErrUnauthenticated)
+	}
+	owner, _ := claims[j.ownerClaim].(string)
+	if owner == "" {
+		return Principal{}, fmt.Errorf("%w: token doesn't have the %s claim", ErrUnauthenticated, j.ownerClaim)
+	}
+	return Principal{
+		ID:     subject,
+		Name:   subject,
+		Owner:  owner,
+		Scopes: scopesFromClaims(claims),
+	}, nil
+}
+
+// scopesFromClaims returns the known scopes of the space-separated scope claim, or of the scp claim if the token doesn't
+// have the scope claim.
+func scopesFromClaims(claims jwt.MapClaims) []string {
+	var scopes []string
+	switch scp := claims["scp"].(type) {
+	case string:
+		scopes = strings.Fields(scp)
+	case []any:
+		for _, scope := range scp {
+			if scope, ok := scope.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		scopes = strings.Fields(scope)
+	}
+	return slices.DeleteFunc(scopes, func(scope string) bool {
+		return !slices.Contains(Scopes, scope)
+	})
+}
+
+// Chain authenticates the token with the authenticators in order, the principal of the first authenticator that accepts
+// the token is returned.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context, token string) (Principal, error) {
+	// The error with the reason is returned rather than the plain ErrUnauthenticated of the authenticators that don't
+	// recognize the token.
+	reason := ErrUnauthenticated
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(ctx, token)
+		if err == nil {
+			return principal, nil
+		}
+		if !errors.Is(err, ErrUnauthenticated) {
+			return Principal{}, err
+		}
+		if reason == ErrUnauthenticated {
+			reason = err
+		}
+	}
+	return Principal{}, reason
+}
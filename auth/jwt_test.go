@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	testIssuer   = "https://gateway.test"
+	testAudience = "ledger"
+)
+
+// signingKey is a locally generated signing key of the tests, the public key is published in the JWKS.
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    any
+}
+
+func newRSAKey(t *testing.T, kid string) signingKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKey{kid: kid, method: jwt.SigningMethodRS256, key: key}
+}
+
+func newECKey(t *testing.T, kid string) signingKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKey{kid: kid, method: jwt.SigningMethodES256, key: key}
+}
+
+func (s signingKey) jwk() jwk {
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		return jwk{Kty: "RSA", Kid: s.kid, Use: "sig", N: encode(key.N.Bytes()), E: encode(big.NewInt(int64(key.E)).Bytes())}
+	case *ecdsa.PrivateKey:
+		return jwk{Kty: "EC", Kid: s.kid, Crv: "P-256", X: encode(key.X.FillBytes(make([]byte, 32))), Y: encode(key.Y.FillBytes(make([]byte, 32)))}
+	}
+	panic("unsupported key")
+}
+
+func (s signingKey) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(s.method, claims)
+	token.Header["kid"] = s.kid
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func marshalJWKS(t *testing.T, keys ...signingKey) []byte {
+	t.Helper()
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	for _, key := range keys {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+	out, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func validClaims(owner string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   testIssuer,
+		"aud":   testAudience,
+		"sub":   "user-1",
+		"owner": owner,
+		"scope": "read transfers:write unknown",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	t.Parallel()
+
+	rsaKey := newRSAKey(t, "rsa-1")
+	ecKey := newECKey(t, "ec-1")
+	unknownKey := newRSAKey(t, "rsa-1")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, marshalJWKS(t, rsaKey, ecKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := LoadJWKS(context.Background(), path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := NewJWTAuthenticator(jwks, JWTConfig{Issuer: testIssuer, Audience: testAudience, OwnerClaim: "owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withClaim := func(key string, value any) jwt.MapClaims {
+		claims := validClaims("owner-1")
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+	tests := []struct {
+		name   string
+		token  string
+		expect Principal
+		err    error
+	}{
+		{
+			name:   "rsa key",
+			token:  rsaKey.sign(t, validClaims("owner-1")),
+			expect: Principal{ID: "user-1", Name: "user-1", Owner: "owner-1", Scopes: []string{ScopeRead, ScopeTransfersWrite}},
+		},
+		{
+			name:   "ec key",
+			token:  ecKey.sign(t, validClaims("owner-2")),
+			expect: Principal{ID: "user-1", Name: "user-1", Owner: "owner-2", Scopes: []string{ScopeRead, ScopeTransfersWrite}},
+		},
+		{name: "wrong issuer", token: rsaKey.sign(t, withClaim("iss", "https://other.test")), err: ErrUnauthenticated},
+		{name: "wrong audience", token: rsaKey.sign(t, withClaim("aud", "other")), err: ErrUnauthenticated},
+		{name: "expired", token: rsaKey.sign(t, withClaim("exp", time.Now().Add(-time.Minute).Unix())), err: ErrUnauthenticated},
+		{name: "no expiration", token: rsaKey.sign(t, withClaim("exp", nil)), err: ErrUnauthenticated},
+		{name: "no owner", token: rsaKey.sign(t, withClaim("owner", nil)), err: ErrUnauthenticated},
+		{name: "unknown key with known kid", token: unknownKey.sign(t, validClaims("owner-1")), err: ErrUnauthenticated},
+		{name: "unknown kid", token: newRSAKey(t, "rsa-2").sign(t, validClaims("owner-1")), err: ErrUnauthenticated},
+		{name: "not a jwt", token: "ldg_abc", err: ErrUnauthenticated},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			principal, err := authenticator.Authenticate(context.Background(), test.token)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expecting error %v but got %v", test.err, err)
+			}
+			if diff := cmp.Diff(test.expect, principal); diff != "" {
+				t.Fatalf("(-want/+got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticatorNoneAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	key := newRSAKey(t, "rsa-1")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, marshalJWKS(t, key), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := LoadJWKS(context.Background(), path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := NewJWTAuthenticator(jwks, JWTConfig{Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims("owner-1")).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticator.Authenticate(context.Background(), token); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expecting error %v but got %v", ErrUnauthenticated, err)
+	}
+}
+
+// TestJWKSRotation tests whether a token of a new signing key is accepted after the key is published in the JWKS, without
+// waiting for the refresh interval.
+func TestJWKSRotation(t *testing.T) {
+	t.Parallel()
+
+	oldKey := newRSAKey(t, "key-1")
+	newKey := newECKey(t, "key-2")
+	var mu sync.Mutex
+	published := marshalJWKS(t, oldKey)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(published)
+	}))
+	defer server.Close()
+
+	jwks, err := LoadJWKS(context.Background(), server.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := NewJWTAuthenticator(jwks, JWTConfig{Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticator.Authenticate(context.Background(), oldKey.sign(t, validClaims("owner-1"))); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	published = marshalJWKS(t, oldKey, newKey)
+	mu.Unlock()
+	// The JWKS is refreshed by the unknown kid, unless it was just refreshed.
+	jwks.mu.Lock()
+	jwks.refreshedAt = time.Now().Add(-jwksMinRefreshInterval)
+	jwks.mu.Unlock()
+	principal, err := authenticator.Authenticate(context.Background(), newKey.sign(t, validClaims("owner-1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.Owner != "user-1" {
+		t.Fatalf("expecting owner from the sub claim but got %s", principal.Owner)
+	}
+}
+
+// TestJWKSSlowRefresh tests whether a slow JWKS source doesn't block the tokens of the known keys, and a canceled request
+// doesn't cancel the refresh that is shared with the other requests.
+func TestJWKSSlowRefresh(t *testing.T) {
+	t.Parallel()
+
+	oldKey := newRSAKey(t, "key-1")
+	newKey := newECKey(t, "key-2")
+	initial, rotated := marshalJWKS(t, oldKey), marshalJWKS(t, oldKey, newKey)
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) == 1 {
+			w.Write(initial)
+			return
+		}
+		<-release
+		w.Write(rotated)
+	}))
+	defer server.Close()
+
+	jwks, err := LoadJWKS(context.Background(), server.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := NewJWTAuthenticator(jwks, JWTConfig{Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks.mu.Lock()
+	jwks.refreshedAt = time.Now().Add(-2 * time.Hour)
+	jwks.mu.Unlock()
+
+	// The JWKS is older than the refresh interval, the token of the known key is verified while the JWKS is refreshed.
+	if _, err := authenticator.Authenticate(context.Background(), oldKey.sign(t, validClaims("owner-1"))); err != nil {
+		t.Fatal(err)
+	}
+	// The token of the new key waits for the refresh until the request is canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := authenticator.Authenticate(ctx, newKey.sign(t, validClaims("owner-1"))); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expecting error %v but got %v", context.DeadlineExceeded, err)
+	}
+
+	close(release)
+	token := newKey.sign(t, validClaims("owner-1"))
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := authenticator.Authenticate(context.Background(), token); err == nil {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("expecting the new key after the refresh")
+		}
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("expecting the JWKS to be fetched 2 times but got %d", n)
+	}
+}
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+
+	keys := NewMemoryKeys()
+	key, apiKey, err := keys.Create(context.Background(), "payments", "", []string{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing := newRSAKey(t, "rsa-1")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, marshalJWKS(t, signing), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := LoadJWKS(context.Background(), path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := NewJWTAuthenticator(jwks, JWTConfig{Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := Chain{keys, authenticator}
+
+	principal, err := chain.Authenticate(context.Background(), key)
+	if err != nil || principal.ID != apiKey.ID || principal.Owner != "" {
+		t.Fatalf("unexpected principal %+v with error %v", principal, err)
+	}
+	principal, err = chain.Authenticate(context.Background(), signing.sign(t, validClaims("owner-1")))
+	if err != nil || principal.Owner != "user-1" {
+		t.Fatalf("unexpected principal %+v with error %v", principal, err)
+	}
+	if _, err := chain.Authenticate(context.Background(), "unknown"); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expecting error %v but got %v", ErrUnauthenticated, err)
+	}
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgresKeys stores the API keys in the api_keys table.
+type postgresKeys struct {
+	db *sql.DB
+}
+
+func (p *postgresKeys) createAPIKey(ctx context.Context, key APIKey) error {
+	query := `
+		INSERT INTO api_keys(api_key_id, name, key_prefix, key_hash, owner_id, scopes, created_at)
+		VALUES($1,$2,$3,$4,NULLIF($5,''),$6,$7);
+	`
+	_, err := p.db.ExecContext(ctx, query, key.ID, key.Name, key.Prefix, key.hash, key.OwnerID, pq.Array(key.Scopes), key.CreatedAt)
+	return err
+}
+
+func (p *postgresKeys) getAPIKeyByHash(ctx context.Context, hash string) (APIKey, error) {
+	query := `
+		SELECT api_key_id, name, key_prefix, key_hash, COALESCE(owner_id, ''), scopes, created_at, revoked_at
+		FROM api_keys
+		WHERE key_hash = $1;
+	`
+	key := APIKey{}
+	err := p.db.QueryRowContext(ctx, query, hash).Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&key.hash,
+		&key.OwnerID,
+		pq.Array(&key.Scopes),
+		&key.CreatedAt,
+		&key.RevokedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+func (p *postgresKeys) listAPIKeys(ctx context.Context) ([]APIKey, error) {
+	query := `
+		SELECT api_key_id, name, key_prefix, COALESCE(owner_id, ''), scopes, created_at, revoked_at
+		FROM api_keys
+		ORDER BY created_at, api_key_id;
+	`
+	rows, err := p.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key := APIKey{}
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, &key.OwnerID, pq.Array(&key.Scopes), &key.CreatedAt, &key.RevokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (p *postgresKeys) revokeAPIKey(ctx context.Context, id string, revokedAt time.Time) error {
+	query := `
+		UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $2)
+		WHERE api_key_id = $1;
+	`
+	result, err := p.db.ExecContext(ctx, query, id, revokedAt)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// memoryKeys stores the API keys in the memory by their id.
+type memoryKeys struct {
+	mu   sync.RWMutex
+	keys map[string]APIKey
+}
+
+func (m *memoryKeys) createAPIKey(ctx context.Context, key APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key.Scopes = slices.Clone(key.Scopes)
+	m.keys[key.ID] = key
+	return nil
+}
+
+func (m *memoryKeys) getAPIKeyByHash(ctx context.Context, hash string) (APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range m.keys {
+		if key.hash == hash {
+			return key, nil
+		}
+	}
+	return APIKey{}, ErrAPIKeyNotFound
+}
+
+func (m *memoryKeys) listAPIKeys(ctx context.Context) ([]APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]APIKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		key.hash = ""
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (m *memoryKeys) revokeAPIKey(ctx context.Context, id string, revokedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	if !key.RevokedAt.Valid {
+		key.RevokedAt = sql.NullTime{Time: revokedAt, Valid: true}
+		m.keys[id] = key
+	}
+	return nil
+}
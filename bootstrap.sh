@@ -2,14 +2,19 @@
 
 set -e
 
+# The API key must have the accounts:write, transfers:write and funding:admin scopes.
+: "${LEDGER_API_KEY:?LEDGER_API_KEY is required}"
+
 # Create 'test-fund'
 curl \
 	localhost:8080/v1/ledger/account \
 	--request POST \
+	--header "Authorization: Bearer ${LEDGER_API_KEY}" \
 	--data @- << EOF
 {
 	"account_id": "test-fund",
-	"account_type": "funding"
+	"account_type": "funding",
+	"currency": "IDR"
 }
 EOF
 
@@ -17,9 +22,11 @@ EOF
 curl \
 	localhost:8080/v1/ledger/account \
 	--request POST \
+	--header "Authorization: Bearer ${LEDGER_API_KEY}" \
 	--data @- << EOF
 {
-	"account_id": "test-acc-1"
+	"account_id": "test-acc-1",
+	"currency": "IDR"
 }
 EOF
 
@@ -27,9 +34,11 @@ EOF
 curl \
 	localhost:8080/v1/ledger/account \
 	--request POST \
+	--header "Authorization: Bearer ${LEDGER_API_KEY}" \
 	--data @- << EOF
 {
-	"account_id": "test-acc-2"
+	"account_id": "test-acc-2",
+	"currency": "IDR"
 }
 EOF
 
@@ -37,6 +46,8 @@ EOF
 curl \
 	localhost:8080/v1/ledger/transfer \
 	--request POST \
+	--header "Authorization: Bearer ${LEDGER_API_KEY}" \
+	--header 'Idempotency-Key: bootstrap-test-acc-1' \
 	--data @- << EOF
 {
 	"from_account": "test-fund",
@@ -49,10 +60,12 @@ EOF
 curl \
 	localhost:8080/v1/ledger/transfer \
 	--request POST \
+	--header "Authorization: Bearer ${LEDGER_API_KEY}" \
+	--header 'Idempotency-Key: bootstrap-test-acc-2' \
 	--data @- << EOF
 {
 	"from_account": "test-fund",
-	"to_account": "test-acc-1",
+	"to_account": "test-acc-2",
 	"amount": "20000"
 }
 EOF
\ No newline at end of file
@@ -0,0 +1,14 @@
+// database contains the schema of the databases used by the service.
+
+package database
+
+import "embed"
+
+// LedgerMigrations is the versioned migrations of the ledger database. The migrations are embedded into the binary, so
+// the service can migrate the database without the SQL files.
+//
+//go:embed ledger/migrations/*.sql
+var LedgerMigrations embed.FS
+
+// LedgerMigrationsDir is the directory of the ledger migrations inside LedgerMigrations.
+const LedgerMigrationsDir = "ledger/migrations"
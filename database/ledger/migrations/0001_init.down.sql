@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS fx_rates;
+DROP TABLE IF EXISTS holds;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS accounts_ledger;
+DROP TABLE IF EXISTS accounts_balance;
+DROP TABLE IF EXISTS transaction;
+DROP TABLE IF EXISTS accounts;
+
+DROP TYPE IF EXISTS hold_status;
+DROP TYPE IF EXISTS account_type;
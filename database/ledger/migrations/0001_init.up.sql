@@ -0,0 +1,151 @@
+-- The migration can be applied to the database that was created by the old schema.sql. The types and the tables are
+-- only created if they don't exist, and the values and the columns that were added after the old schema are added at
+-- the end of the migration.
+
+-- types.
+DO $$ BEGIN
+	CREATE TYPE account_type AS ENUM('user','funding','fx_position');
+EXCEPTION
+	WHEN duplicate_object THEN NULL;
+END $$;
+DO $$ BEGIN
+	CREATE TYPE hold_status AS ENUM('authorized','captured','voided');
+EXCEPTION
+	WHEN duplicate_object THEN NULL;
+END $$;
+
+-- accounts is used to store all user accounts.
+CREATE TABLE IF NOT EXISTS accounts(
+	"account_id" VARCHAR PRIMARY KEY,
+	"account_type" account_type NOT NULL,
+	-- currency is the ISO 4217 currency code of the account. An account can only hold money in one currency.
+	"currency" VARCHAR(3) NOT NULL,
+	"created_at" TIMESTAMPTZ NOT NULL,
+	"updated_at" TIMESTAMPTZ
+);
+
+-- transaction is used to store all transaction records.
+CREATE TABLE IF NOT EXISTS transaction(
+	"transaction_id" VARCHAR PRIMARY KEY,
+	-- transaction_type is the type of the transaction, for example 'transfer', 'posting', 'capture' and 'reversal'.
+	"transaction_type" VARCHAR NOT NULL,
+	"amount" NUMERIC NOT NULL,
+	-- currency is the currency of the amount. For a transaction with legs in multiple currencies, it is the currency of the first leg.
+	"currency" VARCHAR(3) NOT NULL,
+	-- reversal_of links the reversal transaction to the original transaction that it reverses.
+	"reversal_of" VARCHAR,
+	-- reversed_amount is the total amount of the reversals of the transaction. It cannot exceed the amount of the transaction.
+	"reversed_amount" NUMERIC NOT NULL DEFAULT 0,
+	-- fx_rate and fx_rate_id are the executed rate of a conversion transaction, so the conversion can be audited later.
+	"fx_rate" NUMERIC,
+	"fx_rate_id" VARCHAR,
+	"created_at" TIMESTAMPTZ NOT NULL,
+	"updated_at" TIMESTAMPTZ
+);
+
+-- accounts_balance is used to store the latest state of user's balance. This table will be used for user
+-- balance fast retrieval and for locking the user balance for transaction.
+CREATE TABLE IF NOT EXISTS accounts_balance(
+	"account_id" VARCHAR PRIMARY KEY,
+	-- allow_negative allows some accounts to have negative balance. For example, for the funding
+	-- account we might allow the account to have negative balance.
+	"allow_negative" BOOLEAN NOT NULL,
+	-- currency is the same with the currency of the account, it is stored here so it can be checked while locking the balance.
+	"currency" VARCHAR(3) NOT NULL,
+	"balance" NUMERIC NOT NULL,
+	-- held_balance is the total amount of active holds. The available balance of the account is balance - held_balance.
+	"held_balance" NUMERIC NOT NULL DEFAULT 0,
+	"last_transaction_id" VARCHAR NOT NULL,
+	"created_at" TIMESTAMPTZ NOT NULL,
+	"updated_at" TIMESTAMPTZ
+);
+
+-- accounts_ledger is used to store all ledger changes for a specific account. A single transaction
+-- can possibly affecting multiple acounts in the ledger.
+--
+-- Row in this table is immutable and should not be updated.
+CREATE TABLE IF NOT EXISTS accounts_ledger(
+	"transaction_id" VARCHAR NOT NULL,
+	"account_id" VARCHAR NOT NULL,
+	"amount" NUMERIC NOT NULL,
+	"currency" VARCHAR(3) NOT NULL,
+	"current_balance" NUMERIC NOT NULL,
+	"previous_balance" NUMERIC NOT NULL,
+	"created_at" TIMESTAMPTZ NOT NULL,
+	"timestamp" BIGINT NOT NULL,
+	-- the primary key of accounts_ledger is a composite of 'transaction_id' and 'account_id'.
+	-- This is because we are recording balance changes for different-different account in a single transaction.
+	PRIMARY KEY("transaction_id", "account_id")
+);
+-- accounts_ledger_account_id_timestamp_idx is used to paginate the ledger entries of an account with (timestamp, transaction_id) cursor.
+CREATE INDEX IF NOT EXISTS accounts_ledger_account_id_timestamp_idx ON accounts_ledger("account_id", "timestamp", "transaction_id");
+
+
+-- idempotency_keys stores the idempotency key sent by the client along with the fingerprint of the request. The key
+-- is inserted in the same database transaction as the transaction it creates, so a replayed request can return the
+-- original transaction_id instead of moving the money twice.
+CREATE TABLE IF NOT EXISTS idempotency_keys(
+	"idempotency_key" VARCHAR PRIMARY KEY,
+	-- request_hash is the fingerprint of the request. A request with the same key but different hash is rejected.
+	"request_hash" VARCHAR NOT NULL,
+	"transaction_id" VARCHAR NOT NULL,
+	"created_at" TIMESTAMPTZ NOT NULL
+);
+
+-- holds is used to store the authorization of two-phase transfers. An active hold reserves the money in the from_account
+-- by adding its amount to the held_balance, without creating any ledger entries. The ledger entries are created when
+-- the hold is captured.
+CREATE TABLE IF NOT EXISTS holds(
+	"hold_id" VARCHAR PRIMARY KEY,
+	"from_account" VARCHAR NOT NULL,
+	"to_account" VARCHAR NOT NULL,
+	"amount" NUMERIC NOT NULL,
+	"currency" VARCHAR(3) NOT NULL,
+	-- captured_amount is the total amount captured from the hold. A hold can be captured partially multiple times.
+	"captured_amount" NUMERIC NOT NULL,
+	"status" hold_status NOT NULL,
+	"created_at" TIMESTAMPTZ NOT NULL,
+	"updated_at" TIMESTAMPTZ
+);
+
+-- fx_rates stores the conversion rates used by the conversion transactions, 1 base_currency = rate quote_currency. A rate
+-- is valid from valid_from(inclusive) until valid_to(exclusive), and a null valid_to means the rate is valid until it is
+-- replaced by a rate with newer valid_from. The rates are never updated so the executed rate of a transaction can be audited.
+CREATE TABLE IF NOT EXISTS fx_rates(
+	"rate_id" VARCHAR PRIMARY KEY,
+	"base_currency" VARCHAR(3) NOT NULL,
+	"quote_currency" VARCHAR(3) NOT NULL,
+	"rate" NUMERIC NOT NULL,
+	"valid_from" TIMESTAMPTZ NOT NULL,
+	"valid_to" TIMESTAMPTZ,
+	"created_at" TIMESTAMPTZ NOT NULL
+);
+-- fx_rates_currencies_valid_from_idx is used to find the effective rate of a currency pair.
+CREATE INDEX IF NOT EXISTS fx_rates_currencies_valid_from_idx ON fx_rates("base_currency", "quote_currency", "valid_from");
+
+-- upgrade the database that was created by the old schema.sql. The statements are no-op for a new database.
+--
+-- fx_position is added to the existing account_type, the value is not used by this migration so it can be added in the
+-- same transaction.
+ALTER TYPE account_type ADD VALUE IF NOT EXISTS 'fx_position';
+
+-- The old records were created before the multi-currency support, so they are in the default currency. The defaults are
+-- only used to fill the existing rows and dropped afterwards, so the columns are the same with the new database.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS "currency" VARCHAR(3) NOT NULL DEFAULT 'IDR';
+ALTER TABLE accounts ALTER COLUMN "currency" DROP DEFAULT;
+
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS "transaction_type" VARCHAR NOT NULL DEFAULT 'transfer';
+ALTER TABLE transaction ALTER COLUMN "transaction_type" DROP DEFAULT;
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS "currency" VARCHAR(3) NOT NULL DEFAULT 'IDR';
+ALTER TABLE transaction ALTER COLUMN "currency" DROP DEFAULT;
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS "reversal_of" VARCHAR;
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS "reversed_amount" NUMERIC NOT NULL DEFAULT 0;
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS "fx_rate" NUMERIC;
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS "fx_rate_id" VARCHAR;
+
+ALTER TABLE accounts_balance ADD COLUMN IF NOT EXISTS "currency" VARCHAR(3) NOT NULL DEFAULT 'IDR';
+ALTER TABLE accounts_balance ALTER COLUMN "currency" DROP DEFAULT;
+ALTER TABLE accounts_balance ADD COLUMN IF NOT EXISTS "held_balance" NUMERIC NOT NULL DEFAULT 0;
+
+ALTER TABLE accounts_ledger ADD COLUMN IF NOT EXISTS "currency" VARCHAR(3) NOT NULL DEFAULT 'IDR';
+ALTER TABLE accounts_ledger ALTER COLUMN "currency" DROP DEFAULT;
@@ -0,0 +1,12 @@
+-- Move the balance of the sub-balances back to the accounts before dropping them, so no money is lost.
+UPDATE accounts_balance AS ab SET
+	balance = ab.balance + s.balance
+FROM (
+	SELECT account_id, SUM(balance) AS balance FROM accounts_balance_shards GROUP BY account_id
+) AS s
+WHERE ab.account_id = s.account_id;
+
+DROP TABLE IF EXISTS accounts_balance_shards;
+
+ALTER TABLE accounts_balance DROP COLUMN IF EXISTS shard_strategy;
+ALTER TABLE accounts_balance DROP COLUMN IF EXISTS shards;
@@ -0,0 +1,18 @@
+-- shards is the number of sub-balances of a hot account. Zero means the account is not sharded and the whole balance of the
+-- account is stored in accounts_balance. The balance of a sharded account is accounts_balance.balance plus the SUM of the
+-- balance of its sub-balances, so the account still looks like one account to the client.
+ALTER TABLE accounts_balance ADD COLUMN IF NOT EXISTS "shards" INT NOT NULL DEFAULT 0;
+-- shard_strategy is how the sub-balance is chosen for a posting, either 'random' or 'round_robin'.
+ALTER TABLE accounts_balance ADD COLUMN IF NOT EXISTS "shard_strategy" VARCHAR NOT NULL DEFAULT 'random';
+
+-- accounts_balance_shards stores the sub-balances of the sharded accounts. A posting to a sharded account only locks one
+-- of the sub-balances instead of the accounts_balance row, so the postings to the same account can be done concurrently.
+CREATE TABLE IF NOT EXISTS accounts_balance_shards(
+	"account_id" VARCHAR NOT NULL,
+	"shard" INT NOT NULL,
+	"balance" NUMERIC NOT NULL,
+	"last_transaction_id" VARCHAR NOT NULL,
+	"created_at" TIMESTAMPTZ NOT NULL,
+	"updated_at" TIMESTAMPTZ,
+	PRIMARY KEY("account_id", "shard")
+);
@@ -0,0 +1,4 @@
+DROP FUNCTION IF EXISTS ledger_create_transaction(
+	VARCHAR, VARCHAR, NUMERIC, VARCHAR, VARCHAR, NUMERIC, VARCHAR, TIMESTAMPTZ, VARCHAR, VARCHAR,
+	VARCHAR[], NUMERIC[], VARCHAR[], TIMESTAMPTZ[], BIGINT[]
+);
@@ -0,0 +1,167 @@
+-- ledger_create_transaction validates and creates a transaction in one call, so the transaction only takes one round trip to
+-- the database. The function follows the same steps with the CreateTransaction in Go:
+-- 1. Insert the idempotency key if the key is not empty.
+-- 2. Lock all affected accounts in the order of the account_id, and check the currency and the balance of the accounts.
+-- 3. Insert the transaction record.
+-- 4. Update the balance of the accounts, or one of the sub-balances of the sharded accounts.
+-- 5. Insert all ledger entries.
+--
+-- The ledger entries are passed as arrays with the same length, the n-th element of every array is the n-th entry. The
+-- errors are raised with the custom SQLSTATE below, so the caller can map them back into the ledger errors:
+-- LDG01 insufficient balance, LDG02 currency mismatch, LDG03 account not found and LDG04 duplicate idempotency key.
+--
+-- The sub-balance of a sharded account is always chosen randomly, because the round-robin order is kept by the service.
+CREATE OR REPLACE FUNCTION ledger_create_transaction(
+	p_transaction_id VARCHAR,
+	p_transaction_type VARCHAR,
+	p_amount NUMERIC,
+	p_currency VARCHAR,
+	p_reversal_of VARCHAR,
+	p_fx_rate NUMERIC,
+	p_fx_rate_id VARCHAR,
+	p_created_at TIMESTAMPTZ,
+	p_idempotency_key VARCHAR,
+	p_request_hash VARCHAR,
+	p_entry_accounts VARCHAR[],
+	p_entry_amounts NUMERIC[],
+	p_entry_currencies VARCHAR[],
+	p_entry_created_at TIMESTAMPTZ[],
+	p_entry_timestamps BIGINT[]
+) RETURNS VOID AS $$
+DECLARE
+	v_accounts VARCHAR[];
+	v_debits VARCHAR[];
+	v_locked accounts_balance[] := '{}';
+	v_locked_accounts VARCHAR[] := '{}';
+	v_balance accounts_balance;
+	v_amount NUMERIC;
+	v_currency VARCHAR;
+	v_total NUMERIC;
+	v_shard INT;
+	v_shard_balance NUMERIC;
+	-- v_previous_accounts and v_previous_balances are the balances of the accounts before the transaction, they are
+	-- used to calculate the balances of the ledger entries.
+	v_previous_accounts VARCHAR[] := '{}';
+	v_previous_balances NUMERIC[] := '{}';
+	v_update_accounts VARCHAR[] := '{}';
+	v_update_balances NUMERIC[] := '{}';
+BEGIN
+	IF p_idempotency_key <> '' THEN
+		INSERT INTO idempotency_keys(idempotency_key, request_hash, transaction_id, created_at)
+		VALUES(p_idempotency_key, p_request_hash, p_transaction_id, p_created_at)
+		ON CONFLICT DO NOTHING;
+		IF NOT FOUND THEN
+			RAISE EXCEPTION 'duplicate idempotency key %', p_idempotency_key USING ERRCODE = 'LDG04';
+		END IF;
+	END IF;
+
+	SELECT array_agg(DISTINCT e.account_id ORDER BY e.account_id) INTO v_accounts
+	FROM unnest(p_entry_accounts) AS e(account_id);
+	SELECT COALESCE(array_agg(s.account_id), '{}') INTO v_debits
+	FROM (
+		SELECT e.account_id FROM unnest(p_entry_accounts, p_entry_amounts) AS e(account_id, amount)
+		GROUP BY e.account_id HAVING SUM(e.amount) < 0
+	) AS s;
+
+	-- Lock the accounts exclusively, except the sharded accounts that don't need the total balance to be checked. The
+	-- sharded accounts are locked with FOR KEY SHARE, so they cannot be resharded or rebalanced during the transaction.
+	FOR v_balance IN
+		SELECT * FROM accounts_balance
+		WHERE account_id = ANY(v_accounts) AND (shards = 0 OR (NOT allow_negative AND account_id = ANY(v_debits)))
+		ORDER BY account_id
+		FOR UPDATE
+	LOOP
+		v_locked := array_append(v_locked, v_balance);
+		v_locked_accounts := array_append(v_locked_accounts, v_balance.account_id);
+	END LOOP;
+	FOR v_balance IN
+		SELECT * FROM accounts_balance
+		WHERE account_id = ANY(v_accounts) AND NOT (account_id = ANY(v_locked_accounts))
+		ORDER BY account_id
+		FOR KEY SHARE
+	LOOP
+		-- The account was sharded when it was skipped by the first query, but it is no longer sharded now.
+		IF v_balance.shards = 0 THEN
+			RAISE EXCEPTION 'shards of account_id % changed', v_balance.account_id USING ERRCODE = 'serialization_failure';
+		END IF;
+		v_locked := array_append(v_locked, v_balance);
+		v_locked_accounts := array_append(v_locked_accounts, v_balance.account_id);
+	END LOOP;
+
+	IF cardinality(v_locked) < cardinality(v_accounts) THEN
+		RAISE EXCEPTION 'account_id %', (
+			SELECT a.account_id FROM unnest(v_accounts) AS a(account_id)
+			WHERE NOT (a.account_id = ANY(v_locked_accounts))
+			ORDER BY a.account_id
+			LIMIT 1
+		) USING ERRCODE = 'LDG03';
+	END IF;
+
+	-- Check the currency of all accounts first, so the currency mismatch is always reported regardless of the order of the accounts.
+	FOREACH v_balance IN ARRAY v_locked LOOP
+		SELECT e.currency INTO v_currency FROM unnest(p_entry_accounts, p_entry_currencies) AS e(account_id, currency)
+		WHERE e.account_id = v_balance.account_id LIMIT 1;
+		IF v_currency <> v_balance.currency THEN
+			RAISE EXCEPTION 'account_id % is in %, got %', v_balance.account_id, v_balance.currency, v_currency USING ERRCODE = 'LDG02';
+		END IF;
+	END LOOP;
+
+	FOREACH v_balance IN ARRAY v_locked LOOP
+		SELECT SUM(e.amount) INTO v_amount FROM unnest(p_entry_accounts, p_entry_amounts) AS e(account_id, amount)
+		WHERE e.account_id = v_balance.account_id;
+
+		IF v_balance.shards = 0 THEN
+			IF v_balance.balance + v_amount - v_balance.held_balance < 0 AND NOT v_balance.allow_negative THEN
+				RAISE EXCEPTION 'account_id % doesn''t have enough balance for this transaction', v_balance.account_id USING ERRCODE = 'LDG01';
+			END IF;
+			v_update_accounts := array_append(v_update_accounts, v_balance.account_id);
+			v_update_balances := array_append(v_update_balances, v_balance.balance + v_amount);
+			v_previous_accounts := array_append(v_previous_accounts, v_balance.account_id);
+			v_previous_balances := array_append(v_previous_balances, v_balance.balance);
+			CONTINUE;
+		END IF;
+
+		-- The total balance of a sharded account is only checked for the debit, because only then the account is locked exclusively.
+		IF v_amount < 0 AND NOT v_balance.allow_negative THEN
+			SELECT v_balance.balance + COALESCE(SUM(balance), 0) INTO v_total FROM accounts_balance_shards WHERE account_id = v_balance.account_id;
+			IF v_total + v_amount - v_balance.held_balance < 0 THEN
+				RAISE EXCEPTION 'account_id % doesn''t have enough balance for this transaction', v_balance.account_id USING ERRCODE = 'LDG01';
+			END IF;
+		END IF;
+		v_shard := floor(random() * v_balance.shards);
+		UPDATE accounts_balance_shards SET
+			balance = balance + v_amount,
+			last_transaction_id = p_transaction_id,
+			updated_at = p_created_at
+		WHERE account_id = v_balance.account_id AND shard = v_shard
+		RETURNING balance INTO v_shard_balance;
+		v_previous_accounts := array_append(v_previous_accounts, v_balance.account_id);
+		v_previous_balances := array_append(v_previous_balances, v_shard_balance - v_amount);
+	END LOOP;
+
+	INSERT INTO transaction(transaction_id, transaction_type, amount, currency, reversal_of, fx_rate, fx_rate_id, created_at)
+	VALUES(p_transaction_id, p_transaction_type, p_amount, p_currency, NULLIF(p_reversal_of, ''), p_fx_rate, NULLIF(p_fx_rate_id, ''), p_created_at);
+
+	UPDATE accounts_balance AS ab SET
+		balance = v.balance,
+		last_transaction_id = p_transaction_id,
+		updated_at = p_created_at
+	FROM unnest(v_update_accounts, v_update_balances) AS v(account_id, balance)
+	WHERE ab.account_id = v.account_id;
+
+	INSERT INTO accounts_ledger(transaction_id, account_id, amount, currency, current_balance, previous_balance, created_at, timestamp)
+	SELECT
+		p_transaction_id,
+		e.account_id,
+		e.amount,
+		e.currency,
+		p.balance + SUM(e.amount) OVER w,
+		p.balance + SUM(e.amount) OVER w - e.amount,
+		e.created_at,
+		e.timestamp
+	FROM unnest(p_entry_accounts, p_entry_amounts, p_entry_currencies, p_entry_created_at, p_entry_timestamps)
+		WITH ORDINALITY AS e(account_id, amount, currency, created_at, timestamp, ord)
+	JOIN unnest(v_previous_accounts, v_previous_balances) AS p(account_id, balance) ON p.account_id = e.account_id
+	WINDOW w AS (PARTITION BY e.account_id ORDER BY e.ord);
+END;
+$$ LANGUAGE plpgsql;
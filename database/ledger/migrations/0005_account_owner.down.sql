@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS accounts_owner_id_idx;
+ALTER TABLE accounts DROP COLUMN IF EXISTS owner_id;
@@ -0,0 +1,5 @@
+-- owner_id is the owner identity of the end user that owns the account, it is taken from the token of the end user that
+-- creates the account. The end users can only access the accounts they own. The accounts that are created by the
+-- services don't have an owner.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS "owner_id" VARCHAR;
+CREATE INDEX IF NOT EXISTS accounts_owner_id_idx ON accounts("owner_id");
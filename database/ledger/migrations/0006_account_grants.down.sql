@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS account_grants;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS owner_id;
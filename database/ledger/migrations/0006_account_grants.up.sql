@@ -0,0 +1,18 @@
+-- owner_id binds the API key to an owner, so the key can only access the accounts of the owner. The keys without owner,
+-- like the keys of the platform services, can access all accounts.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS "owner_id" VARCHAR;
+
+-- account_grants stores the delegate access to the accounts. The grantee can access the account as if it is the owner of
+-- the account, but cannot grant the access to others.
+CREATE TABLE IF NOT EXISTS account_grants(
+	"account_id" VARCHAR NOT NULL,
+	-- grantee_id is the owner identity that is granted the access to the account.
+	"grantee_id" VARCHAR NOT NULL,
+	-- created_by is the id of the client that granted the access.
+	"created_by" VARCHAR,
+	"created_at" TIMESTAMPTZ NOT NULL,
+	-- revoked_at is set when the access is revoked, the grant is kept for the audit. Granting the access again clears
+	-- revoked_at.
+	"revoked_at" TIMESTAMPTZ,
+	PRIMARY KEY("account_id", "grantee_id")
+);
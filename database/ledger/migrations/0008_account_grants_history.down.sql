@@ -0,0 +1,7 @@
+-- Only the latest grant of every account and grantee is kept, the history of the older grants is lost.
+DELETE FROM account_grants AS older USING account_grants AS newer
+WHERE older.account_id = newer.account_id AND older.grantee_id = newer.grantee_id AND older.grant_id < newer.grant_id;
+DROP INDEX IF EXISTS account_grants_active_idx;
+ALTER TABLE account_grants DROP CONSTRAINT IF EXISTS account_grants_pkey;
+ALTER TABLE account_grants DROP COLUMN IF EXISTS "grant_id";
+ALTER TABLE account_grants ADD PRIMARY KEY ("account_id", "grantee_id");
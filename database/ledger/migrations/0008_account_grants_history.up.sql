@@ -0,0 +1,7 @@
+-- The grants keep their history. Granting the revoked access again inserts a new grant instead of clearing revoked_at of
+-- the revoked grant, so the revocation is never overwritten. Every grant has its own grant_id, and an account can only
+-- have one active grant per grantee.
+ALTER TABLE account_grants ADD COLUMN IF NOT EXISTS "grant_id" BIGSERIAL;
+ALTER TABLE account_grants DROP CONSTRAINT IF EXISTS account_grants_pkey;
+ALTER TABLE account_grants ADD PRIMARY KEY ("grant_id");
+CREATE UNIQUE INDEX IF NOT EXISTS account_grants_active_idx ON account_grants("account_id", "grantee_id") WHERE revoked_at IS NULL;
@@ -0,0 +1,318 @@
+// migrate applies the versioned migrations to Postgres.
+//
+// A migration is a pair of {version}_{name}.up.sql and {version}_{name}.down.sql files. The migrations are applied in
+// the order of the version, and every applied migration is recorded in the schema_migrations table along with the
+// checksum of its up file, so a migration that is changed after it is applied is detected instead of silently ignored.
+//
+// All operations hold a Postgres advisory lock, so multiple instances of the service can migrate the same database
+// at the same time and only one of them applies the migrations.
+
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidFileName  = errors.New("invalid migration file name")
+	ErrDuplicateVersion = errors.New("duplicate migration version")
+	ErrMissingFile      = errors.New("migration must have both up and down file")
+	// ErrChecksumMismatch returned when the up file of an applied migration is changed. An applied migration must never
+	// be changed, a new migration should be added instead.
+	ErrChecksumMismatch = errors.New("checksum of the applied migration does not match")
+	// ErrUnknownVersion returned when the database has an applied version that doesn't have the migration files. This
+	// usually happens when an older version of the service runs against a newer database.
+	ErrUnknownVersion = errors.New("applied migration version is unknown")
+)
+
+// lockKey is the key of the advisory lock used by the migration. The key is an arbitrary number, it only needs to be
+// unique among the advisory locks used in the database.
+const lockKey int64 = 8_417_320_511
+
+// fileNameRegexp matches {version}_{name}.{up|down}.sql, for example 0001_init.up.sql.
+var fileNameRegexp = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Checksum is the sha256 of the up file.
+	Checksum string
+}
+
+// Status is the status of a migration in the database.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type appliedMigration struct {
+	version   int64
+	checksum  string
+	appliedAt time.Time
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New loads the migrations from the dir inside fsys. The function returns error if any of the migrations is invalid.
+func New(db *sql.DB, fsys fs.FS, dir string) (*Migrator, error) {
+	migrations, err := load(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+// load reads all migrations inside the dir and returns them ordered by the version.
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		matches := fileNameRegexp.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidFileName, entry.Name())
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidFileName, entry.Name())
+		}
+		out, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != matches[2] {
+			return nil, fmt.Errorf("%w: %d is used by %s and %s", ErrDuplicateVersion, version, migration.Name, matches[2])
+		}
+		switch matches[3] {
+		case "up":
+			migration.Up = string(out)
+			sum := sha256.Sum256(out)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		case "down":
+			migration.Down = string(out)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("%w: %d_%s", ErrMissingFile, migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up applies all pending migrations and returns the applied migrations. Every migration is applied in its own
+// transaction, so a failed migration doesn't leave the schema half applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var migrated []Migration
+	err := m.withLock(ctx, func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := m.verify(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := apply(ctx, conn, migration.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx,
+					"INSERT INTO schema_migrations(version, name, checksum, applied_at) VALUES($1,$2,$3,$4);",
+					migration.Version, migration.Name, migration.Checksum, time.Now(),
+				)
+				return err
+			}); err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			migrated = append(migrated, migration)
+		}
+		return nil
+	})
+	return migrated, err
+}
+
+// Down rolls back the latest applied migrations up to the number of steps, and returns the rolled back migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var migrated []Migration
+	err := m.withLock(ctx, func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := m.verify(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(migrated) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err := apply(ctx, conn, migration.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1;", migration.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("failed to roll back migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			migrated = append(migrated, migration)
+		}
+		return nil
+	})
+	return migrated, err
+}
+
+// Status returns the status of all migrations ordered by the version.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := getApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			status := Status{Migration: migration}
+			if a, ok := applied[migration.Version]; ok {
+				status.Applied = true
+				status.AppliedAt = a.appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Latest returns the version of the latest migration, zero if there is no migration.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the latest applied version in the database, zero if no migration is applied. Unlike the other
+// operations, Version doesn't hold the advisory lock, so it can be called frequently, for example by the readiness
+// check, without waiting for the migrations that are being applied by other instance.
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	var version int64
+	err := m.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations;").Scan(&version)
+	return version, err
+}
+
+// withLock holds the advisory lock while running fn. The advisory lock belongs to the database session, so the lock
+// and all the queries must use the same connection.
+func (m *Migrator) withLock(ctx context.Context, fn func(ctx context.Context, conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1);", lockKey); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, errUnlock := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1);", lockKey); errUnlock != nil {
+			err = errors.Join(err, errUnlock)
+		}
+	}()
+
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations(
+			"version" BIGINT PRIMARY KEY,
+			"name" VARCHAR NOT NULL,
+			"checksum" VARCHAR NOT NULL,
+			"applied_at" TIMESTAMPTZ NOT NULL
+		);
+	`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	return fn(ctx, conn)
+}
+
+// verify ensures all applied migrations are known and not changed after they are applied.
+func (m *Migrator) verify(ctx context.Context, conn *sql.Conn) (map[int64]appliedMigration, error) {
+	applied, err := getApplied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[int64]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+	for version, a := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+		if migration.Checksum != a.checksum {
+			return nil, fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, version, migration.Name)
+		}
+	}
+	return applied, nil
+}
+
+func getApplied(ctx context.Context, conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, checksum, applied_at FROM schema_migrations;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		a := appliedMigration{}
+		if err := rows.Scan(&a.version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[a.version] = a
+	}
+	return applied, rows.Err()
+}
+
+// apply executes the query and records the migration with fn inside the same transaction.
+func apply(ctx context.Context, conn *sql.Conn, query string, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	if err := fn(tx); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,161 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/albertwidi/ftest/database"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name   string
+		fsys   fstest.MapFS
+		expect []int64
+		err    error
+	}{
+		{
+			name: "ordered by version",
+			fsys: fstest.MapFS{
+				"migrations/0010_second.up.sql":   {Data: []byte("SELECT 2;")},
+				"migrations/0010_second.down.sql": {Data: []byte("SELECT 2;")},
+				"migrations/0002_first.up.sql":    {Data: []byte("SELECT 1;")},
+				"migrations/0002_first.down.sql":  {Data: []byte("SELECT 1;")},
+			},
+			expect: []int64{2, 10},
+		},
+		{
+			name: "invalid file name",
+			fsys: fstest.MapFS{
+				"migrations/init.sql": {Data: []byte("SELECT 1;")},
+			},
+			err: ErrInvalidFileName,
+		},
+		{
+			name: "missing down file",
+			fsys: fstest.MapFS{
+				"migrations/0001_init.up.sql": {Data: []byte("SELECT 1;")},
+			},
+			err: ErrMissingFile,
+		},
+		{
+			name: "duplicate version",
+			fsys: fstest.MapFS{
+				"migrations/0001_init.up.sql":    {Data: []byte("SELECT 1;")},
+				"migrations/0001_init.down.sql":  {Data: []byte("SELECT 1;")},
+				"migrations/0001_other.up.sql":   {Data: []byte("SELECT 1;")},
+				"migrations/0001_other.down.sql": {Data: []byte("SELECT 1;")},
+			},
+			err: ErrDuplicateVersion,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			migrations, err := load(test.fsys, "migrations")
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expecting error %v but got %v", test.err, err)
+			}
+			if len(migrations) != len(test.expect) {
+				t.Fatalf("expecting %d migrations but got %d", len(test.expect), len(migrations))
+			}
+			for idx, migration := range migrations {
+				if migration.Version != test.expect[idx] {
+					t.Fatalf("expecting version %d but got %d", test.expect[idx], migration.Version)
+				}
+				if migration.Checksum == "" {
+					t.Fatal("expecting checksum to be calculated")
+				}
+			}
+		})
+	}
+}
+
+// TestLedgerMigrations ensures the embedded migrations of the ledger database are valid.
+func TestLedgerMigrations(t *testing.T) {
+	migrations, err := load(database.LedgerMigrations, database.LedgerMigrationsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("expecting the ledger migrations to be embedded")
+	}
+}
+
+// TestUpConcurrently tests whether the migrations are only applied once when multiple instances of the service migrate
+// the database at the same time.
+func TestUpConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	var total int
+	var errs []error
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			migrator, err := New(testDB, database.LedgerMigrations, database.LedgerMigrationsDir)
+			if err == nil {
+				var migrations []Migration
+				migrations, err = migrator.Up(context.Background())
+				mu.Lock()
+				total += len(migrations)
+				mu.Unlock()
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		t.Fatal(errors.Join(errs...))
+	}
+
+	migrator, err := New(testDB, database.LedgerMigrations, database.LedgerMigrationsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := migrator.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Fatalf("expecting migration %d_%s to be applied", status.Version, status.Name)
+		}
+	}
+	if total > len(statuses) {
+		t.Fatalf("expecting at most %d migrations applied but got %d", len(statuses), total)
+	}
+	version, err := migrator.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != migrator.Latest() {
+		t.Fatalf("expecting version %d but got %d", migrator.Latest(), version)
+	}
+}
+
+// TestChecksumMismatch tests whether the changed migration is rejected after it is applied.
+func TestChecksumMismatch(t *testing.T) {
+	migrator, err := New(testDB, database.LedgerMigrations, database.LedgerMigrationsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the content of the first migration.
+	migrator.migrations[0].Checksum = "changed"
+	if _, err := migrator.Up(context.Background()); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expecting error %v but got %v", ErrChecksumMismatch, err)
+	}
+}
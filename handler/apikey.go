@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/albertwidi/ftest/auth"
+	"github.com/albertwidi/ftest/logging"
+)
+
+// APIKeys provides http handlers for the admin endpoints of the API keys.
+type APIKeys struct {
+	keys *auth.Keys
+}
+
+func NewAPIKeys(keys *auth.Keys) *APIKeys {
+	return &APIKeys{keys: keys}
+}
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name"`
+	// OwnerID is optional, the key can only access the accounts of the owner if it is set.
+	OwnerID string   `json:"owner_id"`
+	Scopes  []string `json:"scopes"`
+}
+
+type APIKeyResponse struct {
+	APIKeyID  string   `json:"api_key_id"`
+	Name      string   `json:"name"`
+	Prefix    string   `json:"prefix"`
+	OwnerID   string   `json:"owner_id,omitempty"`
+	Scopes    []string `json:"scopes"`
+	CreatedAt string   `json:"created_at"`
+	RevokedAt string   `json:"revoked_at,omitempty"`
+}
+
+func newAPIKeyResponse(key auth.APIKey) APIKeyResponse {
+	resp := APIKeyResponse{
+		APIKeyID:  key.ID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		OwnerID:   key.OwnerID,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt.String(),
+	}
+	if key.RevokedAt.Valid {
+		resp.RevokedAt = key.RevokedAt.Time.String()
+	}
+	return resp
+}
+
+// CreateAPIKeyResponse has the key itself, the key is only returned once when it is created.
+type CreateAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+// CreateAPIKey creates a new API key with the scopes.
+func (h *APIKeys) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	out, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, badRequest(err, "failed to read json body request"))
+		return
+	}
+
+	req := CreateAPIKeyRequest{}
+	if err := json.Unmarshal(out, &req); err != nil {
+		writeError(w, r, badRequest(err, "invalid create api key request format"))
+		return
+	}
+
+	key, apiKey, err := h.keys.Create(r.Context(), req.Name, req.OwnerID, req.Scopes)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	logging.Annotate(r.Context(), "api_key_id", apiKey.ID)
+	writeJSON(w, r, CreateAPIKeyResponse{
+		APIKeyResponse: newAPIKeyResponse(apiKey),
+		Key:            key,
+	})
+}
+
+type ListAPIKeysResponse struct {
+	APIKeys []APIKeyResponse `json:"api_keys"`
+}
+
+// ListAPIKeys returns all API keys without the keys themselves.
+func (h *APIKeys) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.List(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	resp := ListAPIKeysResponse{APIKeys: make([]APIKeyResponse, len(keys))}
+	for idx, key := range keys {
+		resp.APIKeys[idx] = newAPIKeyResponse(key)
+	}
+	writeJSON(w, r, resp)
+}
+
+// RevokeAPIKey revokes the API key by api_key_id, the key cannot be used anymore.
+func (h *APIKeys) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	apiKeyID := chi.URLParam(r, "api_key_id")
+	r = logWith(r, "api_key_id", apiKeyID)
+	if err := h.keys.Revoke(r.Context(), apiKeyID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/albertwidi/ftest/auth"
+	"github.com/albertwidi/ftest/ledger"
+)
+
+func TestAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	keys := auth.NewMemoryKeys()
+	h := NewAPIKeys(keys)
+	r := chi.NewRouter()
+	r.Post("/", h.CreateAPIKey)
+	r.Get("/", h.ListAPIKeys)
+	r.Delete("/{api_key_id}", h.RevokeAPIKey)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":"payments","scopes":["read","transfers:write"]}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expecting status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	created := CreateAPIKeyResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Authenticate(context.Background(), created.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	list := ListAPIKeysResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.APIKeys) != 1 || list.APIKeys[0].APIKeyID != created.APIKeyID || strings.Contains(w.Body.String(), created.Key) {
+		t.Fatalf("unexpected api keys %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/"+created.APIKeyID, nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expecting status %d but got %d", http.StatusNoContent, w.Code)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expecting status %d but got %d", http.StatusNotFound, w.Code)
+	}
+
+	// Unknown scope is rejected.
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":"payments","scopes":["admin"]}`)))
+	resp := ErrorResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusBadRequest || resp.Code != ledger.CodeValidationFailed {
+		t.Fatalf("expecting status %d and code %s but got %d and %s", http.StatusBadRequest, ledger.CodeValidationFailed, w.Code, resp.Code)
+	}
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shopspring/decimal"
+
+	"github.com/albertwidi/ftest/ledger"
+	"github.com/albertwidi/ftest/logging"
+)
+
+type AuthorizeRequest struct {
+	FromAccount string `json:"from_account"`
+	ToAccount   string `json:"to_account"`
+	Amount      string `json:"amount"`
+	Currency    string `json:"currency"`
+}
+
+type HoldResponse struct {
+	HoldID          string `json:"hold_id"`
+	FromAccount     string `json:"from_account"`
+	ToAccount       string `json:"to_account"`
+	Amount          string `json:"amount"`
+	Currency        string `json:"currency"`
+	CapturedAmount  string `json:"captured_amount"`
+	RemainingAmount string `json:"remaining_amount"`
+	Status          string `json:"status"`
+	CreatedAt       string `json:"created_at"`
+}
+
+func newHoldResponse(hold ledger.Hold) HoldResponse {
+	return HoldResponse{
+		HoldID:          hold.ID,
+		FromAccount:     hold.FromAccount,
+		ToAccount:       hold.ToAccount,
+		Amount:          hold.Amount.String(),
+		Currency:        hold.Currency,
+		CapturedAmount:  hold.CapturedAmount.String(),
+		RemainingAmount: hold.Remaining().String(),
+		Status:          hold.Status,
+		CreatedAt:       hold.CreatedAt.String(),
+	}
+}
+
+// LedgerAuthorize reserves the money from the from_account and returns the hold. The money is moved when the hold is captured.
+func (h *Handler) LedgerAuthorize(w http.ResponseWriter, r *http.Request) {
+	out, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, badRequest(err, "failed to read json body request"))
+		return
+	}
+
+	req := AuthorizeRequest{}
+	if err := json.Unmarshal(out, &req); err != nil {
+		writeError(w, r, badRequest(err, "invalid authorize request format"))
+		return
+	}
+
+	amount, err := decimal.NewFromString(req.Amount)
+	if err != nil {
+		writeError(w, r, badRequest(err, "invalid amount for authorization"))
+		return
+	}
+
+	r = logWith(r, "from_account", req.FromAccount, "to_account", req.ToAccount)
+	hold, err := h.ld.Authorize(r.Context(), ledger.Authorization{
+		FromAccount: req.FromAccount,
+		ToAccount:   req.ToAccount,
+		Amount:      amount,
+		Currency:    req.Currency,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	logging.Annotate(r.Context(), "hold_id", hold.ID)
+	writeJSON(w, r, newHoldResponse(hold))
+}
+
+// LedgerGetHold returns the hold information by hold_id.
+func (h *Handler) LedgerGetHold(w http.ResponseWriter, r *http.Request) {
+	holdID := chi.URLParam(r, "hold_id")
+	r = logWith(r, "hold_id", holdID)
+	hold, err := h.ld.GetHold(r.Context(), holdID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, newHoldResponse(hold))
+}
+
+type CaptureRequest struct {
+	// Amount is optional, all the remaining amount of the hold is captured if the amount is empty.
+	Amount string `json:"amount"`
+}
+
+type CaptureResponse struct {
+	TransactionID string `json:"transaction_id"`
+}
+
+// LedgerCapture captures the hold fully or partially and creates the transaction for the captured amount.
+func (h *Handler) LedgerCapture(w http.ResponseWriter, r *http.Request) {
+	out, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, badRequest(err, "failed to read json body request"))
+		return
+	}
+
+	req := CaptureRequest{}
+	if len(out) > 0 {
+		if err := json.Unmarshal(out, &req); err != nil {
+			writeError(w, r, badRequest(err, "invalid capture request format"))
+			return
+		}
+	}
+
+	amount := decimal.Zero
+	if req.Amount != "" {
+		amount, err = decimal.NewFromString(req.Amount)
+		if err != nil {
+			writeError(w, r, badRequest(err, "invalid amount for capture"))
+			return
+		}
+	}
+
+	holdID := chi.URLParam(r, "hold_id")
+	r = logWith(r, "hold_id", holdID)
+	txID, err := h.ld.Capture(r.Context(), holdID, amount)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	logging.Annotate(r.Context(), "transaction_id", txID)
+	writeJSON(w, r, CaptureResponse{TransactionID: txID})
+}
+
+// LedgerVoid voids the hold and releases the remaining amount of the hold.
+func (h *Handler) LedgerVoid(w http.ResponseWriter, r *http.Request) {
+	holdID := chi.URLParam(r, "hold_id")
+	r = logWith(r, "hold_id", holdID)
+	if err := h.ld.Void(r.Context(), holdID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	hold, err := h.ld.GetHold(r.Context(), holdID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, newHoldResponse(hold))
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/albertwidi/ftest/httperror"
+	"github.com/albertwidi/ftest/ledger"
+	"github.com/albertwidi/ftest/logging"
+)
+
+// ErrorResponse is the response body of all errors. The code is stable and can be used by the client to handle the
+// error, while the message is only for human.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// internalErrorMessage is the message of all internal errors, the real error is only logged so we don't leak the
+// detail of the service to the client.
+const internalErrorMessage = "internal server error"
+
+// errorStatuses maps the ledger error codes to the http status code. The code that is not listed is an internal error.
+var errorStatuses = map[string]int{
+	ledger.CodeValidationFailed:       http.StatusBadRequest,
+	ledger.CodeAccountNotFound:        http.StatusNotFound,
+	ledger.CodeTransactionNotFound:    http.StatusNotFound,
+	ledger.CodeHoldNotFound:           http.StatusNotFound,
+	ledger.CodeAccountAlreadyExists:   http.StatusConflict,
+	ledger.CodeHoldNotActive:          http.StatusConflict,
+	ledger.CodeCaptureExceedsHold:     http.StatusConflict,
+	ledger.CodeInsufficientBalance:    http.StatusUnprocessableEntity,
+	ledger.CodeIdempotencyKeyMismatch: http.StatusUnprocessableEntity,
+	ledger.CodeCurrencyMismatch:       http.StatusUnprocessableEntity,
+	ledger.CodeReversalNotAllowed:     http.StatusUnprocessableEntity,
+	ledger.CodeFXRateNotFound:         http.StatusUnprocessableEntity,
+	ledger.CodeFXRateNotApplicable:    http.StatusUnprocessableEntity,
+	ledger.CodeTransactionConflict:    http.StatusServiceUnavailable,
+	ledger.CodeTimeout:                http.StatusGatewayTimeout,
+	ledger.CodeUnauthenticated:        http.StatusUnauthorized,
+	ledger.CodeForbidden:              http.StatusForbidden,
+	ledger.CodeAPIKeyNotFound:         http.StatusNotFound,
+	ledger.CodeGrantNotFound:          http.StatusNotFound,
+}
+
+// badRequest creates the error for a malformed request, for example invalid json or parameter. The message is used
+// as the error if err is nil.
+func badRequest(err error, message string) *httperror.HTTPError {
+	if err == nil {
+		err = errors.New(message)
+	}
+	return &httperror.HTTPError{
+		Err:       err,
+		Message:   message,
+		Code:      http.StatusBadRequest,
+		ErrorCode: ledger.CodeValidationFailed,
+	}
+}
+
+// toHTTPError converts the error into httperror.HTTPError based on the ledger error code. The message of the internal
+// error is always replaced, so only the message of the client errors is returned to the client.
+func toHTTPError(err error) *httperror.HTTPError {
+	var httpErr *httperror.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+
+	code := ledger.ErrorCode(err)
+	status, ok := errorStatuses[code]
+	if !ok {
+		return &httperror.HTTPError{
+			Err:       err,
+			Message:   internalErrorMessage,
+			Code:      http.StatusInternalServerError,
+			ErrorCode: ledger.CodeInternal,
+		}
+	}
+	return &httperror.HTTPError{
+		Err:       err,
+		Message:   err.Error(),
+		Code:      status,
+		ErrorCode: code,
+	}
+}
+
+// logWith adds the attributes to the logger and the access log of the request, see logging.With.
+func logWith(r *http.Request, args ...any) *http.Request {
+	return r.WithContext(logging.With(r.Context(), args...))
+}
+
+// writeError logs the error with the logger of the request and writes the error response based on the error code.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	httpErr := toHTTPError(err)
+	logger := logging.FromContext(r.Context())
+	if httpErr.Code >= http.StatusInternalServerError {
+		logger.Error(httpErr.Error(), "code", httpErr.ErrorCode, "status", httpErr.Code)
+	} else {
+		logger.Warn(httpErr.Error(), "code", httpErr.ErrorCode, "status", httpErr.Code)
+	}
+
+	out, err := json.Marshal(ErrorResponse{
+		Code:    httpErr.ErrorCode,
+		Message: httpErr.Message,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(httpErr.Code)
+	w.Write(out)
+}
+
+// writeJSON writes the response as JSON with http status ok.
+func writeJSON(w http.ResponseWriter, r *http.Request, response any) {
+	out, err := json.Marshal(response)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
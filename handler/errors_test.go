@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/albertwidi/ftest/ledger"
+)
+
+func TestWriteError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		err          error
+		expectStatus int
+		expectResp   ErrorResponse
+	}{
+		{
+			name:         "bad request",
+			err:          badRequest(errors.New("unexpected end of JSON input"), "invalid transfer request format"),
+			expectStatus: http.StatusBadRequest,
+			expectResp: ErrorResponse{
+				Code:    ledger.CodeValidationFailed,
+				Message: "invalid transfer request format",
+			},
+		},
+		{
+			name:         "insufficient balance",
+			err:          fmt.Errorf("%w: account_id a", ledger.ErrInsufficientBalance),
+			expectStatus: http.StatusUnprocessableEntity,
+			expectResp: ErrorResponse{
+				Code:    ledger.CodeInsufficientBalance,
+				Message: ledger.ErrInsufficientBalance.Error() + ": account_id a",
+			},
+		},
+		{
+			name:         "account not found",
+			err:          ledger.ErrAccountNotFound,
+			expectStatus: http.StatusNotFound,
+			expectResp: ErrorResponse{
+				Code:    ledger.CodeAccountNotFound,
+				Message: ledger.ErrAccountNotFound.Error(),
+			},
+		},
+		{
+			name:         "hold not active",
+			err:          ledger.ErrHoldNotActive,
+			expectStatus: http.StatusConflict,
+			expectResp: ErrorResponse{
+				Code:    ledger.CodeHoldNotActive,
+				Message: ledger.ErrHoldNotActive.Error(),
+			},
+		},
+		{
+			name:         "internal error is not leaked",
+			err:          errors.New("pq: connection refused"),
+			expectStatus: http.StatusInternalServerError,
+			expectResp: ErrorResponse{
+				Code:    ledger.CodeInternal,
+				Message: internalErrorMessage,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			writeError(w, httptest.NewRequest(http.MethodGet, "/", nil), test.err)
+			if w.Code != test.expectStatus {
+				t.Fatalf("expecting status %d but got %d", test.expectStatus, w.Code)
+			}
+
+			resp := ErrorResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expectResp, resp); diff != "" {
+				t.Fatalf("(-want/+got) ErrorResponse:\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/albertwidi/ftest/ledger"
+	"github.com/albertwidi/ftest/logging"
+)
+
+// CreateFXRateRequest creates a new rate of 1 base_currency = rate quote_currency. The valid_from and valid_to are in RFC3339
+// format, the rate is valid from now if valid_from is empty and valid until replaced if valid_to is empty.
+type CreateFXRateRequest struct {
+	BaseCurrency  string `json:"base_currency"`
+	QuoteCurrency string `json:"quote_currency"`
+	Rate          string `json:"rate"`
+	ValidFrom     string `json:"valid_from"`
+	ValidTo       string `json:"valid_to"`
+}
+
+type FXRateResponse struct {
+	RateID        string `json:"rate_id"`
+	BaseCurrency  string `json:"base_currency"`
+	QuoteCurrency string `json:"quote_currency"`
+	Rate          string `json:"rate"`
+	ValidFrom     string `json:"valid_from"`
+	ValidTo       string `json:"valid_to,omitempty"`
+	CreatedAt     string `json:"created_at"`
+}
+
+func newFXRateResponse(rate ledger.FXRate) FXRateResponse {
+	resp := FXRateResponse{
+		RateID:        rate.ID,
+		BaseCurrency:  rate.BaseCurrency,
+		QuoteCurrency: rate.QuoteCurrency,
+		Rate:          rate.Rate.String(),
+		ValidFrom:     rate.ValidFrom.Format(time.RFC3339),
+		CreatedAt:     rate.CreatedAt.String(),
+	}
+	if !rate.ValidTo.IsZero() {
+		resp.ValidTo = rate.ValidTo.Format(time.RFC3339)
+	}
+	return resp
+}
+
+type GetFXRatesResponse struct {
+	Rates []FXRateResponse `json:"rates"`
+}
+
+// FXCreateRate creates a new FX rate with its validity window.
+func (h *Handler) FXCreateRate(w http.ResponseWriter, r *http.Request) {
+	out, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, badRequest(err, "failed to read json body request"))
+		return
+	}
+
+	req := CreateFXRateRequest{}
+	if err := json.Unmarshal(out, &req); err != nil {
+		writeError(w, r, badRequest(err, "invalid create fx rate request format"))
+		return
+	}
+
+	rate := ledger.FXRate{
+		BaseCurrency:  req.BaseCurrency,
+		QuoteCurrency: req.QuoteCurrency,
+	}
+	rate.Rate, err = decimal.NewFromString(req.Rate)
+	if err != nil {
+		writeError(w, r, badRequest(err, "invalid rate"))
+		return
+	}
+	for param, value := range map[string]struct {
+		raw    string
+		target *time.Time
+	}{
+		"valid_from": {raw: req.ValidFrom, target: &rate.ValidFrom},
+		"valid_to":   {raw: req.ValidTo, target: &rate.ValidTo},
+	} {
+		if value.raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value.raw)
+		if err != nil {
+			writeError(w, r, badRequest(err, fmt.Sprintf("invalid %s, must be in RFC3339 format", param)))
+			return
+		}
+		*value.target = parsed
+	}
+
+	rate, err = h.ld.CreateFXRate(r.Context(), rate)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, newFXRateResponse(rate))
+}
+
+// FXGetRates returns the FX rates ordered by the newest valid_from first. The optional parameters are base_currency,
+// quote_currency and at(RFC3339) to only return the rates that are valid at the time.
+func (h *Handler) FXGetRates(w http.ResponseWriter, r *http.Request) {
+	query, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		writeError(w, r, badRequest(err, "invalid parameter for get fx rates query"))
+		return
+	}
+	var at time.Time
+	if value := query.Get("at"); value != "" {
+		at, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, r, badRequest(err, "invalid at, must be in RFC3339 format"))
+			return
+		}
+	}
+
+	rates, err := h.ld.GetFXRates(r.Context(), query.Get("base_currency"), query.Get("quote_currency"), at)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	resp := GetFXRatesResponse{
+		Rates: make([]FXRateResponse, len(rates)),
+	}
+	for idx, rate := range rates {
+		resp.Rates[idx] = newFXRateResponse(rate)
+	}
+	writeJSON(w, r, resp)
+}
+
+// ConvertRequest converts the amount from the from_account into the currency of the to_account. The rate_id is optional,
+// the latest valid rate of the currency pair is used if it is empty.
+type ConvertRequest struct {
+	FromAccount string `json:"from_account"`
+	ToAccount   string `json:"to_account"`
+	Amount      string `json:"amount"`
+	RateID      string `json:"rate_id"`
+}
+
+type ConvertResponse struct {
+	TransactionID string `json:"transaction_id"`
+	RateID        string `json:"rate_id"`
+	Rate          string `json:"rate"`
+	FromAmount    string `json:"from_amount"`
+	FromCurrency  string `json:"from_currency"`
+	ToAmount      string `json:"to_amount"`
+	ToCurrency    string `json:"to_currency"`
+}
+
+// LedgerConvert converts money between accounts with different currencies through the FX position accounts.
+func (h *Handler) LedgerConvert(w http.ResponseWriter, r *http.Request) {
+	out, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, badRequest(err, "failed to read json body request"))
+		return
+	}
+
+	req := ConvertRequest{}
+	if err := json.Unmarshal(out, &req); err != nil {
+		writeError(w, r, badRequest(err, "invalid convert request format"))
+		return
+	}
+
+	amount, err := decimal.NewFromString(req.Amount)
+	if err != nil {
+		writeError(w, r, badRequest(err, "invalid amount for conversion"))
+		return
+	}
+
+	r = logWith(r, "from_account", req.FromAccount, "to_account", req.ToAccount)
+	result, err := h.ld.Convert(r.Context(), ledger.Conversion{
+		FromAccount:    req.FromAccount,
+		ToAccount:      req.ToAccount,
+		Amount:         amount,
+		RateID:         req.RateID,
+		IdempotencyKey: r.Header.Get(idempotencyKeyHeader),
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	logging.Annotate(r.Context(), "transaction_id", result.TransactionID)
+	writeJSON(w, r, ConvertResponse{
+		TransactionID: result.TransactionID,
+		RateID:        result.RateID,
+		Rate:          result.Rate.String(),
+		FromAmount:    result.FromAmount.String(),
+		FromCurrency:  result.FromCurrency,
+		ToAmount:      result.ToAmount.String(),
+		ToCurrency:    result.ToCurrency,
+	})
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/albertwidi/ftest/ledger"
+)
+
+type GrantAccessRequest struct {
+	// GranteeID is the owner identity that is granted the access to the account.
+	GranteeID string `json:"grantee_id"`
+}
+
+type AccountGrantResponse struct {
+	AccountID string `json:"account_id"`
+	GranteeID string `json:"grantee_id"`
+	CreatedBy string `json:"created_by,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+func newAccountGrantResponse(grant ledger.AccountGrant) AccountGrantResponse {
+	return AccountGrantResponse{
+		AccountID: grant.AccountID,
+		GranteeID: grant.GranteeID,
+		CreatedBy: grant.CreatedBy,
+		CreatedAt: grant.CreatedAt.String(),
+	}
+}
+
+// LedgerGrantAccess grants the delegate access to the account.
+func (h *Handler) LedgerGrantAccess(w http.ResponseWriter, r *http.Request) {
+	accountID := chi.URLParam(r, "account_id")
+	r = logWith(r, "account_id", accountID)
+
+	out, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, badRequest(err, "failed to read json body request"))
+		return
+	}
+	req := GrantAccessRequest{}
+	if err := json.Unmarshal(out, &req); err != nil {
+		writeError(w, r, badRequest(err, "invalid grant access request format"))
+		return
+	}
+	r = logWith(r, "grantee_id", req.GranteeID)
+
+	grant, err := h.ld.GrantAccess(r.Context(), accountID, req.GranteeID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, newAccountGrantResponse(grant))
+}
+
+// LedgerRevokeAccess revokes the delegate access to the account.
+func (h *Handler) LedgerRevokeAccess(w http.ResponseWriter, r *http.Request) {
+	accountID := chi.URLParam(r, "account_id")
+	granteeID := chi.URLParam(r, "grantee_id")
+	r = logWith(r, "account_id", accountID, "grantee_id", granteeID)
+	if err := h.ld.RevokeAccess(r.Context(), accountID, granteeID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type GetAccountGrantsResponse struct {
+	Grants []AccountGrantResponse `json:"grants"`
+}
+
+// LedgerGetAccountGrants returns the active grants of the account.
+func (h *Handler) LedgerGetAccountGrants(w http.ResponseWriter, r *http.Request) {
+	accountID := chi.URLParam(r, "account_id")
+	r = logWith(r, "account_id", accountID)
+	grants, err := h.ld.GetAccountGrants(r.Context(), accountID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	resp := GetAccountGrantsResponse{Grants: make([]AccountGrantResponse, len(grants))}
+	for idx, grant := range grants {
+		resp.Grants[idx] = newAccountGrantResponse(grant)
+	}
+	writeJSON(w, r, resp)
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/albertwidi/ftest/auth"
+	"github.com/albertwidi/ftest/ledger"
+)
+
+func TestLedgerAccountGrants(t *testing.T) {
+	t.Parallel()
+	testHandler := newTestHandler(t)
+
+	r := chi.NewRouter()
+	r.Post("/account", testHandler.LedgerCreateAccount)
+	r.Get("/account/{account_id}/grants", testHandler.LedgerGetAccountGrants)
+	r.Post("/account/{account_id}/grants", testHandler.LedgerGrantAccess)
+	r.Delete("/account/{account_id}/grants/{grantee_id}", testHandler.LedgerRevokeAccess)
+	do := func(ctx context.Context, method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)).WithContext(ctx))
+		return w
+	}
+	tenant1 := auth.WithPrincipal(context.Background(), auth.Principal{ID: "key-1", Owner: "tenant-1"})
+	tenant2 := auth.WithPrincipal(context.Background(), auth.Principal{ID: "key-2", Owner: "tenant-2"})
+
+	w := do(context.Background(), http.MethodPost, "/account", `{"account_id": "t-acc-1", "owner_id": "tenant-1"}`)
+	account := CreateACcountResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &account); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || account.OwnerID != "tenant-1" {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := do(tenant2, http.MethodPost, "/account/t-acc-1/grants", `{"grantee_id": "tenant-2"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expecting status %d but got %d", http.StatusForbidden, w.Code)
+	}
+	if w := do(tenant1, http.MethodPost, "/account/t-acc-1/grants", `{"grantee_id": "tenant-2"}`); w.Code != http.StatusOK {
+		t.Fatalf("expecting status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	w = do(tenant2, http.MethodGet, "/account/t-acc-1/grants", "")
+	grants := GetAccountGrantsResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &grants); err != nil {
+		t.Fatal(err)
+	}
+	if len(grants.Grants) != 1 || grants.Grants[0].GranteeID != "tenant-2" || grants.Grants[0].CreatedBy != "key-1" {
+		t.Fatalf("unexpected grants %s", w.Body.String())
+	}
+
+	if w := do(tenant1, http.MethodDelete, "/account/t-acc-1/grants/tenant-2", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expecting status %d but got %d", http.StatusNoContent, w.Code)
+	}
+	w = do(tenant1, http.MethodDelete, "/account/t-acc-1/grants/tenant-2", "")
+	resp := ErrorResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNotFound || resp.Code != ledger.CodeGrantNotFound {
+		t.Fatalf("expecting status %d and code %s but got %d and %s", http.StatusNotFound, ledger.CodeGrantNotFound, w.Code, resp.Code)
+	}
+}
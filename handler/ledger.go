@@ -2,14 +2,18 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/shopspring/decimal"
 
 	"github.com/albertwidi/ftest/ledger"
+	"github.com/albertwidi/ftest/logging"
 )
 
 // Handler provides http handlers for all ledger endpoints.
@@ -26,54 +30,47 @@ type CreateAccountRequest struct {
 	AccountID string `json:"account_id"`
 	// AccountType defines the type of account we want to create. This is only for testing purpose.
 	AccountType string `json:"account_type"`
+	// Currency is the ISO 4217 currency code of the account, the default currency is used if the currency is empty.
+	Currency string `json:"currency"`
+	// OwnerID is optional, the account is owned by the owner of the client if it is empty.
+	OwnerID string `json:"owner_id"`
 }
 
 type CreateACcountResponse struct {
 	AccountID string `json:"account_id"`
+	Currency  string `json:"currency"`
+	OwnerID   string `json:"owner_id,omitempty"`
 	CreatedAt string `json:"created_at"`
 }
 
 func (h *Handler) LedgerCreateAccount(w http.ResponseWriter, r *http.Request) {
 	out, err := io.ReadAll(r.Body)
 	if err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: "failed to read json body request",
-			code:    http.StatusBadRequest,
-		})
+		writeError(w, r, badRequest(err, "failed to read json body request"))
 		return
 	}
 
 	req := CreateAccountRequest{}
 	if err := json.Unmarshal(out, &req); err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: "invalid create account request format",
-			code:    http.StatusBadRequest,
-		})
+		writeError(w, r, badRequest(err, "invalid create account request format"))
 		return
 	}
 
-	acc, err := h.ld.CreateAccount(r.Context(), req.AccountID, req.AccountType)
+	acc, err := h.ld.CreateOwnedAccount(r.Context(), req.OwnerID, req.AccountID, req.AccountType, req.Currency)
 	if err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: err.Error(),
-			code:    http.StatusBadRequest,
-		})
+		writeError(w, r, err)
 		return
 	}
+	logging.Annotate(r.Context(), "account_id", acc.ID)
 
 	out, err = json.Marshal(CreateACcountResponse{
 		AccountID: acc.ID,
+		Currency:  acc.Currency,
+		OwnerID:   acc.OwnerID,
 		CreatedAt: acc.CreatedAt.String(),
 	})
 	if err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: "failed to marshal response to client",
-			code:    http.StatusInternalServerError,
-		})
+		writeError(w, r, err)
 		return
 	}
 
@@ -81,10 +78,77 @@ func (h *Handler) LedgerCreateAccount(w http.ResponseWriter, r *http.Request) {
 	w.Write(out)
 }
 
+// AccountShardsRequest changes the number of sub-balances of a high-traffic account. Zero shards moves the balance back
+// into a single balance.
+type AccountShardsRequest struct {
+	AccountID string `json:"account_id"`
+	Shards    int    `json:"shards"`
+	// Strategy is either random or round_robin, random is used if the strategy is empty.
+	Strategy string `json:"strategy"`
+}
+
+type AccountShardsResponse struct {
+	AccountID string `json:"account_id"`
+	Shards    int    `json:"shards"`
+	Strategy  string `json:"strategy"`
+}
+
+func (h *Handler) LedgerSetAccountShards(w http.ResponseWriter, r *http.Request) {
+	out, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, badRequest(err, "failed to read json body request"))
+		return
+	}
+
+	req := AccountShardsRequest{}
+	if err := json.Unmarshal(out, &req); err != nil {
+		writeError(w, r, badRequest(err, "invalid account shards request format"))
+		return
+	}
+
+	r = logWith(r, "account_id", req.AccountID)
+	shards, err := h.ld.SetAccountShards(r.Context(), ledger.AccountShards{
+		AccountID: req.AccountID,
+		Shards:    req.Shards,
+		Strategy:  req.Strategy,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	out, err = json.Marshal(AccountShardsResponse{
+		AccountID: shards.AccountID,
+		Shards:    shards.Shards,
+		Strategy:  shards.Strategy,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+// idempotencyKeyHeader is the header used by the client to retry a request safely. The same key with the same request
+// will always return the same transaction_id.
+const idempotencyKeyHeader = "Idempotency-Key"
+
 type TransferRequest struct {
 	FromAccount string `json:"from_account"`
 	ToAccount   string `json:"to_account"`
 	Amount      string `json:"amount"`
+	Currency    string `json:"currency"`
+	// FX is required to transfer money between accounts with different currencies.
+	FX *FXLegRequest `json:"fx,omitempty"`
+}
+
+// FXLegRequest converts the transfer amount into the currency of the to_account through the position accounts.
+type FXLegRequest struct {
+	FromPosition string `json:"from_position"`
+	ToPosition   string `json:"to_position"`
+	Amount       string `json:"amount"`
+	Currency     string `json:"currency"`
 }
 
 type TransferResponse struct {
@@ -94,107 +158,184 @@ type TransferResponse struct {
 func (h *Handler) LedgerTransfer(w http.ResponseWriter, r *http.Request) {
 	out, err := io.ReadAll(r.Body)
 	if err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: "failed to read json body request",
-			code:    http.StatusBadRequest,
-		})
+		writeError(w, r, badRequest(err, "failed to read json body request"))
 		return
 	}
 
 	req := TransferRequest{}
 	if err := json.Unmarshal(out, &req); err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: "invalid transfer request format",
-			code:    http.StatusBadRequest,
-		})
+		writeError(w, r, badRequest(err, "invalid transfer request format"))
 		return
 	}
 
 	amount, err := decimal.NewFromString(req.Amount)
 	if err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: "invalid amount for transfer",
-			code:    http.StatusBadRequest,
-		})
+		writeError(w, r, badRequest(err, "invalid amount for transfer"))
 		return
 	}
 
-	txID, err := h.ld.Transfer(r.Context(), ledger.Transfer{
-		FromAccount: req.FromAccount,
-		ToAccount:   req.ToAccount,
-		Amount:      amount,
-	})
+	r = logWith(r, "from_account", req.FromAccount, "to_account", req.ToAccount)
+	transfer := ledger.Transfer{
+		FromAccount:    req.FromAccount,
+		ToAccount:      req.ToAccount,
+		Amount:         amount,
+		Currency:       req.Currency,
+		IdempotencyKey: r.Header.Get(idempotencyKeyHeader),
+	}
+	if req.FX != nil {
+		fxAmount, err := decimal.NewFromString(req.FX.Amount)
+		if err != nil {
+			writeError(w, r, badRequest(err, "invalid amount for fx"))
+			return
+		}
+		transfer.FX = &ledger.FXLeg{
+			FromPosition: req.FX.FromPosition,
+			ToPosition:   req.FX.ToPosition,
+			Amount:       fxAmount,
+			Currency:     req.FX.Currency,
+		}
+	}
+
+	txID, err := h.ld.Transfer(r.Context(), transfer)
 	if err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: "failed to transfer",
-			code:    http.StatusInternalServerError,
-		})
+		writeError(w, r, err)
 		return
 	}
+	logging.Annotate(r.Context(), "transaction_id", txID)
 
 	out, err = json.Marshal(TransferResponse{TransactionID: txID})
 	if err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: "failed to marshal response to client",
-			code:    http.StatusInternalServerError,
-		})
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+type LegRequest struct {
+	AccountID string `json:"account_id"`
+	Amount    string `json:"amount"`
+	// Currency is optional, the currency of the transaction is used if the currency of the leg is empty.
+	Currency string `json:"currency"`
+}
+
+// CreateTransactionRequest is a multi-leg transaction request. The total amount of debits must be the same with the
+// total amount of credits for every currency.
+type CreateTransactionRequest struct {
+	Currency string       `json:"currency"`
+	Debits   []LegRequest `json:"debits"`
+	Credits  []LegRequest `json:"credits"`
+}
+
+type CreateTransactionResponse struct {
+	TransactionID string `json:"transaction_id"`
+}
+
+func (h *Handler) LedgerCreateTransaction(w http.ResponseWriter, r *http.Request) {
+	out, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, badRequest(err, "failed to read json body request"))
+		return
+	}
+
+	req := CreateTransactionRequest{}
+	if err := json.Unmarshal(out, &req); err != nil {
+		writeError(w, r, badRequest(err, "invalid create transaction request format"))
+		return
+	}
+
+	debits, err := toEntries(req.Debits)
+	if err != nil {
+		writeError(w, r, badRequest(err, "invalid amount for debit"))
+		return
+	}
+	credits, err := toEntries(req.Credits)
+	if err != nil {
+		writeError(w, r, badRequest(err, "invalid amount for credit"))
+		return
+	}
+
+	accounts := make([]string, 0, len(debits)+len(credits))
+	for _, legs := range [][]ledger.Entry{debits, credits} {
+		for _, leg := range legs {
+			accounts = append(accounts, leg.AccountID)
+		}
+	}
+	r = logWith(r, "accounts", accounts)
+	txID, err := h.ld.Post(r.Context(), ledger.Posting{
+		Currency: req.Currency,
+		Debits:   debits,
+		Credits:  credits,
+	}, r.Header.Get(idempotencyKeyHeader))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	logging.Annotate(r.Context(), "transaction_id", txID)
+
+	out, err = json.Marshal(CreateTransactionResponse{TransactionID: txID})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 	w.Write(out)
 }
 
+func toEntries(legs []LegRequest) ([]ledger.Entry, error) {
+	result := make([]ledger.Entry, len(legs))
+	for idx, leg := range legs {
+		amount, err := decimal.NewFromString(leg.Amount)
+		if err != nil {
+			return nil, err
+		}
+		result[idx] = ledger.Entry{
+			AccountID: leg.AccountID,
+			Amount:    amount,
+			Currency:  leg.Currency,
+		}
+	}
+	return result, nil
+}
+
+// GetBalanceResponse returns both available and posted balance of the account. The available balance is the posted
+// balance subtracted by the amount of active holds.
 type GetBalanceResponse struct {
-	AccountID   string `json:"account_id"`
-	Balance     string `json:"available_balance"`
-	LastUpdated string `json:"last_updated"`
+	AccountID        string `json:"account_id"`
+	Currency         string `json:"currency"`
+	AvailableBalance string `json:"available_balance"`
+	PostedBalance    string `json:"posted_balance"`
+	LastUpdated      string `json:"last_updated"`
 }
 
 func (h *Handler) LedgerGetBalance(w http.ResponseWriter, r *http.Request) {
 	query, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: "invalid parameter for get balance query",
-			code:    http.StatusBadRequest,
-		})
+		writeError(w, r, badRequest(err, "invalid parameter for get balance query"))
 		return
 	}
 	accountID := query.Get("account_id")
 	if accountID == "" {
-		writeError(w, ErrorResponse{
-			Message: "account_id cannot be empty",
-			code:    http.StatusBadRequest,
-		})
+		writeError(w, r, badRequest(nil, "account_id cannot be empty"))
 		return
 	}
+	r = logWith(r, "account_id", accountID)
 	balance, err := h.ld.GetAccountBalance(r.Context(), accountID)
 	if err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: err.Error(),
-			code:    http.StatusBadRequest,
-		})
+		writeError(w, r, err)
 		return
 	}
 
 	resp := GetBalanceResponse{
-		AccountID:   balance.AccountID,
-		Balance:     balance.Balance.String(),
-		LastUpdated: balance.UpdatedAt.String(),
+		AccountID:        balance.AccountID,
+		Currency:         balance.Currency,
+		AvailableBalance: balance.AvailableBalance.String(),
+		PostedBalance:    balance.Balance.String(),
+		LastUpdated:      balance.UpdatedAt.String(),
 	}
 	out, err := json.Marshal(resp)
 	if err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: "failed to marshal response to client",
-			code:    http.StatusInternalServerError,
-		})
+		writeError(w, r, err)
 		return
 	}
 
@@ -206,63 +347,63 @@ func (h *Handler) LedgerGetBalance(w http.ResponseWriter, r *http.Request) {
 // we only have transfer which always 1:1 from user to user, we can use it for now.
 type GetTransactionsResponse struct {
 	Transactions []LedgerEntryResponse `json:"transactions"`
+	// NextCursor and PrevCursor are used as the cursor parameter to get the next and previous page. The cursor
+	// is empty if there are no more entries.
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
 }
 
 type LedgerEntryResponse struct {
 	TransactionID string `json:"transaction_id"`
 	AccountID     string `json:"account_id"`
 	Amount        string `json:"amount"`
+	Currency      string `json:"currency"`
 	CreatedAt     string `json:"created_at"`
 }
 
 func (h *Handler) LedgerGetTransactionsByAccountID(w http.ResponseWriter, r *http.Request) {
 	query, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: "invalid parameter for get balance query",
-			code:    http.StatusBadRequest,
-		})
+		writeError(w, r, badRequest(err, "invalid parameter for get balance query"))
 		return
 	}
 	accountID := query.Get("account_id")
 	if accountID == "" {
-		writeError(w, ErrorResponse{
-			Message: "account_id cannot be empty",
-			code:    http.StatusBadRequest,
-		})
+		writeError(w, r, badRequest(nil, "account_id cannot be empty"))
+		return
+	}
+
+	filter, err := parseLedgerEntriesFilter(query)
+	if err != nil {
+		writeError(w, r, badRequest(err, err.Error()))
 		return
 	}
 
-	entries, err := h.ld.GetAccountLedgerEntries(r.Context(), accountID)
+	r = logWith(r, "account_id", accountID)
+	page, err := h.ld.GetAccountLedgerEntries(r.Context(), accountID, filter)
 	if err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: err.Error(),
-			code:    http.StatusBadRequest,
-		})
+		writeError(w, r, err)
 		return
 	}
 
 	resp := GetTransactionsResponse{
-		Transactions: make([]LedgerEntryResponse, len(entries)),
+		Transactions: make([]LedgerEntryResponse, len(page.Entries)),
+		NextCursor:   page.NextCursor,
+		PrevCursor:   page.PrevCursor,
 	}
-	for idx, entry := range entries {
+	for idx, entry := range page.Entries {
 		resp.Transactions[idx] = LedgerEntryResponse{
 			TransactionID: entry.TransactionID,
 			AccountID:     entry.AccountID,
 			Amount:        entry.Amount.String(),
+			Currency:      entry.Currency,
 			CreatedAt:     entry.CreatedAt.String(),
 		}
 	}
 
 	out, err := json.Marshal(resp)
 	if err != nil {
-		slog.Error(err.Error())
-		writeError(w, ErrorResponse{
-			Message: "failed to marshal response to client",
-			code:    http.StatusInternalServerError,
-		})
+		writeError(w, r, err)
 		return
 	}
 
@@ -270,17 +411,43 @@ func (h *Handler) LedgerGetTransactionsByAccountID(w http.ResponseWriter, r *htt
 	w.Write(out)
 }
 
-type ErrorResponse struct {
-	Message string `json:"message"`
-	code    int
-}
-
-func writeError(w http.ResponseWriter, response ErrorResponse) {
-	out, err := json.Marshal(response)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+// parseLedgerEntriesFilter parses the pagination and filter parameters of the ledger entries. The supported parameters are
+// limit, cursor, from_time, to_time(RFC3339), direction(debit/credit), min_amount and max_amount.
+func parseLedgerEntriesFilter(query url.Values) (ledger.LedgerEntriesFilter, error) {
+	filter := ledger.LedgerEntriesFilter{
+		Cursor:    query.Get("cursor"),
+		Direction: query.Get("direction"),
 	}
-	w.WriteHeader(response.code)
-	w.Write(out)
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return filter, errors.New("invalid limit")
+		}
+		filter.Limit = l
+	}
+	for param, t := range map[string]*time.Time{
+		"from_time": &filter.FromTime,
+		"to_time":   &filter.ToTime,
+	} {
+		if value := query.Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s, must be in RFC3339 format", param)
+			}
+			*t = parsed
+		}
+	}
+	for param, amount := range map[string]*decimal.NullDecimal{
+		"min_amount": &filter.MinAmount,
+		"max_amount": &filter.MaxAmount,
+	} {
+		if value := query.Get(param); value != "" {
+			parsed, err := decimal.NewFromString(value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s", param)
+			}
+			*amount = decimal.NewNullDecimal(parsed)
+		}
+	}
+	return filter, nil
 }
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/shopspring/decimal"
 
 	"github.com/albertwidi/ftest/ledger"
 )
@@ -17,10 +19,8 @@ import (
 // TestLedgerTransfer tests the transfer feature. This kind of test ensure we receive message in the format
 // that we wanted(in JSON) with correct message and parameter.
 func TestLedgerTransfer(t *testing.T) {
-	ledger.BootstrapTest(t, testHandler.ld)
-	t.Cleanup(func() {
-		ledger.RestLedger(t, testHandler.ld)
-	})
+	t.Parallel()
+	testHandler := newTestHandler(t)
 
 	req := TransferRequest{
 		FromAccount: "b-fund",
@@ -78,8 +78,10 @@ func TestLedgerTransfer(t *testing.T) {
 	}
 
 	expect := GetBalanceResponse{
-		AccountID: "b-acc-1",
-		Balance:   "10.1",
+		AccountID:        "b-acc-1",
+		Currency:         ledger.DefaultCurrency,
+		AvailableBalance: "10.1",
+		PostedBalance:    "10.1",
 	}
 	if diff := cmp.Diff(expect, balanceResp, cmpopts.IgnoreFields(
 		GetBalanceResponse{}, "LastUpdated",
@@ -114,6 +116,7 @@ func TestLedgerTransfer(t *testing.T) {
 			{
 				AccountID: "b-acc-1",
 				Amount:    "10.1",
+				Currency:  ledger.DefaultCurrency,
 			},
 		},
 	}
@@ -123,3 +126,142 @@ func TestLedgerTransfer(t *testing.T) {
 		t.Fatalf("(-want/+got) Transactions:\n%s", diff)
 	}
 }
+
+// TestLedgerCreateTransaction tests the multi-leg transaction where one account pays to multiple accounts.
+func TestLedgerCreateTransaction(t *testing.T) {
+	t.Parallel()
+	testHandler := newTestHandler(t)
+
+	if _, err := testHandler.ld.Transfer(context.Background(), ledger.Transfer{
+		FromAccount: "b-fund",
+		ToAccount:   "b-acc-1",
+		Amount:      decimal.NewFromInt(100),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := CreateTransactionRequest{
+		Debits: []LegRequest{
+			{AccountID: "b-acc-1", Amount: "100"},
+		},
+		Credits: []LegRequest{
+			{AccountID: "b-acc-2", Amount: "90"},
+			{AccountID: "b-fund", Amount: "10"},
+		},
+	}
+	out, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpReq := httptest.NewRequest("POST", "/", bytes.NewBuffer(out))
+	w := httptest.NewRecorder()
+
+	testHandler.LedgerCreateTransaction(w, httpReq)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect ok status from create transaction but got %d", w.Code)
+	}
+
+	resp := CreateTransactionResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.TransactionID == "" {
+		t.Fatal("got empty transaction id")
+	}
+
+	balance, err := testHandler.ld.GetAccountBalance(context.Background(), "b-acc-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Balance.Cmp(decimal.NewFromInt(90)) != 0 {
+		t.Fatalf("expecting balance 90 but got %s", balance.Balance.String())
+	}
+}
+
+// TestLedgerTransferInsufficientBalance tests the error response when the account doesn't have enough balance, the
+// client must receive the stable error code instead of only the message.
+func TestLedgerTransferInsufficientBalance(t *testing.T) {
+	t.Parallel()
+	testHandler := newTestHandler(t)
+
+	req := TransferRequest{
+		FromAccount: "b-acc-1",
+		ToAccount:   "b-acc-2",
+		Amount:      "10",
+	}
+	out, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpReq := httptest.NewRequest("POST", "/", bytes.NewBuffer(out))
+	w := httptest.NewRecorder()
+
+	testHandler.LedgerTransfer(w, httpReq)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expecting status %d but got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	resp := ErrorResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != ledger.CodeInsufficientBalance {
+		t.Fatalf("expecting code %s but got %s", ledger.CodeInsufficientBalance, resp.Code)
+	}
+}
+
+func TestLedgerSetAccountShards(t *testing.T) {
+	t.Parallel()
+	testHandler := newTestHandler(t)
+
+	tests := []struct {
+		name         string
+		req          AccountShardsRequest
+		expectStatus int
+		expectResp   AccountShardsResponse
+	}{
+		{
+			name:         "shard funding account",
+			req:          AccountShardsRequest{AccountID: "b-fund", Shards: 4},
+			expectStatus: http.StatusOK,
+			expectResp:   AccountShardsResponse{AccountID: "b-fund", Shards: 4, Strategy: ledger.ShardStrategyRandom},
+		},
+		{
+			name:         "invalid strategy",
+			req:          AccountShardsRequest{AccountID: "b-fund", Shards: 4, Strategy: "weighted"},
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "account not found",
+			req:          AccountShardsRequest{AccountID: "unknown", Shards: 4},
+			expectStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			out, err := json.Marshal(test.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			testHandler.LedgerSetAccountShards(w, httptest.NewRequest("PUT", "/", bytes.NewBuffer(out)))
+			if w.Code != test.expectStatus {
+				t.Fatalf("expecting status %d but got %d", test.expectStatus, w.Code)
+			}
+			if test.expectStatus != http.StatusOK {
+				return
+			}
+			resp := AccountShardsResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expectResp, resp); diff != "" {
+				t.Fatalf("(-want/+got)\n%s", diff)
+			}
+		})
+	}
+}
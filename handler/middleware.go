@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/albertwidi/ftest/auth"
+	"github.com/albertwidi/ftest/ledger"
+	"github.com/albertwidi/ftest/logging"
+)
+
+// Timeouts is the maximum duration of a request to the ledger. Reads and writes have different timeouts, because a write
+// might need to wait for the locks of the accounts. Zero means the request has no timeout.
+type Timeouts struct {
+	// Read is the timeout of the GET and HEAD requests.
+	Read time.Duration
+	// Write is the timeout of all other requests.
+	Write time.Duration
+}
+
+func (t Timeouts) timeout(method string) time.Duration {
+	if method == http.MethodGet || method == http.MethodHead {
+		return t.Read
+	}
+	return t.Write
+}
+
+// Timeout sets the deadline of the request context based on the method of the request. The context is passed down to
+// all database queries, so a query is canceled and the database transaction is rolled back when the deadline is reached
+// or the client is gone.
+func Timeout(timeouts Timeouts) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := timeouts.timeout(r.Method)
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Authenticate authenticates the client with the bearer token of the Authorization header, and passes the principal of
+// the client to the context of the request. The request without a valid token is rejected.
+func Authenticate(authenticator auth.Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The scheme of the Authorization header is case-insensitive.
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || token == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, r, ledger.ErrUnauthenticated)
+				return
+			}
+			principal, err := authenticator.Authenticate(r.Context(), token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, r, err)
+				return
+			}
+			ctx := logging.With(auth.WithPrincipal(r.Context(), principal), "principal_id", principal.ID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireScope rejects the request if the principal of the request doesn't have the scope. The middleware must be used
+// after Authenticate.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := auth.Require(r.Context(), scope); err != nil {
+				writeError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
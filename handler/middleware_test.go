@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/albertwidi/ftest/auth"
+	"github.com/albertwidi/ftest/ledger"
+)
+
+func TestTimeout(t *testing.T) {
+	t.Parallel()
+
+	timeouts := Timeouts{Read: time.Second, Write: time.Minute}
+	tests := []struct {
+		name     string
+		timeouts Timeouts
+		method   string
+		expect   time.Duration
+	}{
+		{name: "read", timeouts: timeouts, method: http.MethodGet, expect: time.Second},
+		{name: "write", timeouts: timeouts, method: http.MethodPost, expect: time.Minute},
+		{name: "no timeout", timeouts: Timeouts{}, method: http.MethodPost},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var deadline time.Time
+			var ok bool
+			handler := Timeout(test.timeouts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				deadline, ok = r.Context().Deadline()
+			}))
+			start := time.Now()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(test.method, "/", nil))
+
+			if ok != (test.expect > 0) {
+				t.Fatalf("expecting deadline %t but got %t", test.expect > 0, ok)
+			}
+			if ok && (deadline.Before(start.Add(test.expect)) || deadline.After(time.Now().Add(test.expect))) {
+				t.Fatalf("expecting deadline in %s but got %s", test.expect, deadline.Sub(start))
+			}
+		})
+	}
+}
+
+// TestTimeoutExceeded tests whether the request that reaches the deadline is responded with the timeout error.
+func TestTimeoutExceeded(t *testing.T) {
+	t.Parallel()
+
+	handler := Timeout(Timeouts{Write: time.Millisecond})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		writeError(w, r, r.Context().Err())
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expecting status %d but got %d", http.StatusGatewayTimeout, w.Code)
+	}
+	resp := ErrorResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != ledger.CodeTimeout {
+		t.Fatalf("expecting code %s but got %s", ledger.CodeTimeout, resp.Code)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	keys := auth.NewMemoryKeys()
+	key, apiKey, err := keys.Create(context.Background(), "payments", "", []string{auth.ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		expectStatus  int
+	}{
+		{name: "no authorization", expectStatus: http.StatusUnauthorized},
+		{name: "basic scheme", authorization: "Basic " + key, expectStatus: http.StatusUnauthorized},
+		{name: "unknown key", authorization: "Bearer ldg_unknown", expectStatus: http.StatusUnauthorized},
+		{name: "valid key", authorization: "Bearer " + key, expectStatus: http.StatusOK},
+		{name: "lowercase scheme", authorization: "bearer " + key, expectStatus: http.StatusOK},
+		{name: "missing scope", authorization: "Bearer " + key, expectStatus: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			scope := auth.ScopeRead
+			if test.expectStatus == http.StatusForbidden {
+				scope = auth.ScopeTransfersWrite
+			}
+			handler := Authenticate(keys)(RequireScope(scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal, ok := auth.FromContext(r.Context())
+				if !ok || principal.ID != apiKey.ID {
+					t.Errorf("expecting principal %s but got %+v", apiKey.ID, principal)
+				}
+			})))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != test.expectStatus {
+				t.Fatalf("expecting status %d but got %d", test.expectStatus, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Fatalf("expecting WWW-Authenticate header but got %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
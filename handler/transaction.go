@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shopspring/decimal"
+
+	"github.com/albertwidi/ftest/logging"
+)
+
+type TransactionEntryResponse struct {
+	AccountID string `json:"account_id"`
+	Amount    string `json:"amount"`
+	Currency  string `json:"currency"`
+	// PreviousBalance and CurrentBalance are empty for the entries of a sharded account.
+	PreviousBalance string `json:"previous_balance,omitempty"`
+	CurrentBalance  string `json:"current_balance,omitempty"`
+	CreatedAt       string `json:"created_at"`
+}
+
+type GetTransactionResponse struct {
+	TransactionID   string                     `json:"transaction_id"`
+	TransactionType string                     `json:"transaction_type"`
+	Amount          string                     `json:"amount"`
+	Currency        string                     `json:"currency"`
+	ReversalOf      string                     `json:"reversal_of,omitempty"`
+	ReversedAmount  string                     `json:"reversed_amount"`
+	FXRate          string                     `json:"fx_rate,omitempty"`
+	FXRateID        string                     `json:"fx_rate_id,omitempty"`
+	CreatedBy       string                     `json:"created_by,omitempty"`
+	CreatedAt       string                     `json:"created_at"`
+	Entries         []TransactionEntryResponse `json:"entries"`
+}
+
+// LedgerGetTransaction returns the transaction and all of its ledger entries.
+func (h *Handler) LedgerGetTransaction(w http.ResponseWriter, r *http.Request) {
+	transactionID := chi.URLParam(r, "transaction_id")
+	r = logWith(r, "transaction_id", transactionID)
+	tx, err := h.ld.GetTransaction(r.Context(), transactionID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	resp := GetTransactionResponse{
+		TransactionID:   tx.ID,
+		TransactionType: tx.Type,
+		Amount:          tx.Amount.String(),
+		Currency:        tx.Currency,
+		ReversalOf:      tx.ReversalOf,
+		ReversedAmount:  tx.ReversedAmount.String(),
+		FXRateID:        tx.FXRateID,
+		CreatedBy:       tx.CreatedBy,
+		CreatedAt:       tx.CreatedAt.String(),
+		Entries:         make([]TransactionEntryResponse, len(tx.Entries)),
+	}
+	if tx.FXRateID != "" {
+		resp.FXRate = tx.FXRate.String()
+	}
+	for idx, entry := range tx.Entries {
+		resp.Entries[idx] = TransactionEntryResponse{
+			AccountID: entry.AccountID,
+			Amount:    entry.Amount.String(),
+			Currency:  entry.Currency,
+			CreatedAt: entry.CreatedAt.String(),
+		}
+		if entry.PreviousBalance.Valid && entry.CurrentBalance.Valid {
+			resp.Entries[idx].PreviousBalance = entry.PreviousBalance.Decimal.String()
+			resp.Entries[idx].CurrentBalance = entry.CurrentBalance.Decimal.String()
+		}
+	}
+	writeJSON(w, r, resp)
+}
+
+type ReverseTransactionRequest struct {
+	// Amount is optional, all the remaining amount of the transaction is reversed if the amount is empty.
+	Amount string `json:"amount"`
+}
+
+type ReverseTransactionResponse struct {
+	TransactionID string `json:"transaction_id"`
+	ReversalOf    string `json:"reversal_of"`
+}
+
+// LedgerReverseTransaction reverses the transaction fully or partially by posting the mirrored entries of the transaction.
+func (h *Handler) LedgerReverseTransaction(w http.ResponseWriter, r *http.Request) {
+	out, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, badRequest(err, "failed to read json body request"))
+		return
+	}
+
+	req := ReverseTransactionRequest{}
+	if len(out) > 0 {
+		if err := json.Unmarshal(out, &req); err != nil {
+			writeError(w, r, badRequest(err, "invalid reverse transaction request format"))
+			return
+		}
+	}
+
+	amount := decimal.Zero
+	if req.Amount != "" {
+		amount, err = decimal.NewFromString(req.Amount)
+		if err != nil {
+			writeError(w, r, badRequest(err, "invalid amount for reversal"))
+			return
+		}
+	}
+
+	transactionID := chi.URLParam(r, "transaction_id")
+	r = logWith(r, "reversal_of", transactionID)
+	txID, err := h.ld.Reverse(r.Context(), transactionID, amount)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	logging.Annotate(r.Context(), "transaction_id", txID)
+	writeJSON(w, r, ReverseTransactionResponse{
+		TransactionID: txID,
+		ReversalOf:    transactionID,
+	})
+}
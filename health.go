@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// readinessTimeout is the maximum duration of all readiness checks.
+const readinessTimeout = 2 * time.Second
+
+// liveness tells whether the process is alive. It doesn't check the dependencies, so a database outage doesn't make the
+// service restarted.
+func liveness(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// readinessCheck checks whether a dependency of the service is ready.
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// readiness tells the load balancer whether the service can receive new requests. The service is only ready after it is
+// fully started and all checks pass, and it is not ready anymore as soon as the shutdown begins, so the load balancer
+// stops routing the new requests before the server is drained.
+type readiness struct {
+	ready  atomic.Bool
+	checks []readinessCheck
+}
+
+func newReadiness(checks ...readinessCheck) *readiness {
+	return &readiness{checks: checks}
+}
+
+func (rd *readiness) set(ready bool) {
+	rd.ready.Store(ready)
+}
+
+func (rd *readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !rd.ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("not ready"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	for _, check := range rd.checks {
+		if err := check.check(ctx); err != nil {
+			slog.Warn("readiness check failed", "check", check.name, "error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(check.name + " is not ready"))
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// databaseCheck checks whether the database is reachable.
+func databaseCheck(db *sql.DB) readinessCheck {
+	return readinessCheck{
+		name:  "database",
+		check: db.PingContext,
+	}
+}
+
+// migrationVersions returns the applied and the latest version of the migrations, it is implemented by migrate.Migrator.
+type migrationVersions interface {
+	Version(ctx context.Context) (int64, error)
+	Latest() int64
+}
+
+// migrationCheck checks whether the database has all the migrations of the service. A database with newer migrations is
+// still ready, because the older replicas keep serving the requests while the newer replicas are rolled out.
+func migrationCheck(migrator migrationVersions) readinessCheck {
+	return readinessCheck{
+		name: "migrations",
+		check: func(ctx context.Context) error {
+			version, err := migrator.Version(ctx)
+			if err != nil {
+				return err
+			}
+			if version < migrator.Latest() {
+				return fmt.Errorf("database version %d is older than %d", version, migrator.Latest())
+			}
+			return nil
+		},
+	}
+}
+
+// connectDB opens the database and waits until the database is reachable. The connection is retried with exponential
+// backoff until the timeout, so the service can be started before the database is ready.
+func connectDB(ctx context.Context, dsn string, timeout time.Duration) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	const maxBackoff = 5 * time.Second
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return db, nil
+		}
+		slog.Warn("failed to connect to the database, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+
+		select {
+		case <-ctx.Done():
+			db.Close()
+			return nil, fmt.Errorf("failed to connect to the database after %d attempts: %w", attempt, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
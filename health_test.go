@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeMigrations is the migrationVersions with the fixed versions.
+type fakeMigrations struct {
+	version int64
+	latest  int64
+	err     error
+}
+
+func (f fakeMigrations) Version(ctx context.Context) (int64, error) {
+	return f.version, f.err
+}
+
+func (f fakeMigrations) Latest() int64 {
+	return f.latest
+}
+
+func TestReadiness(t *testing.T) {
+	t.Parallel()
+
+	passing := readinessCheck{name: "passing", check: func(ctx context.Context) error { return nil }}
+	tests := []struct {
+		name         string
+		ready        bool
+		checks       []readinessCheck
+		expectStatus int
+		expectBody   string
+	}{
+		{
+			name:         "ready",
+			ready:        true,
+			checks:       []readinessCheck{passing, migrationCheck(fakeMigrations{version: 7, latest: 7})},
+			expectStatus: http.StatusOK,
+			expectBody:   "ok",
+		},
+		{
+			name:         "not started or draining",
+			ready:        false,
+			checks:       []readinessCheck{passing},
+			expectStatus: http.StatusServiceUnavailable,
+			expectBody:   "not ready",
+		},
+		{
+			name:  "failing ping",
+			ready: true,
+			checks: []readinessCheck{passing, {name: "database", check: func(ctx context.Context) error {
+				return errors.New("connection refused")
+			}}},
+			expectStatus: http.StatusServiceUnavailable,
+			expectBody:   "database is not ready",
+		},
+		{
+			name:         "failing migration version",
+			ready:        true,
+			checks:       []readinessCheck{migrationCheck(fakeMigrations{err: errors.New("connection refused")})},
+			expectStatus: http.StatusServiceUnavailable,
+			expectBody:   "migrations is not ready",
+		},
+		{
+			name:         "older migration version",
+			ready:        true,
+			checks:       []readinessCheck{migrationCheck(fakeMigrations{version: 6, latest: 7})},
+			expectStatus: http.StatusServiceUnavailable,
+			expectBody:   "migrations is not ready",
+		},
+		{
+			// The older replicas are still ready while the newer replicas are rolled out.
+			name:         "newer migration version",
+			ready:        true,
+			checks:       []readinessCheck{migrationCheck(fakeMigrations{version: 8, latest: 7})},
+			expectStatus: http.StatusOK,
+			expectBody:   "ok",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ready := newReadiness(test.checks...)
+			ready.set(test.ready)
+			w := httptest.NewRecorder()
+			ready.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != test.expectStatus || w.Body.String() != test.expectBody {
+				t.Fatalf("expecting %d %q but got %d %q", test.expectStatus, test.expectBody, w.Code, w.Body.String())
+			}
+		})
+	}
+}
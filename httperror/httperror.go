@@ -12,6 +12,8 @@ type HTTPError struct {
 	Message string
 	// Code is the http code of when error happens.
 	Code int
+	// ErrorCode is the stable and machine-readable code of the error, so the client doesn't need to parse the message.
+	ErrorCode string
 }
 
 // Error returns the string of real error.
@@ -19,6 +21,11 @@ func (h *HTTPError) Error() string {
 	return h.Err.Error()
 }
 
+// Unwrap returns the real error, so errors.As can also seek the internal h.Err.
+func (h *HTTPError) Unwrap() error {
+	return h.Err
+}
+
 // Is overrides the implementation of errors.Is. If this function is implemented, then errors.Is will seek
 // the internal h.Err.
 func (h *HTTPError) Is(err error) bool {
@@ -0,0 +1,189 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/albertwidi/ftest/auth"
+	"github.com/albertwidi/ftest/ledger/internal"
+)
+
+// maxOwnerIDLength is the maximum length of the owner identity of the accounts and the grants.
+const maxOwnerIDLength = 255
+
+// createdBy returns the id of the principal of the context, the id is recorded in the transaction for the audit.
+func createdBy(ctx context.Context) string {
+	principal, _ := auth.FromContext(ctx)
+	return principal.ID
+}
+
+// isFundingAdmin returns true if the principal of the context can create money, either by creating a funding account or
+// by debiting an account that allows negative balance. The operations without a principal are not requested by a client,
+// for example the shard rebalancer, so they are always allowed. All requests to the service have a principal.
+func isFundingAdmin(ctx context.Context) bool {
+	principal, ok := auth.FromContext(ctx)
+	return !ok || principal.HasScope(auth.ScopeFundingAdmin)
+}
+
+// fxPositionDebiter is implemented by the builders inside the package that debit the FX position accounts with the amount
+// calculated by the ledger, like the conversion of Convert. The interface is unexported, so the postings and the transfers
+// of the clients cannot debit the position accounts without the checks.
+type fxPositionDebiter interface {
+	debitsFXPositions() bool
+}
+
+// ledgerDebits returns the FX position accounts that are debited by the builder of the ledger. The accounts are excluded
+// from the checks of the debits, as they are debited by every conversion regardless of the principal.
+func ledgerDebits(builder TransactionBuilder, summaries txSumaries) map[string]bool {
+	debiter, ok := builder.(fxPositionDebiter)
+	if !ok || !debiter.debitsFXPositions() {
+		return nil
+	}
+	debits := make(map[string]bool)
+	for accountID, sum := range summaries {
+		if sum.IsNegative() && strings.HasPrefix(accountID, fxPositionAccountPrefix) {
+			debits[accountID] = true
+		}
+	}
+	return debits
+}
+
+// checkFundingDebits returns ErrForbidden if the transaction debits an account that allows negative balance and the
+// principal is not a funding admin. The ledgerDebits are excluded, see ledgerDebits.
+func checkFundingDebits(ctx context.Context, summaries txSumaries, balances []internal.AccountBalance, ledgerDebits map[string]bool) error {
+	if isFundingAdmin(ctx) {
+		return nil
+	}
+	for _, balance := range balances {
+		if !balance.AllowNegative || !summaries[balance.AccountID].IsNegative() || ledgerDebits[balance.AccountID] {
+			continue
+		}
+		return fmt.Errorf("%w: debit of account_id %s requires scope %s", ErrForbidden, balance.AccountID, auth.ScopeFundingAdmin)
+	}
+	return nil
+}
+
+// checkFXLeg returns ErrForbidden if the transaction is a transfer with the explicit FX leg and the principal is not a
+// funding admin. The converted amount of the FX leg is chosen by the client instead of the FX rates, so paying it from the
+// position account creates money.
+func checkFXLeg(ctx context.Context, builder TransactionBuilder) error {
+	transfer, ok := builder.(Transfer)
+	if !ok || transfer.FX == nil || isFundingAdmin(ctx) {
+		return nil
+	}
+	return fmt.Errorf("%w: transfer with fx leg requires scope %s", ErrForbidden, auth.ScopeFundingAdmin)
+}
+
+// authorizeFundingDebits checks the debits of the transaction with checkFundingDebits. It is used when the balances are
+// not checked before the transaction is created, the balances of the debited accounts are only retrieved if the principal
+// is not a funding admin.
+func (l *Ledger) authorizeFundingDebits(ctx context.Context, summaries txSumaries, ledgerDebits map[string]bool) error {
+	if isFundingAdmin(ctx) {
+		return nil
+	}
+	var debits []string
+	for accountID, sum := range summaries {
+		if sum.IsNegative() && !ledgerDebits[accountID] {
+			debits = append(debits, accountID)
+		}
+	}
+	if len(debits) == 0 {
+		return nil
+	}
+	balances, err := l.storage.GetAccountsBalance(ctx, debits...)
+	if err != nil {
+		return err
+	}
+	return checkFundingDebits(ctx, summaries, balances, ledgerDebits)
+}
+
+// accountOwner returns the owner identity of the principal of the context. The end users and the API keys of a tenant
+// have an owner and can only access the accounts of the owner, or the accounts that are granted to the owner. The
+// principals without owner, like the platform services, can access all accounts.
+func accountOwner(ctx context.Context) string {
+	principal, _ := auth.FromContext(ctx)
+	return principal.Owner
+}
+
+// canAccessAccount returns true if the account is owned by the owner, or the access to the account is granted to the
+// owner. An account that doesn't exist is treated as accessible, so the operation still returns ErrAccountNotFound.
+func (l *Ledger) canAccessAccount(ctx context.Context, owner, accountID string) (bool, error) {
+	acc, err := l.storage.GetAccount(ctx, accountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if acc.OwnerID.Valid && acc.OwnerID.String == owner {
+		return true, nil
+	}
+	grants, err := l.storage.GetAccountGrants(ctx, accountID)
+	if err != nil {
+		return false, err
+	}
+	for _, grant := range grants {
+		if grant.GranteeID == owner {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkAccountsAccess returns ErrForbidden if the principal of the context has an owner and cannot access one of the
+// accounts.
+func (l *Ledger) checkAccountsAccess(ctx context.Context, accountIDs ...string) error {
+	owner := accountOwner(ctx)
+	if owner == "" {
+		return nil
+	}
+	for _, accountID := range accountIDs {
+		ok, err := l.canAccessAccount(ctx, owner, accountID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: account_id %s cannot be accessed by %s", ErrForbidden, accountID, owner)
+		}
+	}
+	return nil
+}
+
+// checkAnyAccountAccess returns ErrForbidden if the principal of the context has an owner and cannot access any of the
+// accounts. It is used for the records of multiple accounts, like the transactions and the holds, which can be read by
+// the owner of any of the accounts.
+func (l *Ledger) checkAnyAccountAccess(ctx context.Context, accountIDs ...string) error {
+	owner := accountOwner(ctx)
+	if owner == "" {
+		return nil
+	}
+	for _, accountID := range accountIDs {
+		ok, err := l.canAccessAccount(ctx, owner, accountID)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: none of the accounts can be accessed by %s", ErrForbidden, owner)
+}
+
+// checkDebitsAccess returns ErrForbidden if the principal of the context has an owner and the transaction debits an
+// account that cannot be accessed by the owner, so the money can only be moved out of the accessible accounts. The
+// ledgerDebits are excluded, see ledgerDebits.
+func (l *Ledger) checkDebitsAccess(ctx context.Context, summaries txSumaries, ledgerDebits map[string]bool) error {
+	if accountOwner(ctx) == "" {
+		return nil
+	}
+	var debits []string
+	for accountID, sum := range summaries {
+		if sum.IsNegative() && !ledgerDebits[accountID] {
+			debits = append(debits, accountID)
+		}
+	}
+	return l.checkAccountsAccess(ctx, debits...)
+}
@@ -0,0 +1,389 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/albertwidi/ftest/auth"
+)
+
+// TestFundingAccess tests whether only the funding admin can create money, by creating a funding account or by debiting
+// the funding account.
+func TestFundingAccess(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{
+		ID:     "key-1",
+		Scopes: []string{auth.ScopeAccountsWrite, auth.ScopeTransfersWrite},
+	})
+	if _, err := testLedger.CreateAccount(ctx, "", AccountTypeFunding, DefaultCurrency); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	if _, err := testLedger.CreateAccount(ctx, "", AccountTypeUser, DefaultCurrency); err != nil {
+		t.Fatal(err)
+	}
+	_, err = testLedger.Transfer(ctx, Transfer{
+		FromAccount: fundingAccount.ID,
+		ToAccount:   acc1.ID,
+		Amount:      createDecimalFromString("100"),
+	})
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	checkAvailableAndPosted(t, testLedger, acc1.ID, "0", "0")
+
+	adminCtx := auth.WithPrincipal(context.Background(), auth.Principal{
+		ID:     "key-2",
+		Scopes: []string{auth.ScopeTransfersWrite, auth.ScopeFundingAdmin},
+	})
+	transactionID, err := testLedger.Transfer(adminCtx, Transfer{
+		FromAccount: fundingAccount.ID,
+		ToAccount:   acc1.ID,
+		Amount:      createDecimalFromString("100"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAvailableAndPosted(t, testLedger, acc1.ID, "100", "100")
+
+	// The transaction records the key that created it.
+	tx, err := testLedger.GetTransaction(context.Background(), transactionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.CreatedBy != "key-2" {
+		t.Fatalf("expecting created by key-2 but got %q", tx.CreatedBy)
+	}
+}
+
+// TestAccountOwner tests whether an end user can only read its own accounts and only move money out of its own accounts.
+func TestAccountOwner(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	owner1 := auth.WithPrincipal(context.Background(), auth.Principal{
+		ID:     "user-1",
+		Owner:  "owner-1",
+		Scopes: []string{auth.ScopeRead, auth.ScopeAccountsWrite, auth.ScopeTransfersWrite},
+	})
+	owner2 := auth.WithPrincipal(context.Background(), auth.Principal{
+		ID:     "user-2",
+		Owner:  "owner-2",
+		Scopes: []string{auth.ScopeRead, auth.ScopeAccountsWrite, auth.ScopeTransfersWrite},
+	})
+	acc1, err := testLedger.CreateAccount(owner1, "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc1.OwnerID != "owner-1" {
+		t.Fatalf("expecting owner owner-1 but got %q", acc1.OwnerID)
+	}
+	acc2, err := testLedger.CreateAccount(owner2, "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fundingTxID, err := testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: fundingAccount.ID,
+		ToAccount:   acc1.ID,
+		Amount:      createDecimalFromString("100"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner can read its account and the transactions of its account.
+	if _, err := testLedger.GetAccountBalance(owner1, acc1.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testLedger.GetAccountLedgerEntries(owner1, acc1.ID, LedgerEntriesFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testLedger.GetTransaction(owner1, fundingTxID); err != nil {
+		t.Fatal(err)
+	}
+	// Other end user cannot.
+	if _, err := testLedger.GetAccountBalance(owner2, acc1.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	if _, err := testLedger.GetAccountLedgerEntries(owner2, acc1.ID, LedgerEntriesFilter{}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	if _, err := testLedger.GetTransaction(owner2, fundingTxID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+
+	// The owner can transfer from its account to other account, but not the other way around.
+	if _, err := testLedger.Transfer(owner1, Transfer{
+		FromAccount: acc1.ID,
+		ToAccount:   acc2.ID,
+		Amount:      createDecimalFromString("40"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = testLedger.Transfer(owner1, Transfer{
+		FromAccount: acc2.ID,
+		ToAccount:   acc1.ID,
+		Amount:      createDecimalFromString("40"),
+	})
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	_, err = testLedger.Authorize(owner2, Authorization{
+		FromAccount: acc1.ID,
+		ToAccount:   acc2.ID,
+		Amount:      createDecimalFromString("10"),
+	})
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	checkAvailableAndPosted(t, testLedger, acc1.ID, "60", "60")
+	checkAvailableAndPosted(t, testLedger, acc2.ID, "40", "40")
+
+	// The hold can be read by both owners, but only voided by the owner of the debited account.
+	hold, err := testLedger.Authorize(owner1, Authorization{
+		FromAccount: acc1.ID,
+		ToAccount:   acc2.ID,
+		Amount:      createDecimalFromString("10"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testLedger.GetHold(owner2, hold.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := testLedger.Void(owner2, hold.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	if err := testLedger.Void(owner1, hold.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAccountGrants tests whether the owner of an account can grant and revoke the delegate access to the account, and the
+// principals with an owner can only act on the accounts they own or are granted.
+func TestAccountGrants(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	scopes := []string{auth.ScopeRead, auth.ScopeAccountsWrite, auth.ScopeTransfersWrite}
+	tenant1 := auth.WithPrincipal(context.Background(), auth.Principal{ID: "key-1", Owner: "tenant-1", Scopes: scopes})
+	tenant2 := auth.WithPrincipal(context.Background(), auth.Principal{ID: "key-2", Owner: "tenant-2", Scopes: scopes})
+
+	// Only the platform can create an account for other owner.
+	if _, err := testLedger.CreateOwnedAccount(tenant2, "tenant-1", "", AccountTypeUser, DefaultCurrency); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	acc1, err := testLedger.CreateOwnedAccount(context.Background(), "tenant-1", "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc2, err := testLedger.CreateAccount(tenant2, "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fundingAccount := createFundingAccount(t, testLedger)
+	transferAndCheck(t, testLedger, Transfer{
+		FromAccount: fundingAccount.ID,
+		ToAccount:   acc1.ID,
+		Amount:      createDecimalFromString("100"),
+	})
+
+	if _, err := testLedger.GetAccountBalance(tenant2, acc1.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	// Only the owner can manage the grants.
+	if _, err := testLedger.GrantAccess(tenant2, acc1.ID, "tenant-2"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	// The account that doesn't exist is forbidden as well, so the owner cannot tell whether the account exists.
+	if _, err := testLedger.GrantAccess(tenant2, "not-exist", "tenant-2"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	if err := testLedger.RevokeAccess(tenant2, "not-exist", "tenant-2"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	if _, err := testLedger.GrantAccess(context.Background(), "not-exist", "tenant-2"); !errors.Is(err, ErrAccountNotFound) {
+		t.Fatalf("expecting error %v but got %v", ErrAccountNotFound, err)
+	}
+	grant, err := testLedger.GrantAccess(tenant1, acc1.ID, "tenant-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.CreatedBy != "key-1" {
+		t.Fatalf("expecting created by key-1 but got %q", grant.CreatedBy)
+	}
+	// Granting the same access again doesn't fail.
+	if _, err := testLedger.GrantAccess(tenant1, acc1.ID, "tenant-2"); err != nil {
+		t.Fatal(err)
+	}
+	grants, err := testLedger.GetAccountGrants(tenant2, acc1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 1 || grants[0].GranteeID != "tenant-2" {
+		t.Fatalf("unexpected grants %+v", grants)
+	}
+
+	// The grantee can act on the account, but cannot grant the access to others.
+	if _, err := testLedger.GetAccountBalance(tenant2, acc1.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testLedger.Transfer(tenant2, Transfer{
+		FromAccount: acc1.ID,
+		ToAccount:   acc2.ID,
+		Amount:      createDecimalFromString("30"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testLedger.GrantAccess(tenant2, acc1.ID, "tenant-3"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	checkAvailableAndPosted(t, testLedger, acc1.ID, "70", "70")
+
+	if err := testLedger.RevokeAccess(tenant1, acc1.ID, "tenant-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testLedger.RevokeAccess(tenant1, acc1.ID, "tenant-2"); !errors.Is(err, ErrGrantNotFound) {
+		t.Fatalf("expecting error %v but got %v", ErrGrantNotFound, err)
+	}
+	_, err = testLedger.Transfer(tenant2, Transfer{
+		FromAccount: acc1.ID,
+		ToAccount:   acc2.ID,
+		Amount:      createDecimalFromString("30"),
+	})
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+}
+
+// TestFXPositionDebits tests the FX position accounts can only be debited by the conversions of the ledger. The position
+// accounts allow negative balance, so the posting of the client that debits them requires the funding admin.
+func TestFXPositionDebits(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	usdFunding, err := testLedger.CreateAccount(context.Background(), "", AccountTypeFunding, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usdAccount, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpyAccount, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, "JPY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: usdFunding.ID,
+		ToAccount:   usdAccount.ID,
+		Amount:      createDecimalFromString("100"),
+		Currency:    "USD",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testLedger.CreateFXRate(context.Background(), FXRate{
+		BaseCurrency:  "USD",
+		QuoteCurrency: "JPY",
+		Rate:          createDecimalFromString("150"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := testLedger.ensureFXPositions(context.Background(), "USD", "JPY"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{
+		ID:     "key-1",
+		Scopes: []string{auth.ScopeRead, auth.ScopeAccountsWrite, auth.ScopeTransfersWrite},
+	})
+	_, err = testLedger.Post(ctx, Posting{
+		Currency: "USD",
+		Debits:   []Entry{{AccountID: FXPositionAccountID("USD"), Amount: createDecimalFromString("1000000")}},
+		Credits:  []Entry{{AccountID: usdAccount.ID, Amount: createDecimalFromString("1000000")}},
+	}, "")
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	_, err = testLedger.Transfer(ctx, Transfer{
+		FromAccount: FXPositionAccountID("USD"),
+		ToAccount:   usdAccount.ID,
+		Amount:      createDecimalFromString("1000000"),
+		Currency:    "USD",
+	})
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	checkAvailableAndPosted(t, testLedger, usdAccount.ID, "100", "100")
+
+	// The conversion and its reversal debit the position accounts with the amount of the FX rate.
+	result, err := testLedger.Convert(ctx, Conversion{
+		FromAccount: usdAccount.ID,
+		ToAccount:   jpyAccount.ID,
+		Amount:      createDecimalFromString("10"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAvailableAndPosted(t, testLedger, jpyAccount.ID, "1500", "1500")
+	if _, err := testLedger.Reverse(ctx, result.TransactionID, decimal.Zero); err != nil {
+		t.Fatal(err)
+	}
+	checkAvailableAndPosted(t, testLedger, usdAccount.ID, "100", "100")
+	checkAvailableAndPosted(t, testLedger, jpyAccount.ID, "0", "0")
+	checkAvailableAndPosted(t, testLedger, FXPositionAccountID("USD"), "0", "0")
+	checkAvailableAndPosted(t, testLedger, FXPositionAccountID("JPY"), "0", "0")
+
+	// The principal with owner cannot debit the position accounts it doesn't own, even with the funding admin.
+	ownerCtx := auth.WithPrincipal(context.Background(), auth.Principal{
+		ID:     "user-1",
+		Owner:  "owner-1",
+		Scopes: []string{auth.ScopeRead, auth.ScopeAccountsWrite, auth.ScopeTransfersWrite, auth.ScopeFundingAdmin},
+	})
+	ownerUSD, err := testLedger.CreateAccount(ownerCtx, "", AccountTypeUser, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerJPY, err := testLedger.CreateAccount(ownerCtx, "", AccountTypeUser, "JPY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testLedger.Post(ownerCtx, Posting{
+		Currency: "USD",
+		Debits:   []Entry{{AccountID: FXPositionAccountID("USD"), Amount: createDecimalFromString("1000000")}},
+		Credits:  []Entry{{AccountID: ownerUSD.ID, Amount: createDecimalFromString("1000000")}},
+	}, "")
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+	checkAvailableAndPosted(t, testLedger, ownerUSD.ID, "0", "0")
+
+	// The owner can still convert the money of its own account.
+	if _, err := testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: usdFunding.ID,
+		ToAccount:   ownerUSD.ID,
+		Amount:      createDecimalFromString("10"),
+		Currency:    "USD",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testLedger.Convert(ownerCtx, Conversion{
+		FromAccount: ownerUSD.ID,
+		ToAccount:   ownerJPY.ID,
+		Amount:      createDecimalFromString("10"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkAvailableAndPosted(t, testLedger, ownerJPY.ID, "1500", "1500")
+}
@@ -0,0 +1,208 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/albertwidi/ftest/ledger/internal"
+)
+
+const (
+	HoldStatusAuthorized = internal.HoldStatusAuthorized
+	HoldStatusCaptured   = internal.HoldStatusCaptured
+	HoldStatusVoided     = internal.HoldStatusVoided
+)
+
+// Authorization is the first phase of a two-phase transfer. The authorization reserves the amount from the FromAccount
+// and the money is moved to the ToAccount when the hold is captured.
+type Authorization struct {
+	FromAccount string
+	ToAccount   string
+	Amount      decimal.Decimal
+	// Currency is the currency of the Amount, DefaultCurrency is used if the currency is empty.
+	Currency string
+}
+
+func (a Authorization) validate() error {
+	if a.FromAccount == "" {
+		return errors.New("from account cannot be empty")
+	}
+	if a.ToAccount == "" {
+		return errors.New("to account cannot be empty")
+	}
+	if a.FromAccount == a.ToAccount {
+		return errors.New("from account and to account cannot be the same")
+	}
+	if !a.Amount.IsPositive() {
+		return errors.New("amount must be positive")
+	}
+	return validateAmountScale(a.Amount, currencyOrDefault(a.Currency))
+}
+
+// Hold is the record of an authorization. The hold reduces the available balance of the FromAccount until it is captured or voided.
+type Hold struct {
+	ID             string
+	FromAccount    string
+	ToAccount      string
+	Amount         decimal.Decimal
+	Currency       string
+	CapturedAmount decimal.Decimal
+	Status         string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Remaining returns the amount of the hold that can still be captured.
+func (h Hold) Remaining() decimal.Decimal {
+	if h.Status != HoldStatusAuthorized {
+		return decimal.Zero
+	}
+	return h.Amount.Sub(h.CapturedAmount)
+}
+
+// Authorize reserves the amount of the authorization from the FromAccount without posting any ledger entries.
+func (l *Ledger) Authorize(ctx context.Context, auth Authorization) (Hold, error) {
+	if err := auth.validate(); err != nil {
+		return Hold{}, validationError(err)
+	}
+	currency := currencyOrDefault(auth.Currency)
+	if err := l.checkAccountsAccess(ctx, auth.FromAccount); err != nil {
+		return Hold{}, err
+	}
+	// Pre-check the accounts, the available balance of the FromAccount is checked again when the hold is created.
+	if err := l.checkBalances(ctx, txSumaries{
+		auth.FromAccount: auth.Amount.Neg(),
+		auth.ToAccount:   auth.Amount,
+	}, map[string]string{
+		auth.FromAccount: currency,
+		auth.ToAccount:   currency,
+	}, nil); err != nil {
+		return Hold{}, err
+	}
+
+	createdAt := time.Now()
+	hold := internal.Hold{
+		HoldID:      uuid.NewString(),
+		FromAccount: auth.FromAccount,
+		ToAccount:   auth.ToAccount,
+		Amount:      auth.Amount,
+		Currency:    currency,
+		CreatedAt:   createdAt,
+	}
+	if err := l.storage.CreateHold(ctx, hold); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Hold{}, ErrAccountNotFound
+		}
+		return Hold{}, err
+	}
+	return Hold{
+		ID:             hold.HoldID,
+		FromAccount:    hold.FromAccount,
+		ToAccount:      hold.ToAccount,
+		Amount:         hold.Amount,
+		Currency:       hold.Currency,
+		CapturedAmount: decimal.Zero,
+		Status:         HoldStatusAuthorized,
+		CreatedAt:      createdAt,
+		UpdatedAt:      createdAt,
+	}, nil
+}
+
+// GetHold returns the hold by its id.
+func (l *Ledger) GetHold(ctx context.Context, holdID string) (Hold, error) {
+	hold, err := l.storage.GetHold(ctx, holdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Hold{}, ErrHoldNotFound
+		}
+		return Hold{}, err
+	}
+	if err := l.checkAnyAccountAccess(ctx, hold.FromAccount, hold.ToAccount); err != nil {
+		return Hold{}, err
+	}
+	return Hold{
+		ID:             hold.HoldID,
+		FromAccount:    hold.FromAccount,
+		ToAccount:      hold.ToAccount,
+		Amount:         hold.Amount,
+		Currency:       hold.Currency,
+		CapturedAmount: hold.CapturedAmount,
+		Status:         hold.Status,
+		CreatedAt:      hold.CreatedAt,
+		UpdatedAt:      hold.UpdatedAt.Time,
+	}, nil
+}
+
+// Capture moves the amount from the hold to the ToAccount and returns the transaction_id. The hold can be captured partially
+// multiple times, and a zero amount captures all the remaining amount of the hold.
+//
+// The capture that is aborted because of a deadlock or a serialization failure is retried from the start, so the remaining
+// amount of the hold is read again.
+func (l *Ledger) Capture(ctx context.Context, holdID string, amount decimal.Decimal) (string, error) {
+	return l.retryOnConflict(ctx, "capture", func() (string, error) {
+		return l.capture(ctx, holdID, amount)
+	})
+}
+
+// capture is a single attempt of Capture.
+func (l *Ledger) capture(ctx context.Context, holdID string, amount decimal.Decimal) (string, error) {
+	if amount.IsNegative() {
+		return "", validationError(errors.New("capture amount cannot be negative"))
+	}
+	hold, err := l.GetHold(ctx, holdID)
+	if err != nil {
+		return "", err
+	}
+	if err := l.checkAccountsAccess(ctx, hold.FromAccount); err != nil {
+		return "", err
+	}
+	if hold.Status != HoldStatusAuthorized {
+		return "", ErrHoldNotActive
+	}
+	if amount.IsZero() {
+		amount = hold.Remaining()
+	}
+
+	txID := uuid.NewString()
+	tx, err := buildTransaction(txID, Transfer{
+		FromAccount: hold.FromAccount,
+		ToAccount:   hold.ToAccount,
+		Amount:      amount,
+		Currency:    hold.Currency,
+	})
+	if err != nil {
+		return txID, err
+	}
+	tx.TransactionType = TransactionTypeCapture
+	tx.CreatedBy = createdBy(ctx)
+	// The remaining amount and the status of the hold is checked again inside the database transaction, as the hold might
+	// be captured or voided concurrently.
+	if err := l.storage.CaptureHold(ctx, holdID, amount, tx); err != nil {
+		return txID, err
+	}
+	return txID, nil
+}
+
+// Void voids the hold and releases the remaining amount back to the available balance of the FromAccount.
+func (l *Ledger) Void(ctx context.Context, holdID string) error {
+	// The client with an owner can only void the holds of the accounts it can access.
+	if accountOwner(ctx) != "" {
+		hold, err := l.GetHold(ctx, holdID)
+		if err != nil {
+			return err
+		}
+		if err := l.checkAccountsAccess(ctx, hold.FromAccount); err != nil {
+			return err
+		}
+	}
+	err := l.storage.VoidHold(ctx, holdID, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrHoldNotFound
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// TestAuthorizeCaptureVoid tests the two-phase transfer flow. The hold should reduce the available balance without
+// changing the posted balance until it is captured.
+func TestAuthorizeCaptureVoid(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc2, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: fundingAccount.ID,
+		ToAccount:   acc1.ID,
+		Amount:      createDecimalFromString("100"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hold, err := testLedger.Authorize(context.Background(), Authorization{
+		FromAccount: acc1.ID,
+		ToAccount:   acc2.ID,
+		Amount:      createDecimalFromString("100"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAvailableAndPosted(t, testLedger, acc1.ID, "0", "100")
+
+	// All the money is held, so the account cannot transfer or authorize anymore.
+	_, err = testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: acc1.ID,
+		ToAccount:   acc2.ID,
+		Amount:      createDecimalFromString("1"),
+	})
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expecting error %v but got %v", ErrInsufficientBalance, err)
+	}
+
+	// Capture the hold partially.
+	if _, err := testLedger.Capture(context.Background(), hold.ID, createDecimalFromString("40")); err != nil {
+		t.Fatal(err)
+	}
+	checkAvailableAndPosted(t, testLedger, acc1.ID, "0", "60")
+	checkAvailableAndPosted(t, testLedger, acc2.ID, "40", "40")
+
+	// Capturing more than the remaining amount should be rejected.
+	_, err = testLedger.Capture(context.Background(), hold.ID, createDecimalFromString("61"))
+	if !errors.Is(err, ErrCaptureExceedsHold) {
+		t.Fatalf("expecting error %v but got %v", ErrCaptureExceedsHold, err)
+	}
+
+	// Void releases the remaining amount of the hold.
+	if err := testLedger.Void(context.Background(), hold.ID); err != nil {
+		t.Fatal(err)
+	}
+	checkAvailableAndPosted(t, testLedger, acc1.ID, "60", "60")
+
+	hold, err = testLedger.GetHold(context.Background(), hold.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hold.Status != HoldStatusVoided {
+		t.Fatalf("expecting hold status %s but got %s", HoldStatusVoided, hold.Status)
+	}
+	_, err = testLedger.Capture(context.Background(), hold.ID, decimal.Zero)
+	if !errors.Is(err, ErrHoldNotActive) {
+		t.Fatalf("expecting error %v but got %v", ErrHoldNotActive, err)
+	}
+}
+
+func checkAvailableAndPosted(t *testing.T, testLedger *Ledger, accountID, available, posted string) {
+	t.Helper()
+
+	balance, err := testLedger.GetAccountBalance(context.Background(), accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.AvailableBalance.Cmp(createDecimalFromString(available)) != 0 {
+		t.Fatalf("expecting available balance %s but got %s", available, balance.AvailableBalance.String())
+	}
+	if balance.Balance.Cmp(createDecimalFromString(posted)) != 0 {
+		t.Fatalf("expecting posted balance %s but got %s", posted, balance.Balance.String())
+	}
+}
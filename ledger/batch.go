@@ -0,0 +1,193 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/albertwidi/ftest/ledger/internal"
+)
+
+const (
+	// DefaultBatchMaxLatency is the default maximum time a transaction waits for other transactions to join its batch.
+	DefaultBatchMaxLatency = 2 * time.Millisecond
+	// DefaultBatchTimeout is the default maximum duration of the database transaction of a batch.
+	DefaultBatchTimeout = 10 * time.Second
+	// MaxBatchSize is the maximum number of transactions in a batch. The locks of all transactions in a batch are held
+	// until the batch is committed, so a big batch makes the other transactions wait longer.
+	MaxBatchSize = 1000
+)
+
+// BatchConfig is the configuration of the group commit of the transactions. The transactions that arrive within MaxLatency
+// are created in one database transaction, so they only pay for a single commit.
+type BatchConfig struct {
+	// MaxSize is the maximum number of transactions in a batch, the batch is created as soon as it is full. The batching
+	// is disabled if the size is zero or one.
+	MaxSize int
+	// MaxLatency is the maximum time the first transaction of a batch waits for the other transactions, DefaultBatchMaxLatency
+	// is used if empty.
+	MaxLatency time.Duration
+	// Timeout is the maximum duration of the database transaction of a batch, DefaultBatchTimeout is used if empty. The
+	// batch is also canceled after the deadline of the last transaction in the batch, it should be the same with the
+	// write timeout of the requests.
+	Timeout time.Duration
+}
+
+func (c BatchConfig) enabled() bool {
+	return c.MaxSize > 1
+}
+
+func (c BatchConfig) validate() error {
+	if c.MaxSize < 0 || c.MaxSize > MaxBatchSize {
+		return fmt.Errorf("batch size must be between 0 and %d", MaxBatchSize)
+	}
+	if c.MaxLatency < 0 {
+		return errors.New("batch latency cannot be negative")
+	}
+	if c.Timeout < 0 {
+		return errors.New("batch timeout cannot be negative")
+	}
+	return nil
+}
+
+// batchItem is a transaction waiting in a batch, the result of the transaction is sent to done.
+type batchItem struct {
+	ctx  context.Context
+	tx   internal.CreateTransaction
+	done chan error
+}
+
+// batch is the transactions that are created together. full is closed when the batch reaches the maximum size.
+type batch struct {
+	items []*batchItem
+	full  chan struct{}
+}
+
+// batcher groups the transactions into batches. There is no background goroutine, the first transaction of a batch becomes
+// the leader of the batch. The leader waits until the batch is full or the latency is reached, then creates all transactions
+// of the batch and sends the result to every transaction.
+type batcher struct {
+	config  BatchConfig
+	storage internal.Storage
+
+	mu      sync.Mutex
+	pending *batch
+	stopped bool
+	// flushing is the batches that are waiting or being created, stop waits for them.
+	flushing sync.WaitGroup
+}
+
+func newBatcher(config BatchConfig, storage internal.Storage) *batcher {
+	if config.MaxLatency == 0 {
+		config.MaxLatency = DefaultBatchMaxLatency
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultBatchTimeout
+	}
+	return &batcher{
+		config:  config,
+		storage: storage,
+	}
+}
+
+// createTransaction adds the transaction to the pending batch and waits for the result. The caller always waits for the
+// result of the batch, which is bounded by the timeout of the batch, so the caller never gets an error for a transaction
+// that is created. The transaction is created without batching after the batcher is stopped.
+func (b *batcher) createTransaction(ctx context.Context, tx internal.CreateTransaction) error {
+	item := &batchItem{ctx: ctx, tx: tx, done: make(chan error, 1)}
+
+	b.mu.Lock()
+	if b.stopped {
+		b.mu.Unlock()
+		return b.storage.CreateTransaction(ctx, tx)
+	}
+	current := b.pending
+	leader := current == nil
+	if leader {
+		current = &batch{full: make(chan struct{})}
+		b.pending = current
+		b.flushing.Add(1)
+	}
+	current.items = append(current.items, item)
+	if len(current.items) >= b.config.MaxSize {
+		b.pending = nil
+		close(current.full)
+	}
+	b.mu.Unlock()
+
+	if leader {
+		b.flush(ctx, current)
+	}
+	return <-item.done
+}
+
+// flush waits until the batch is full or the latency is reached, then creates all transactions of the batch. The batch is
+// created with a context that is not canceled with the context of the leader, because the batch also belongs to the other
+// transactions. The context has the deadline of the last transaction in the batch, bounded by the timeout of the batch.
+func (b *batcher) flush(ctx context.Context, current *batch) {
+	defer b.flushing.Done()
+
+	timer := time.NewTimer(b.config.MaxLatency)
+	select {
+	case <-current.full:
+	case <-timer.C:
+	}
+	timer.Stop()
+
+	b.mu.Lock()
+	if b.pending == current {
+		b.pending = nil
+	}
+	b.mu.Unlock()
+
+	// The transactions that are canceled while waiting for the batch are not created, and their callers get the error of
+	// their context.
+	items := make([]*batchItem, 0, len(current.items))
+	deadline := time.Now().Add(b.config.Timeout)
+	var last time.Time
+	for _, item := range current.items {
+		if err := item.ctx.Err(); err != nil {
+			item.done <- err
+			continue
+		}
+		items = append(items, item)
+		itemDeadline, ok := item.ctx.Deadline()
+		if !ok {
+			itemDeadline = deadline
+		}
+		if itemDeadline.After(last) {
+			last = itemDeadline
+		}
+	}
+	if len(items) == 0 {
+		return
+	}
+	if last.Before(deadline) {
+		deadline = last
+	}
+	ctx, cancel := context.WithDeadline(context.WithoutCancel(ctx), deadline)
+	defer cancel()
+
+	txs := make([]internal.CreateTransaction, len(items))
+	for i, item := range items {
+		txs[i] = item.tx
+	}
+	errs := b.storage.CreateTransactions(ctx, txs)
+	for i, item := range items {
+		item.done <- errs[i]
+	}
+}
+
+// stop creates the pending batch without waiting for the latency, and waits until all batches are created.
+func (b *batcher) stop() {
+	b.mu.Lock()
+	b.stopped = true
+	if b.pending != nil {
+		close(b.pending.full)
+		b.pending = nil
+	}
+	b.mu.Unlock()
+	b.flushing.Wait()
+}
@@ -0,0 +1,240 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/albertwidi/ftest/ledger/internal"
+)
+
+// batchRecorder records the size and the deadline of every batch that is created by the storage.
+type batchRecorder struct {
+	internal.Storage
+
+	mu        sync.Mutex
+	sizes     []int
+	deadlines []time.Time
+}
+
+func (r *batchRecorder) CreateTransactions(ctx context.Context, txs []internal.CreateTransaction) []error {
+	deadline, _ := ctx.Deadline()
+	r.mu.Lock()
+	r.sizes = append(r.sizes, len(txs))
+	r.deadlines = append(r.deadlines, deadline)
+	r.mu.Unlock()
+	return r.Storage.CreateTransactions(ctx, txs)
+}
+
+func newBatchLedger(t *testing.T, config BatchConfig) (*Ledger, *batchRecorder) {
+	testLedger := NewTest(t)
+	recorder := &batchRecorder{Storage: testLedger.storage}
+	testLedger.batcher = newBatcher(config, recorder)
+	return testLedger, recorder
+}
+
+// TestBatchTransfers tests whether the transfers are grouped into batches, and a failed transfer doesn't fail the other
+// transfers in the same batch.
+func TestBatchTransfers(t *testing.T) {
+	t.Parallel()
+	testLedger, recorder := newBatchLedger(t, BatchConfig{MaxSize: 5, MaxLatency: time.Second})
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	accounts := make([]Account, 10)
+	for i := range accounts {
+		acc, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts[i] = acc
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(accounts))
+	for i, acc := range accounts {
+		wg.Add(1)
+		go func(i int, acc Account) {
+			defer wg.Done()
+			// The odd accounts send money before they receive any, so the transfers fail with insufficient balance.
+			from, to := fundingAccount.ID, acc.ID
+			if i%2 == 1 {
+				from, to = acc.ID, fundingAccount.ID
+			}
+			_, errs[i] = testLedger.Transfer(context.Background(), Transfer{
+				FromAccount: from,
+				ToAccount:   to,
+				Amount:      createDecimalFromString("10"),
+			})
+		}(i, acc)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if i%2 == 1 {
+			if !errors.Is(err, ErrInsufficientBalance) {
+				t.Fatalf("expecting error %v but got %v", ErrInsufficientBalance, err)
+			}
+			checkAvailableAndPosted(t, testLedger, accounts[i].ID, "0", "0")
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkAvailableAndPosted(t, testLedger, accounts[i].ID, "10", "10")
+	}
+	checkAvailableAndPosted(t, testLedger, fundingAccount.ID, "-50", "-50")
+
+	// The batches are only created when they are full, because the latency is longer than the test. The insufficient
+	// balance of the odd accounts is detected before the transfer joins a batch.
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.sizes) != 1 || recorder.sizes[0] != 5 {
+		t.Fatalf("expecting one batch of 5 transfers but got %v", recorder.sizes)
+	}
+}
+
+// TestBatchMaxLatency tests whether the batch is created after the latency even if the batch is not full.
+func TestBatchMaxLatency(t *testing.T) {
+	t.Parallel()
+	testLedger, recorder := newBatchLedger(t, BatchConfig{MaxSize: 100, MaxLatency: 10 * time.Millisecond})
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transferAndCheck(t, testLedger, Transfer{
+		FromAccount: fundingAccount.ID,
+		ToAccount:   acc.ID,
+		Amount:      createDecimalFromString("10"),
+	})
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.sizes) != 1 || recorder.sizes[0] != 1 {
+		t.Fatalf("expecting one batch of 1 transfer but got %v", recorder.sizes)
+	}
+}
+
+// TestBatchDeadline tests whether the batch has the deadline of its transactions, and the canceled transaction is not
+// created by the batch.
+func TestBatchDeadline(t *testing.T) {
+	t.Parallel()
+	testLedger, recorder := newBatchLedger(t, BatchConfig{MaxSize: 2, MaxLatency: time.Hour, Timeout: time.Hour})
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer := Transfer{FromAccount: fundingAccount.ID, ToAccount: acc.ID, Amount: createDecimalFromString("10")}
+
+	// The first transfer is canceled while it waits for the second transfer to fill the batch.
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := testLedger.Transfer(canceledCtx, transfer)
+		canceled <- err
+	}()
+	waitPendingBatch(t, testLedger.batcher)
+	cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := testLedger.Transfer(ctx, transfer); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expecting error %v but got %v", context.Canceled, err)
+	}
+	checkAvailableAndPosted(t, testLedger, acc.ID, "10", "10")
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	expectDeadline, _ := ctx.Deadline()
+	if len(recorder.sizes) != 1 || recorder.sizes[0] != 1 || !recorder.deadlines[0].Equal(expectDeadline) {
+		t.Fatalf("expecting one batch of 1 transfer with deadline %s but got %v with %v", expectDeadline, recorder.sizes, recorder.deadlines)
+	}
+}
+
+// TestBatchStop tests whether stop creates the pending batch without waiting for the latency, and the transactions after
+// stop are created without batching.
+func TestBatchStop(t *testing.T) {
+	t.Parallel()
+	testLedger, recorder := newBatchLedger(t, BatchConfig{MaxSize: 100, MaxLatency: time.Hour})
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer := Transfer{FromAccount: fundingAccount.ID, ToAccount: acc.ID, Amount: createDecimalFromString("10")}
+
+	pending := make(chan error, 1)
+	go func() {
+		_, err := testLedger.Transfer(context.Background(), transfer)
+		pending <- err
+	}()
+	waitPendingBatch(t, testLedger.batcher)
+	testLedger.Stop()
+	// Stop returns after the batch is created, so the result is already sent.
+	select {
+	case err := <-pending:
+		if err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatal("expecting the pending transfer to be created by stop")
+	}
+
+	transferAndCheck(t, testLedger, transfer)
+	checkAvailableAndPosted(t, testLedger, acc.ID, "20", "20")
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.sizes) != 1 || recorder.sizes[0] != 1 {
+		t.Fatalf("expecting one batch of 1 transfer but got %v", recorder.sizes)
+	}
+}
+
+// waitPendingBatch waits until a transaction joins the pending batch of the batcher.
+func waitPendingBatch(t *testing.T, b *batcher) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		b.mu.Lock()
+		pending := b.pending != nil && len(b.pending.items) > 0
+		b.mu.Unlock()
+		if pending {
+			return
+		}
+	}
+	t.Fatal("no transaction joined the batch")
+}
+
+func TestBatchConfigValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		config    BatchConfig
+		expectErr bool
+	}{
+		{name: "disabled", config: BatchConfig{}},
+		{name: "enabled", config: BatchConfig{MaxSize: 10, MaxLatency: time.Millisecond}},
+		{name: "negative size", config: BatchConfig{MaxSize: -1}, expectErr: true},
+		{name: "too big", config: BatchConfig{MaxSize: MaxBatchSize + 1}, expectErr: true},
+		{name: "negative latency", config: BatchConfig{MaxSize: 10, MaxLatency: -time.Millisecond}, expectErr: true},
+		{name: "negative timeout", config: BatchConfig{MaxSize: 10, Timeout: -time.Millisecond}, expectErr: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			if err := test.config.validate(); (err != nil) != test.expectErr {
+				t.Fatalf("expecting error %t but got %v", test.expectErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package ledger
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// DefaultCurrency is the currency used when the currency of an account or a transaction is not specified.
+const DefaultCurrency = "IDR"
+
+// currencyMinorUnits is the list of supported ISO 4217 currencies along with their minor unit. The minor unit is the number
+// of decimal places allowed for the amount in the currency, for example 2 for USD(cents) and 0 for JPY.
+var currencyMinorUnits = map[string]int32{
+	"AUD": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"IDR": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"MYR": 2,
+	"PHP": 2,
+	"SGD": 2,
+	"THB": 2,
+	"USD": 2,
+	"VND": 0,
+}
+
+// validateCurrency checks whether the currency is a supported ISO 4217 currency.
+func validateCurrency(currency string) error {
+	if _, ok := currencyMinorUnits[currency]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedCurrency, currency)
+	}
+	return nil
+}
+
+// validateAmountScale checks whether the amount fits the minor unit of the currency. For example, 10.001 is not a valid
+// amount for USD because USD only has two(2) decimal places.
+func validateAmountScale(amount decimal.Decimal, currency string) error {
+	minorUnit, ok := currencyMinorUnits[currency]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedCurrency, currency)
+	}
+	if !amount.Truncate(minorUnit).Equal(amount) {
+		return fmt.Errorf("%w: %s only allows %d decimal places", ErrInvalidAmountScale, currency, minorUnit)
+	}
+	return nil
+}
+
+// currencyOrDefault returns the DefaultCurrency if the currency is empty.
+func currencyOrDefault(currency string) string {
+	if currency == "" {
+		return DefaultCurrency
+	}
+	return currency
+}
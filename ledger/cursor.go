@@ -0,0 +1,32 @@
+package ledger
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// cursor is the position of a ledger entry in the account history. The cursor is encoded as an opaque string so
+// the client doesn't depend on its format.
+type cursor struct {
+	Timestamp     int64  `json:"t"`
+	TransactionID string `json:"id"`
+	// Backward means the cursor is used to get the entries before the position.
+	Backward bool `json:"b,omitempty"`
+}
+
+func (c cursor) encode() string {
+	out, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	out, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	c := cursor{}
+	if err := json.Unmarshal(out, &c); err != nil || c.TransactionID == "" {
+		return cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
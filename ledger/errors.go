@@ -1,11 +1,131 @@
 package ledger
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/albertwidi/ftest/auth"
+	"github.com/albertwidi/ftest/ledger/internal"
+)
 
 var (
-	ErrInsufficientBalance        = errors.New("insufficient balance")
+	// ErrInsufficientBalance is the same error with the error in the internal package, so the error can be checked with
+	// errors.Is regardless of which layer the balance is checked.
+	ErrInsufficientBalance        = internal.ErrInsufficientBalance
 	ErrLedgerEntriesTotalNotZero  = errors.New("non zero sum of ledger entries")
-	ErrInvalidLedgerEntriesLength = errors.New("ledger entries must have even length")
+	ErrInvalidLedgerEntriesLength = errors.New("ledger entries must have at least two entries")
+	ErrZeroLedgerEntryAmount      = errors.New("ledger entry amount cannot be zero")
 	ErrAllAccountsNotfound        = errors.New("all accounts not found")
-	ErrAccountNotFound            = errors.New("account not found")
+	ErrAccountNotFound            = internal.ErrAccountNotFound
+	ErrIdempotencyKeyMismatch     = errors.New("idempotency key already used for a different request")
+	ErrHoldNotFound               = errors.New("hold not found")
+	ErrHoldNotActive              = internal.ErrHoldNotActive
+	ErrCaptureExceedsHold         = internal.ErrCaptureExceedsHold
+	ErrTransactionNotFound        = errors.New("transaction not found")
+	ErrReversalExceedsAmount      = internal.ErrReversalExceedsAmount
+	ErrReverseReversal            = internal.ErrReverseReversal
+	ErrInvalidCursor              = errors.New("invalid cursor")
+	ErrUnsupportedCurrency        = errors.New("unsupported currency")
+	ErrInvalidAmountScale         = errors.New("amount has more decimal places than the currency allows")
+	ErrCurrencyMismatch           = internal.ErrCurrencyMismatch
+	ErrDuplicateLedgerEntry       = internal.ErrDuplicateLedgerEntry
+	ErrAccountAlreadyExists       = internal.ErrAccountAlreadyExists
+	ErrFXRateNotFound             = errors.New("fx rate not found")
+	ErrFXRateNotApplicable        = errors.New("fx rate cannot be used for the conversion")
+	ErrGrantNotFound              = errors.New("account grant not found")
+	// The errors of the authentication are the same with the errors in the auth package, the ledger returns ErrForbidden
+	// when the principal of the context doesn't have the scope for the operation.
+	ErrUnauthenticated = auth.ErrUnauthenticated
+	ErrForbidden       = auth.ErrForbidden
+	ErrAPIKeyNotFound  = auth.ErrAPIKeyNotFound
+	ErrInvalidAPIKey   = auth.ErrInvalidAPIKey
+	// ErrValidationFailed is returned when the request is invalid, the detail of the validation is wrapped along with the error.
+	ErrValidationFailed = errors.New("validation failed")
+)
+
+// Error codes are the stable and machine-readable codes of the ledger errors. Unlike the error message, the code is not
+// changed so the client can rely on it to handle the error.
+const (
+	CodeValidationFailed       = "VALIDATION_FAILED"
+	CodeInsufficientBalance    = "INSUFFICIENT_BALANCE"
+	CodeAccountNotFound        = "ACCOUNT_NOT_FOUND"
+	CodeAccountAlreadyExists   = "ACCOUNT_ALREADY_EXISTS"
+	CodeTransactionNotFound    = "TRANSACTION_NOT_FOUND"
+	CodeHoldNotFound           = "HOLD_NOT_FOUND"
+	CodeHoldNotActive          = "HOLD_NOT_ACTIVE"
+	CodeCaptureExceedsHold     = "CAPTURE_EXCEEDS_HOLD"
+	CodeReversalNotAllowed     = "REVERSAL_NOT_ALLOWED"
+	CodeIdempotencyKeyMismatch = "IDEMPOTENCY_KEY_MISMATCH"
+	CodeCurrencyMismatch       = "CURRENCY_MISMATCH"
+	CodeFXRateNotFound         = "FX_RATE_NOT_FOUND"
+	CodeFXRateNotApplicable    = "FX_RATE_NOT_APPLICABLE"
+	CodeUnauthenticated        = "UNAUTHENTICATED"
+	CodeForbidden              = "FORBIDDEN"
+	CodeAPIKeyNotFound         = "API_KEY_NOT_FOUND"
+	CodeGrantNotFound          = "GRANT_NOT_FOUND"
+	// CodeTransactionConflict is returned when the transaction keeps conflicting with other transactions, the request
+	// can be retried by the client.
+	CodeTransactionConflict = "TRANSACTION_CONFLICT"
+	// CodeTimeout is returned when the operation is canceled before it is done, either because the deadline of the
+	// operation is reached or the client is gone. A canceled write is rolled back.
+	CodeTimeout = "TIMEOUT"
+	// CodeInternal is the code of all errors that are not listed, the error is not caused by the request.
+	CodeInternal = "INTERNAL"
 )
+
+// errorCodes maps the errors to their code. The list is checked in order with errors.Is, so the more specific error
+// must be listed first.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{err: ErrInsufficientBalance, code: CodeInsufficientBalance},
+	{err: ErrAccountNotFound, code: CodeAccountNotFound},
+	{err: ErrAllAccountsNotfound, code: CodeAccountNotFound},
+	{err: ErrAccountAlreadyExists, code: CodeAccountAlreadyExists},
+	{err: ErrTransactionNotFound, code: CodeTransactionNotFound},
+	{err: ErrHoldNotFound, code: CodeHoldNotFound},
+	{err: ErrHoldNotActive, code: CodeHoldNotActive},
+	{err: ErrCaptureExceedsHold, code: CodeCaptureExceedsHold},
+	{err: ErrReversalExceedsAmount, code: CodeReversalNotAllowed},
+	{err: ErrReverseReversal, code: CodeReversalNotAllowed},
+	{err: ErrIdempotencyKeyMismatch, code: CodeIdempotencyKeyMismatch},
+	{err: ErrCurrencyMismatch, code: CodeCurrencyMismatch},
+	{err: ErrFXRateNotFound, code: CodeFXRateNotFound},
+	{err: ErrFXRateNotApplicable, code: CodeFXRateNotApplicable},
+	{err: ErrTransactionConflict, code: CodeTransactionConflict},
+	{err: ErrUnauthenticated, code: CodeUnauthenticated},
+	{err: ErrForbidden, code: CodeForbidden},
+	{err: ErrAPIKeyNotFound, code: CodeAPIKeyNotFound},
+	{err: ErrGrantNotFound, code: CodeGrantNotFound},
+	{err: ErrInvalidAPIKey, code: CodeValidationFailed},
+	{err: ErrValidationFailed, code: CodeValidationFailed},
+	{err: ErrLedgerEntriesTotalNotZero, code: CodeValidationFailed},
+	{err: ErrInvalidLedgerEntriesLength, code: CodeValidationFailed},
+	{err: ErrZeroLedgerEntryAmount, code: CodeValidationFailed},
+	{err: ErrDuplicateLedgerEntry, code: CodeValidationFailed},
+	{err: ErrInvalidCursor, code: CodeValidationFailed},
+	{err: ErrUnsupportedCurrency, code: CodeValidationFailed},
+	{err: ErrInvalidAmountScale, code: CodeValidationFailed},
+}
+
+// ErrorCode returns the code of the error. CodeInternal is returned if the error is not one of the ledger errors.
+func ErrorCode(err error) string {
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			return ec.code
+		}
+	}
+	if internal.IsCanceled(err) {
+		return CodeTimeout
+	}
+	return CodeInternal
+}
+
+// validationError marks the error as ErrValidationFailed. The error that already has its own code is returned as is.
+func validationError(err error) error {
+	if err == nil || ErrorCode(err) != CodeInternal {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrValidationFailed, err)
+}
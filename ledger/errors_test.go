@@ -0,0 +1,66 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		expect string
+	}{
+		{
+			name:   "wrapped insufficient balance",
+			err:    fmt.Errorf("%w: account_id a", ErrInsufficientBalance),
+			expect: CodeInsufficientBalance,
+		},
+		{
+			name:   "validation error",
+			err:    validationError(errors.New("from account cannot be empty")),
+			expect: CodeValidationFailed,
+		},
+		{
+			name:   "validation error keeps the code of the coded error",
+			err:    validationError(fmt.Errorf("%w: XYZ", ErrUnsupportedCurrency)),
+			expect: CodeValidationFailed,
+		},
+		{
+			name:   "validation error keeps the code of non validation error",
+			err:    validationError(ErrCurrencyMismatch),
+			expect: CodeCurrencyMismatch,
+		},
+		{
+			name:   "duplicate ledger entry of the storage",
+			err:    fmt.Errorf("%w: account_id a", ErrDuplicateLedgerEntry),
+			expect: CodeValidationFailed,
+		},
+		{
+			name:   "transaction conflict",
+			err:    fmt.Errorf("%w: transfer still conflicts after 5 attempts", ErrTransactionConflict),
+			expect: CodeTransactionConflict,
+		},
+		{
+			name:   "deadline exceeded",
+			err:    fmt.Errorf("failed to get account: %w", context.DeadlineExceeded),
+			expect: CodeTimeout,
+		},
+		{
+			name:   "unknown error",
+			err:    errors.New("connection refused"),
+			expect: CodeInternal,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if code := ErrorCode(test.err); code != test.expect {
+				t.Fatalf("expecting code %s but got %s", test.expect, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package ledger
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/albertwidi/ftest/ledger/internal"
+)
+
+// fxPositionAccountPrefix is the prefix of the FX position accounts. The position account of a currency holds the exposure
+// of all conversions from and into the currency. The id is longer than the limit of self-created account id, so it cannot
+// be taken by the user.
+const fxPositionAccountPrefix = "fx_position:"
+
+// FXPositionAccountID returns the account_id of the FX position account of the currency.
+func FXPositionAccountID(currency string) string {
+	return fxPositionAccountPrefix + currency
+}
+
+// FXRate is the conversion rate from the BaseCurrency to the QuoteCurrency, 1 BaseCurrency = Rate QuoteCurrency. The rate
+// is valid from ValidFrom(inclusive) until ValidTo(exclusive), a zero ValidTo means the rate is valid until it is replaced
+// by a rate with newer ValidFrom.
+type FXRate struct {
+	ID            string
+	BaseCurrency  string
+	QuoteCurrency string
+	Rate          decimal.Decimal
+	ValidFrom     time.Time
+	ValidTo       time.Time
+	CreatedAt     time.Time
+}
+
+func (r FXRate) validate() error {
+	if err := validateCurrency(r.BaseCurrency); err != nil {
+		return err
+	}
+	if err := validateCurrency(r.QuoteCurrency); err != nil {
+		return err
+	}
+	if r.BaseCurrency == r.QuoteCurrency {
+		return errors.New("base currency and quote currency cannot be the same")
+	}
+	if !r.Rate.IsPositive() {
+		return errors.New("rate must be positive")
+	}
+	if !r.ValidTo.IsZero() && !r.ValidTo.After(r.ValidFrom) {
+		return errors.New("valid_to must be after valid_from")
+	}
+	return nil
+}
+
+// validAt returns true if the rate can be used at the time.
+func (r FXRate) validAt(t time.Time) bool {
+	if t.Before(r.ValidFrom) {
+		return false
+	}
+	return r.ValidTo.IsZero() || t.Before(r.ValidTo)
+}
+
+func newFXRate(rate internal.FXRate) FXRate {
+	return FXRate{
+		ID:            rate.RateID,
+		BaseCurrency:  rate.BaseCurrency,
+		QuoteCurrency: rate.QuoteCurrency,
+		Rate:          rate.Rate,
+		ValidFrom:     rate.ValidFrom,
+		ValidTo:       rate.ValidTo.Time,
+		CreatedAt:     rate.CreatedAt,
+	}
+}
+
+// CreateFXRate stores a new FX rate and returns the rate with its id. The rate is valid from now if the ValidFrom is zero.
+func (l *Ledger) CreateFXRate(ctx context.Context, rate FXRate) (FXRate, error) {
+	rate.ID = uuid.NewString()
+	rate.CreatedAt = time.Now()
+	if rate.ValidFrom.IsZero() {
+		rate.ValidFrom = rate.CreatedAt
+	}
+	if err := rate.validate(); err != nil {
+		return FXRate{}, validationError(err)
+	}
+
+	err := l.storage.CreateFXRate(ctx, internal.FXRate{
+		RateID:        rate.ID,
+		BaseCurrency:  rate.BaseCurrency,
+		QuoteCurrency: rate.QuoteCurrency,
+		Rate:          rate.Rate,
+		ValidFrom:     rate.ValidFrom,
+		ValidTo:       sql.NullTime{Time: rate.ValidTo, Valid: !rate.ValidTo.IsZero()},
+		CreatedAt:     rate.CreatedAt,
+	})
+	if err != nil {
+		return FXRate{}, err
+	}
+	return rate, nil
+}
+
+// GetFXRates returns the FX rates ordered by the newest ValidFrom first. All parameters are optional, a non-zero at only
+// returns the rates that are valid at the time.
+func (l *Ledger) GetFXRates(ctx context.Context, baseCurrency, quoteCurrency string, at time.Time) ([]FXRate, error) {
+	rates, err := l.storage.GetFXRates(ctx, internal.FXRateFilter{
+		BaseCurrency:  baseCurrency,
+		QuoteCurrency: quoteCurrency,
+		At:            at,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]FXRate, len(rates))
+	for idx, rate := range rates {
+		result[idx] = newFXRate(rate)
+	}
+	return result, nil
+}
+
+// effectiveFXRate returns the rate to convert the base currency into the quote currency at the time. If the rateID is
+// not empty, the rate must be for the same currency pair and valid at the time.
+func (l *Ledger) effectiveFXRate(ctx context.Context, rateID, baseCurrency, quoteCurrency string, at time.Time) (FXRate, error) {
+	if rateID != "" {
+		rate, err := l.storage.GetFXRate(ctx, rateID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return FXRate{}, ErrFXRateNotFound
+			}
+			return FXRate{}, err
+		}
+		fxRate := newFXRate(rate)
+		if fxRate.BaseCurrency != baseCurrency || fxRate.QuoteCurrency != quoteCurrency {
+			return FXRate{}, fmt.Errorf("%w: rate %s is for %s/%s", ErrFXRateNotApplicable, rateID, fxRate.BaseCurrency, fxRate.QuoteCurrency)
+		}
+		if !fxRate.validAt(at) {
+			return FXRate{}, fmt.Errorf("%w: rate %s is not valid at %s", ErrFXRateNotApplicable, rateID, at.Format(time.RFC3339))
+		}
+		return fxRate, nil
+	}
+
+	rates, err := l.GetFXRates(ctx, baseCurrency, quoteCurrency, at)
+	if err != nil {
+		return FXRate{}, err
+	}
+	if len(rates) == 0 {
+		return FXRate{}, fmt.Errorf("%w: no rate for %s/%s", ErrFXRateNotFound, baseCurrency, quoteCurrency)
+	}
+	return rates[0], nil
+}
+
+// Conversion converts the money of the FromAccount into the currency of the ToAccount. The Amount is in the currency of
+// the FromAccount, and the converted amount is rounded down to the minor unit of the currency of the ToAccount.
+type Conversion struct {
+	FromAccount string
+	ToAccount   string
+	Amount      decimal.Decimal
+	// RateID is optional, the latest valid rate of the currency pair is used if the rate id is empty.
+	RateID string
+	// IdempotencyKey is optional. When set, replaying the conversion with the same key returns the original conversion.
+	IdempotencyKey string
+}
+
+func (c Conversion) validate() error {
+	if c.FromAccount == "" {
+		return errors.New("from account cannot be empty")
+	}
+	if c.ToAccount == "" {
+		return errors.New("to account cannot be empty")
+	}
+	if c.FromAccount == c.ToAccount {
+		return errors.New("from account and to account cannot be the same")
+	}
+	if !c.Amount.IsPositive() {
+		return errors.New("amount must be positive")
+	}
+	return nil
+}
+
+// hash is the fingerprint of the conversion request. The converted amount is not included because the rate might change
+// between the replays.
+func (c Conversion) hash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "conversion;%d:%s;%d:%s;%s;%s", len(c.FromAccount), c.FromAccount, len(c.ToAccount), c.ToAccount, c.Amount.String(), c.RateID)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ConversionResult is the executed conversion.
+type ConversionResult struct {
+	TransactionID string
+	RateID        string
+	Rate          decimal.Decimal
+	FromAmount    decimal.Decimal
+	FromCurrency  string
+	ToAmount      decimal.Decimal
+	ToCurrency    string
+}
+
+// conversion is the builder of the conversion transaction. It is a transfer with FX leg, along with the rate used to
+// calculate the amount of the FX leg.
+type conversion struct {
+	Transfer
+	request Conversion
+	rate    FXRate
+}
+
+func (c conversion) transactionType() string {
+	return TransactionTypeConversion
+}
+
+func (c conversion) fxRate() (decimal.Decimal, string) {
+	return c.rate.Rate, c.rate.ID
+}
+
+func (c conversion) requestHash() string {
+	return c.request.hash()
+}
+
+// debitsFXPositions allows the conversion to debit the position account without the checks, the amount of the debit is
+// calculated from the stored FX rate.
+func (c conversion) debitsFXPositions() bool {
+	return true
+}
+
+// Convert converts the money from the FromAccount into the currency of the ToAccount. The money goes through the FX position
+// accounts of both currencies, and the position accounts are created automatically when they don't exist. The executed
+// rate and the rate id are recorded on the transaction.
+func (l *Ledger) Convert(ctx context.Context, req Conversion) (ConversionResult, error) {
+	if err := req.validate(); err != nil {
+		return ConversionResult{}, validationError(err)
+	}
+	if err := l.checkAccountsAccess(ctx, req.FromAccount); err != nil {
+		return ConversionResult{}, err
+	}
+	// Return the previous conversion if the request is a replay, before looking for the rate as the rate might
+	// already expired.
+	if req.IdempotencyKey != "" {
+		prevTxID, found, err := l.idempotentTransaction(ctx, req.IdempotencyKey, req.hash())
+		if err != nil {
+			return ConversionResult{}, err
+		}
+		if found {
+			return l.conversionResult(ctx, prevTxID, req.ToAccount)
+		}
+	}
+
+	balances, err := l.storage.GetAccountsBalance(ctx, req.FromAccount, req.ToAccount)
+	if err != nil {
+		return ConversionResult{}, err
+	}
+	currencies := make(map[string]string)
+	for _, balance := range balances {
+		currencies[balance.AccountID] = balance.Currency
+	}
+	fromCurrency, ok := currencies[req.FromAccount]
+	if !ok {
+		return ConversionResult{}, fmt.Errorf("%w: account_id %s", ErrAccountNotFound, req.FromAccount)
+	}
+	toCurrency, ok := currencies[req.ToAccount]
+	if !ok {
+		return ConversionResult{}, fmt.Errorf("%w: account_id %s", ErrAccountNotFound, req.ToAccount)
+	}
+	if fromCurrency == toCurrency {
+		return ConversionResult{}, validationError(fmt.Errorf("both accounts are in %s, use transfer instead", fromCurrency))
+	}
+	if err := validateAmountScale(req.Amount, fromCurrency); err != nil {
+		return ConversionResult{}, err
+	}
+
+	rate, err := l.effectiveFXRate(ctx, req.RateID, fromCurrency, toCurrency, time.Now())
+	if err != nil {
+		return ConversionResult{}, err
+	}
+	// Round down the converted amount to the minor unit of the currency, the fraction stays in the position account.
+	toAmount := req.Amount.Mul(rate.Rate).Truncate(currencyMinorUnits[toCurrency])
+	if !toAmount.IsPositive() {
+		return ConversionResult{}, validationError(fmt.Errorf("amount is too small to be converted into %s", toCurrency))
+	}
+
+	if err := l.ensureFXPositions(ctx, fromCurrency, toCurrency); err != nil {
+		return ConversionResult{}, err
+	}
+	txID, err := l.Post(ctx, conversion{
+		Transfer: Transfer{
+			FromAccount: req.FromAccount,
+			ToAccount:   req.ToAccount,
+			Amount:      req.Amount,
+			Currency:    fromCurrency,
+			FX: &FXLeg{
+				FromPosition: FXPositionAccountID(fromCurrency),
+				ToPosition:   FXPositionAccountID(toCurrency),
+				Amount:       toAmount,
+				Currency:     toCurrency,
+			},
+		},
+		request: req,
+		rate:    rate,
+	}, req.IdempotencyKey)
+	if err != nil {
+		return ConversionResult{}, err
+	}
+	return l.conversionResult(ctx, txID, req.ToAccount)
+}
+
+// conversionResult builds the result of the conversion from the recorded transaction.
+func (l *Ledger) conversionResult(ctx context.Context, transactionID, toAccount string) (ConversionResult, error) {
+	tx, err := l.GetTransaction(ctx, transactionID)
+	if err != nil {
+		return ConversionResult{}, err
+	}
+	result := ConversionResult{
+		TransactionID: tx.ID,
+		RateID:        tx.FXRateID,
+		Rate:          tx.FXRate,
+		FromAmount:    tx.Amount,
+		FromCurrency:  tx.Currency,
+	}
+	for _, entry := range tx.Entries {
+		if entry.AccountID == toAccount {
+			result.ToAmount = entry.Amount
+			result.ToCurrency = entry.Currency
+		}
+	}
+	return result, nil
+}
+
+// ensureFXPositions creates the FX position accounts of the currencies if they don't exist yet. The position accounts
+// are allowed to have negative balance, as they pay the converted money in their currency.
+func (l *Ledger) ensureFXPositions(ctx context.Context, currencies ...string) error {
+	accountIDs := make([]string, len(currencies))
+	for idx, currency := range currencies {
+		accountIDs[idx] = FXPositionAccountID(currency)
+	}
+	balances, err := l.storage.GetAccountsBalance(ctx, accountIDs...)
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool)
+	for _, balance := range balances {
+		exists[balance.AccountID] = true
+	}
+
+	for idx, currency := range currencies {
+		if exists[accountIDs[idx]] {
+			continue
+		}
+		err := l.storage.CreateAccount(ctx, internal.Account{
+			ID:                   accountIDs[idx],
+			AccountType:          AccountTypeFXPosition,
+			Currency:             currency,
+			AllowNegativeBalance: true,
+			CreatedAt:            time.Now(),
+		})
+		// The position account might be created by a concurrent conversion.
+		if err != nil && !errors.Is(err, internal.ErrAccountAlreadyExists) {
+			return err
+		}
+	}
+	return nil
+}
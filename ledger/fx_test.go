@@ -0,0 +1,146 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFXRateValidAt(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		rate   FXRate
+		at     time.Time
+		expect bool
+	}{
+		{
+			name:   "open ended rate",
+			rate:   FXRate{ValidFrom: now.Add(-time.Hour)},
+			at:     now,
+			expect: true,
+		},
+		{
+			name:   "rate is not yet valid",
+			rate:   FXRate{ValidFrom: now.Add(time.Hour)},
+			at:     now,
+			expect: false,
+		},
+		{
+			name:   "valid_from is inclusive",
+			rate:   FXRate{ValidFrom: now, ValidTo: now.Add(time.Hour)},
+			at:     now,
+			expect: true,
+		},
+		{
+			name:   "valid_to is exclusive",
+			rate:   FXRate{ValidFrom: now.Add(-time.Hour), ValidTo: now},
+			at:     now,
+			expect: false,
+		},
+	}
+
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rate.validAt(tt.at); got != tt.expect {
+				t.Fatalf("expecting %v but got %v", tt.expect, got)
+			}
+		})
+	}
+}
+
+// TestConvert tests the conversion between accounts with different currencies. The money goes through the FX position
+// accounts, and the executed rate is recorded on the transaction.
+func TestConvert(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	usdFunding, err := testLedger.CreateAccount(context.Background(), "", AccountTypeFunding, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usdAccount, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpyAccount, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, "JPY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: usdFunding.ID,
+		ToAccount:   usdAccount.ID,
+		Amount:      createDecimalFromString("100"),
+		Currency:    "USD",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	conversion := Conversion{
+		FromAccount: usdAccount.ID,
+		ToAccount:   jpyAccount.ID,
+		Amount:      createDecimalFromString("10.25"),
+	}
+	// There is no rate for USD/JPY yet.
+	_, err = testLedger.Convert(context.Background(), conversion)
+	if !errors.Is(err, ErrFXRateNotFound) {
+		t.Fatalf("expecting error %v but got %v", ErrFXRateNotFound, err)
+	}
+
+	// The expired rate should not be used.
+	if _, err := testLedger.CreateFXRate(context.Background(), FXRate{
+		BaseCurrency:  "USD",
+		QuoteCurrency: "JPY",
+		Rate:          createDecimalFromString("140"),
+		ValidFrom:     time.Now().Add(-2 * time.Hour),
+		ValidTo:       time.Now().Add(-time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	rate, err := testLedger.CreateFXRate(context.Background(), FXRate{
+		BaseCurrency:  "USD",
+		QuoteCurrency: "JPY",
+		Rate:          createDecimalFromString("149.5"),
+		ValidFrom:     time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := testLedger.Convert(context.Background(), conversion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10.25 * 149.5 = 1532.375 is rounded down to 1532 as JPY doesn't have minor unit.
+	if result.RateID != rate.ID || !result.Rate.Equal(rate.Rate) {
+		t.Fatalf("expecting rate %s(%s) but got %s(%s)", rate.ID, rate.Rate, result.RateID, result.Rate)
+	}
+	if !result.ToAmount.Equal(createDecimalFromString("1532")) || result.ToCurrency != "JPY" {
+		t.Fatalf("expecting converted amount 1532 JPY but got %s %s", result.ToAmount, result.ToCurrency)
+	}
+
+	tx, err := testLedger.GetTransaction(context.Background(), result.TransactionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Type != TransactionTypeConversion || tx.FXRateID != rate.ID || !tx.FXRate.Equal(rate.Rate) {
+		t.Fatalf("expecting conversion transaction with rate %s but got type %s and rate %s", rate.ID, tx.Type, tx.FXRateID)
+	}
+
+	for accountID, expect := range map[string]string{
+		usdAccount.ID:              "89.75",
+		jpyAccount.ID:              "1532",
+		FXPositionAccountID("USD"): "10.25",
+		FXPositionAccountID("JPY"): "-1532",
+	} {
+		balance, err := testLedger.GetAccountBalance(context.Background(), accountID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !balance.Balance.Equal(createDecimalFromString(expect)) {
+			t.Fatalf("expecting balance of %s to be %s but got %s", accountID, expect, balance.Balance)
+		}
+	}
+}
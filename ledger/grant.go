@@ -0,0 +1,108 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/albertwidi/ftest/ledger/internal"
+)
+
+// AccountGrant is the delegate access of the grantee to the account. The grantee can access the account as if it is the
+// owner of the account, but cannot grant the access to others.
+type AccountGrant struct {
+	AccountID string
+	GranteeID string
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// checkGrantManager returns ErrForbidden if the principal of the context cannot manage the grants of the account. Only the
+// owner of the account, or the principals without owner, can manage the grants. The principals with owner get ErrForbidden
+// for the account that doesn't exist as well, so they cannot find the accounts of others by the error.
+func (l *Ledger) checkGrantManager(ctx context.Context, accountID string) error {
+	owner := accountOwner(ctx)
+	acc, err := l.storage.GetAccount(ctx, accountID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if owner != "" && (err != nil || acc.OwnerID.String != owner) {
+		return fmt.Errorf("%w: only the owner of account_id %s can manage its grants", ErrForbidden, accountID)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: account_id %s", ErrAccountNotFound, accountID)
+	}
+	return nil
+}
+
+// GrantAccess grants the grantee the access to the account. Granting the access that is already granted doesn't change
+// the grant.
+func (l *Ledger) GrantAccess(ctx context.Context, accountID, granteeID string) (AccountGrant, error) {
+	if granteeID == "" {
+		return AccountGrant{}, validationError(errors.New("grantee_id cannot be empty"))
+	}
+	if len(granteeID) > maxOwnerIDLength {
+		return AccountGrant{}, validationError(fmt.Errorf("grantee_id cannot be longer than %d characters", maxOwnerIDLength))
+	}
+	if err := l.checkGrantManager(ctx, accountID); err != nil {
+		return AccountGrant{}, err
+	}
+	grantedBy := createdBy(ctx)
+	grant := internal.AccountGrant{
+		AccountID: accountID,
+		GranteeID: granteeID,
+		CreatedBy: sql.NullString{String: grantedBy, Valid: grantedBy != ""},
+		CreatedAt: time.Now(),
+	}
+	if err := l.storage.CreateAccountGrant(ctx, grant); err != nil {
+		return AccountGrant{}, err
+	}
+	return AccountGrant{
+		AccountID: grant.AccountID,
+		GranteeID: grant.GranteeID,
+		CreatedBy: grantedBy,
+		CreatedAt: grant.CreatedAt,
+	}, nil
+}
+
+// RevokeAccess revokes the access of the grantee to the account. ErrGrantNotFound is returned if the access is not granted.
+func (l *Ledger) RevokeAccess(ctx context.Context, accountID, granteeID string) error {
+	if err := l.checkGrantManager(ctx, accountID); err != nil {
+		return err
+	}
+	err := l.storage.RevokeAccountGrant(ctx, accountID, granteeID, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: account_id %s grantee_id %s", ErrGrantNotFound, accountID, granteeID)
+	}
+	return err
+}
+
+// GetAccountGrants returns the active grants of the account. The grants can be read by all principals that can access the
+// account.
+func (l *Ledger) GetAccountGrants(ctx context.Context, accountID string) ([]AccountGrant, error) {
+	if err := l.checkAccountsAccess(ctx, accountID); err != nil {
+		return nil, err
+	}
+	if _, err := l.storage.GetAccount(ctx, accountID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: account_id %s", ErrAccountNotFound, accountID)
+		}
+		return nil, err
+	}
+	grants, err := l.storage.GetAccountGrants(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]AccountGrant, len(grants))
+	for idx, grant := range grants {
+		result[idx] = AccountGrant{
+			AccountID: grant.AccountID,
+			GranteeID: grant.GranteeID,
+			CreatedBy: grant.CreatedBy.String,
+			CreatedAt: grant.CreatedAt,
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,123 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// TestGetAccountLedgerEntries tests the cursor pagination and the filters of the account history.
+func TestGetAccountLedgerEntries(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Create 5 transfers with amount of 1 to 5.
+	for i := 1; i <= 5; i++ {
+		_, err := testLedger.Transfer(context.Background(), Transfer{
+			FromAccount: fundingAccount.ID,
+			ToAccount:   acc1.ID,
+			Amount:      decimal.NewFromInt(int64(i)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("paginate forward and backward", func(t *testing.T) {
+		page1, err := testLedger.GetAccountLedgerEntries(context.Background(), acc1.ID, LedgerEntriesFilter{Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEntryAmounts(t, page1, "1", "2")
+		if page1.PrevCursor != "" {
+			t.Fatal("expecting empty prev cursor for the first page")
+		}
+
+		page2, err := testLedger.GetAccountLedgerEntries(context.Background(), acc1.ID, LedgerEntriesFilter{Limit: 2, Cursor: page1.NextCursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEntryAmounts(t, page2, "3", "4")
+
+		page3, err := testLedger.GetAccountLedgerEntries(context.Background(), acc1.ID, LedgerEntriesFilter{Limit: 2, Cursor: page2.NextCursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEntryAmounts(t, page3, "5")
+		if page3.NextCursor != "" {
+			t.Fatal("expecting empty next cursor for the last page")
+		}
+
+		prevPage, err := testLedger.GetAccountLedgerEntries(context.Background(), acc1.ID, LedgerEntriesFilter{Limit: 2, Cursor: page3.PrevCursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEntryAmounts(t, prevPage, "3", "4")
+
+		firstPage, err := testLedger.GetAccountLedgerEntries(context.Background(), acc1.ID, LedgerEntriesFilter{Limit: 2, Cursor: prevPage.PrevCursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEntryAmounts(t, firstPage, "1", "2")
+		if firstPage.PrevCursor != "" {
+			t.Fatal("expecting empty prev cursor for the first page")
+		}
+	})
+
+	t.Run("filter amount range", func(t *testing.T) {
+		page, err := testLedger.GetAccountLedgerEntries(context.Background(), acc1.ID, LedgerEntriesFilter{
+			MinAmount: decimal.NewNullDecimal(decimal.NewFromInt(2)),
+			MaxAmount: decimal.NewNullDecimal(decimal.NewFromInt(4)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEntryAmounts(t, page, "2", "3", "4")
+	})
+
+	t.Run("filter direction", func(t *testing.T) {
+		page, err := testLedger.GetAccountLedgerEntries(context.Background(), fundingAccount.ID, LedgerEntriesFilter{
+			Direction: DirectionCredit,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEntryAmounts(t, page)
+
+		page, err = testLedger.GetAccountLedgerEntries(context.Background(), fundingAccount.ID, LedgerEntriesFilter{
+			Direction: DirectionDebit,
+			Limit:     1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEntryAmounts(t, page, "-1")
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, err := testLedger.GetAccountLedgerEntries(context.Background(), acc1.ID, LedgerEntriesFilter{Cursor: "invalid"})
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("expecting error %v but got %v", ErrInvalidCursor, err)
+		}
+	})
+}
+
+func checkEntryAmounts(t *testing.T, page LedgerEntriesPage, amounts ...string) {
+	t.Helper()
+
+	if len(page.Entries) != len(amounts) {
+		t.Fatalf("expecting %d entries but got %d", len(amounts), len(page.Entries))
+	}
+	for idx, amount := range amounts {
+		if page.Entries[idx].Amount.Cmp(createDecimalFromString(amount)) != 0 {
+			t.Fatalf("expecting amount %s at index %d but got %s", amount, idx, page.Entries[idx].Amount.String())
+		}
+	}
+}
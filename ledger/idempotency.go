@@ -0,0 +1,57 @@
+package ledger
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/albertwidi/ftest/ledger/internal"
+)
+
+// maxIdempotencyKeyLength is the maximum length of the idempotency key sent by the client.
+const maxIdempotencyKeyLength = 255
+
+// requestHash creates the fingerprint of the transaction request from its ledger entries. The transaction_id and the
+// time of the transaction are not included because they are different for every request.
+func requestHash(tx internal.CreateTransaction) string {
+	h := sha256.New()
+	for _, entry := range tx.LedgerEntries {
+		// Prefix the account_id with its length so different account_id and amount combination cannot produce the same input.
+		fmt.Fprintf(h, "%d:%s:%s:%s;", len(entry.AccountID), entry.AccountID, entry.Amount.String(), entry.Currency)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// requestHasher is implemented by the builders that need their own fingerprint of the request, for example when the entries
+// depend on data that might change between the replays.
+type requestHasher interface {
+	requestHash() string
+}
+
+// builderRequestHash returns the fingerprint of the request from the builder if the builder implements requestHasher, or
+// from the ledger entries of the transaction otherwise.
+func builderRequestHash(builder TransactionBuilder, tx internal.CreateTransaction) string {
+	if hasher, ok := builder.(requestHasher); ok {
+		return hasher.requestHash()
+	}
+	return requestHash(tx)
+}
+
+// idempotentTransaction looks for the previous transaction created with the same idempotency key. The function returns
+// found as false if the key has not been used yet, and ErrIdempotencyKeyMismatch if the key was used for a different request.
+func (l *Ledger) idempotentTransaction(ctx context.Context, key, hash string) (transactionID string, found bool, err error) {
+	idem, err := l.storage.GetIdempotencyKey(ctx, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if idem.RequestHash != hash {
+		return "", true, ErrIdempotencyKeyMismatch
+	}
+	return idem.TransactionID, true, nil
+}
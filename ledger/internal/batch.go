@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/albertwidi/ftest/logging"
+)
+
+// CreateTransactions creates multiple transactions in one database transaction, so the transactions only pay for a single
+// commit. Every transaction is created inside its own savepoint, a failed transaction is rolled back to the savepoint
+// without affecting the other transactions in the batch.
+//
+// The returned errors have the same length and order with the transactions. If the database transaction itself fails,
+// for example the commit fails, the error is returned for all transactions. The transactions that failed on their own
+// get the error as well, because their error might be caused by other transaction in the batch that is rolled back, for
+// example the ErrDuplicateIdempotencyKey of the key that is never committed.
+//
+// The locks of the transactions are held until the whole batch is committed. The transactions in a batch don't lock their
+// accounts in a global order, so two batches might deadlock each other. The deadlock only aborts the transaction that
+// detects it, and the transaction is returned with ErrTransactionConflict so it can be retried.
+func (p *Postgres) CreateTransactions(ctx context.Context, txs []CreateTransaction) []error {
+	errs := make([]error, len(txs))
+	err := transact(ctx, p.db, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+	}, func(ctx context.Context, db *sql.Tx) error {
+		for i, tx := range txs {
+			if _, err := db.ExecContext(ctx, "SAVEPOINT create_transaction;"); err != nil {
+				return err
+			}
+			if err := p.createBatchTransaction(ctx, db, tx); err != nil {
+				errs[i] = err
+				if _, err := db.ExecContext(ctx, "ROLLBACK TO SAVEPOINT create_transaction;"); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := db.ExecContext(ctx, "RELEASE SAVEPOINT create_transaction;"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// The batch is created with the context of the first transaction in the batch, so the log only has the request id
+		// of the first transaction.
+		logging.FromContext(ctx).Error("failed to create batch of transactions", "transactions", len(txs), "error", err)
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// createBatchTransaction creates one transaction of the batch with the write path of the storage.
+func (p *Postgres) createBatchTransaction(ctx context.Context, db *sql.Tx, tx CreateTransaction) error {
+	if p.config.WriteFunction {
+		return functionError(callCreateTransaction(ctx, db, tx))
+	}
+	return classifyError(p.createTransaction(ctx, db, tx))
+}
+
+// CreateTransactions creates the transactions one by one. A commit doesn't cost anything in the memory, so every transaction
+// is committed on its own, which is the same result with the savepoint of every transaction in the Postgres implementation.
+func (m *Memory) CreateTransactions(ctx context.Context, txs []CreateTransaction) []error {
+	errs := make([]error, len(txs))
+	for i, tx := range txs {
+		errs[i] = m.CreateTransaction(ctx, tx)
+	}
+	return errs
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// TestCreateTransactions tests whether a failed transaction in a batch is rolled back to its savepoint, and the other
+// transactions in the batch are still created.
+func TestCreateTransactions(t *testing.T) {
+	t.Cleanup(func() {
+		TruncateTables(t, testPG, "accounts", "accounts_balance", "transaction", "accounts_ledger", "idempotency_keys")
+	})
+
+	for _, acc := range []Account{
+		{ID: "fund", AccountType: "funding", Currency: "IDR", AllowNegativeBalance: true, CreatedAt: time.Now()},
+		{ID: "user", AccountType: "user", Currency: "IDR", CreatedAt: time.Now()},
+	} {
+		if err := testPG.CreateAccount(context.Background(), acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	duplicate := transferTransaction("fund", "user", 10, "IDR")
+	duplicate.IdempotencyKey = "key"
+	txs := []CreateTransaction{
+		transferTransaction("fund", "user", 10, "IDR"),
+		// The balance of the user is only 20 after the first two transactions, the third transaction sees the balance
+		// changed by the previous transactions in the same batch.
+		transferTransaction("fund", "user", 10, "IDR"),
+		transferTransaction("user", "fund", 21, "IDR"),
+		duplicate,
+		duplicate,
+	}
+	errs := testPG.CreateTransactions(context.Background(), txs)
+	expectErrs := []error{nil, nil, ErrInsufficientBalance, nil, ErrDuplicateIdempotencyKey}
+	for i, err := range errs {
+		if !errors.Is(err, expectErrs[i]) {
+			t.Fatalf("expecting error %v for transaction %d but got %v", expectErrs[i], i, err)
+		}
+	}
+
+	balances, err := testPG.GetAccountsBalance(context.Background(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 1 || !balances[0].Balance.Equal(decimal.NewFromInt(30)) {
+		t.Fatalf("expecting balance 30 but got %v", balances)
+	}
+}
+
+// TestCreateTransactionsCommitFailure tests whether all transactions in the batch get the error of the commit, including the
+// transaction that failed because of the idempotency key of other transaction in the rolled back batch.
+func TestCreateTransactionsCommitFailure(t *testing.T) {
+	// The constraint trigger is deferred until the commit, so the commit of the batch that has the transaction fails.
+	if _, err := testPG.db.Exec(`
+		CREATE OR REPLACE FUNCTION test_fail_commit() RETURNS trigger LANGUAGE plpgsql AS $$
+		BEGIN
+			RAISE EXCEPTION 'commit failed';
+		END;
+		$$;
+		CREATE CONSTRAINT TRIGGER test_fail_commit AFTER INSERT ON transaction
+			DEFERRABLE INITIALLY DEFERRED FOR EACH ROW
+			WHEN (NEW.transaction_id = 'fail-commit') EXECUTE FUNCTION test_fail_commit();
+	`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := testPG.db.Exec("DROP TRIGGER test_fail_commit ON transaction; DROP FUNCTION test_fail_commit();"); err != nil {
+			t.Error(err)
+		}
+		TruncateTables(t, testPG, "accounts", "accounts_balance", "transaction", "accounts_ledger", "idempotency_keys")
+	})
+
+	for _, acc := range []Account{
+		{ID: "fund", AccountType: "funding", Currency: "IDR", AllowNegativeBalance: true, CreatedAt: time.Now()},
+		{ID: "user", AccountType: "user", Currency: "IDR", CreatedAt: time.Now()},
+	} {
+		if err := testPG.CreateAccount(context.Background(), acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failing := transferTransaction("fund", "user", 10, "IDR")
+	failing.TransactionID = "fail-commit"
+	for i := range failing.LedgerEntries {
+		failing.LedgerEntries[i].TransactionID = failing.TransactionID
+	}
+	first := transferTransaction("fund", "user", 10, "IDR")
+	first.IdempotencyKey = "key"
+	duplicate := transferTransaction("fund", "user", 10, "IDR")
+	duplicate.IdempotencyKey = "key"
+	errs := testPG.CreateTransactions(context.Background(), []CreateTransaction{first, duplicate, failing})
+	for i, err := range errs {
+		if err == nil || errors.Is(err, ErrDuplicateIdempotencyKey) {
+			t.Fatalf("expecting the error of the commit for transaction %d but got %v", i, err)
+		}
+	}
+
+	if _, err := testPG.GetIdempotencyKey(context.Background(), "key"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expecting error %v but got %v", sql.ErrNoRows, err)
+	}
+	balances, err := testPG.GetAccountsBalance(context.Background(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 1 || !balances[0].Balance.IsZero() {
+		t.Fatalf("expecting zero balance but got %v", balances)
+	}
+}
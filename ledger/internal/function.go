@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The SQLSTATE of the errors raised by the ledger_create_transaction function.
+const (
+	pqInsufficientBalance     = "LDG01"
+	pqCurrencyMismatch        = "LDG02"
+	pqAccountNotFound         = "LDG03"
+	pqDuplicateIdempotencyKey = "LDG04"
+)
+
+// createTransactionFunction creates the transaction with the ledger_create_transaction function. The function validates
+// the accounts, locks and updates the balances and inserts the ledger entries, so the whole transaction is done by a
+// single statement. The statement is executed outside of an explicit database transaction, as a single statement is
+// already atomic and BEGIN/COMMIT would only add more round trips.
+func (p *Postgres) createTransactionFunction(ctx context.Context, tx CreateTransaction) error {
+	ctx, endFunction := p.startPhase(ctx, PhaseFunction)
+	err := callCreateTransaction(ctx, p.db, tx)
+	if err != nil {
+		err = functionError(err)
+	}
+	endFunction(err)
+	return err
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx, so the function can be called with or without a database transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// callCreateTransaction calls the ledger_create_transaction function with the transaction.
+func callCreateTransaction(ctx context.Context, db execer, tx CreateTransaction) error {
+	query := "SELECT ledger_create_transaction($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14::timestamptz[],$15,$16);"
+
+	accounts := make([]string, len(tx.LedgerEntries))
+	amounts := make([]string, len(tx.LedgerEntries))
+	currencies := make([]string, len(tx.LedgerEntries))
+	createdAt := make([]string, len(tx.LedgerEntries))
+	timestamps := make([]int64, len(tx.LedgerEntries))
+	for i, ledger := range tx.LedgerEntries {
+		accounts[i] = ledger.AccountID
+		amounts[i] = ledger.Amount.String()
+		currencies[i] = ledger.Currency
+		createdAt[i] = ledger.CreatedAt.Format(time.RFC3339Nano)
+		timestamps[i] = ledger.CreatedAt.UnixNano()
+	}
+
+	_, err := db.ExecContext(ctx, query,
+		tx.TransactionID,
+		tx.TransactionType,
+		tx.Amount,
+		tx.Currency,
+		tx.ReversalOf,
+		tx.FXRate,
+		tx.FXRateID,
+		tx.CreatedAt,
+		tx.IdempotencyKey,
+		tx.RequestHash,
+		pq.Array(accounts),
+		pq.Array(amounts),
+		pq.Array(currencies),
+		pq.Array(createdAt),
+		pq.Array(timestamps),
+		tx.CreatedBy,
+	)
+	return err
+}
+
+// functionError maps the errors raised by the ledger_create_transaction function back into the errors of the package.
+func functionError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch pqErr.Code {
+	case pqInsufficientBalance:
+		return fmt.Errorf("%w: %s", ErrInsufficientBalance, pqErr.Message)
+	case pqCurrencyMismatch:
+		return fmt.Errorf("%w: %s", ErrCurrencyMismatch, pqErr.Message)
+	case pqAccountNotFound:
+		return fmt.Errorf("%w: %s", ErrAccountNotFound, pqErr.Message)
+	case pqDuplicateIdempotencyKey:
+		return ErrDuplicateIdempotencyKey
+	}
+	return classifyError(err)
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func transferTransaction(from, to string, amount int64, currency string) CreateTransaction {
+	txID := uuid.NewString()
+	createdAt := time.Now()
+	return CreateTransaction{
+		TransactionID:   txID,
+		TransactionType: "transfer",
+		Amount:          decimal.NewFromInt(amount),
+		Currency:        currency,
+		CreatedAt:       createdAt,
+		LedgerEntries: []Ledger{
+			{TransactionID: txID, AccountID: from, Amount: decimal.NewFromInt(-amount), Currency: currency, CreatedAt: createdAt},
+			{TransactionID: txID, AccountID: to, Amount: decimal.NewFromInt(amount), Currency: currency, CreatedAt: createdAt},
+		},
+		Summaries:  map[string]decimal.Decimal{from: decimal.NewFromInt(-amount), to: decimal.NewFromInt(amount)},
+		Currencies: map[string]string{from: currency, to: currency},
+	}
+}
+
+// TestCreateTransactionFunction tests whether the write function checks the transaction the same way with the ledger
+// package, and returns the same errors.
+func TestCreateTransactionFunction(t *testing.T) {
+	t.Cleanup(func() {
+		TruncateTables(t, testPG, "accounts", "accounts_balance", "transaction", "accounts_ledger", "idempotency_keys")
+	})
+	pg := NewPostgres(testPG.db, PostgresConfig{WriteFunction: true})
+
+	for _, acc := range []Account{
+		{ID: "fund", AccountType: "funding", Currency: "IDR", AllowNegativeBalance: true, CreatedAt: time.Now()},
+		{ID: "user", AccountType: "user", Currency: "IDR", CreatedAt: time.Now()},
+		{ID: "user-usd", AccountType: "user", Currency: "USD", CreatedAt: time.Now()},
+	} {
+		if err := pg.CreateAccount(context.Background(), acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx := transferTransaction("fund", "user", 100, "IDR")
+	tx.IdempotencyKey = "key"
+	tx.RequestHash = "hash"
+	if err := pg.CreateTransaction(context.Background(), tx); err != nil {
+		t.Fatal(err)
+	}
+	balances, err := pg.GetAccountsBalance(context.Background(), "fund", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, balance := range balances {
+		expect := tx.Summaries[balance.AccountID]
+		if !balance.Balance.Equal(expect) || balance.LastTransactionID != tx.TransactionID {
+			t.Fatalf("expecting balance %s of %s but got %s", expect, balance.AccountID, balance.Balance)
+		}
+	}
+	entries, err := pg.GetLedgerByTransactionID(context.Background(), tx.TransactionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expecting 2 ledger entries but got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if !entry.CurrentBalance.Decimal.Equal(tx.Summaries[entry.AccountID]) || !entry.PreviousBalance.Decimal.IsZero() {
+			t.Fatalf("unexpected ledger entry %+v", entry)
+		}
+	}
+
+	duplicate := transferTransaction("fund", "user", 100, "IDR")
+	duplicate.IdempotencyKey = "key"
+	duplicate.RequestHash = "hash"
+
+	tests := []struct {
+		name      string
+		tx        CreateTransaction
+		expectErr error
+	}{
+		{
+			name:      "insufficient balance",
+			tx:        transferTransaction("user", "fund", 101, "IDR"),
+			expectErr: ErrInsufficientBalance,
+		},
+		{
+			name:      "currency mismatch",
+			tx:        transferTransaction("fund", "user-usd", 10, "IDR"),
+			expectErr: ErrCurrencyMismatch,
+		},
+		{
+			name:      "account not found",
+			tx:        transferTransaction("fund", "unknown", 10, "IDR"),
+			expectErr: ErrAccountNotFound,
+		},
+		{
+			name:      "duplicate idempotency key",
+			tx:        duplicate,
+			expectErr: ErrDuplicateIdempotencyKey,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := pg.CreateTransaction(context.Background(), test.tx); !errors.Is(err, test.expectErr) {
+				t.Fatalf("expecting error %v but got %v", test.expectErr, err)
+			}
+		})
+	}
+}
+
+// BenchmarkCreateTransaction compares the statements and the function write path. The transfers move money from the
+// funding account to a set of user accounts, so the result also shows the cost of the locks on the funding account.
+//
+// The lib/pq driver prepares the statement with parameters in a separate round trip unless binary_parameters=yes is set
+// in the DSN, so the DSN of the benchmark should set it to compare the real round trips.
+func BenchmarkCreateTransaction(b *testing.B) {
+	writePaths := []struct {
+		name   string
+		config PostgresConfig
+	}{
+		{name: "statements", config: PostgresConfig{}},
+		{name: "function", config: PostgresConfig{WriteFunction: true}},
+	}
+
+	for _, writePath := range writePaths {
+		b.Run(writePath.name, func(b *testing.B) {
+			pg := NewPostgres(testPG.db, writePath.config)
+			for _, acc := range []Account{
+				{ID: "fund", AccountType: "funding", Currency: "IDR", AllowNegativeBalance: true, CreatedAt: time.Now()},
+				{ID: "user-1", AccountType: "user", Currency: "IDR", CreatedAt: time.Now()},
+				{ID: "user-2", AccountType: "user", Currency: "IDR", CreatedAt: time.Now()},
+				{ID: "user-3", AccountType: "user", Currency: "IDR", CreatedAt: time.Now()},
+			} {
+				if err := pg.CreateAccount(context.Background(), acc); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.Cleanup(func() {
+				if _, err := pg.db.Exec("TRUNCATE accounts, accounts_balance, transaction, accounts_ledger RESTART IDENTITY;"); err != nil {
+					b.Log(err)
+				}
+			})
+
+			users := []string{"user-1", "user-2", "user-3"}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := pg.CreateTransaction(context.Background(), transferTransaction("fund", users[i%len(users)], 10, "IDR")); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/shopspring/decimal"
+)
+
+// FXRate is the conversion rate from the BaseCurrency to the QuoteCurrency, 1 BaseCurrency = Rate QuoteCurrency. The rate
+// is only valid between ValidFrom(inclusive) and ValidTo(exclusive), a null ValidTo means the rate is valid until replaced
+// by a newer rate.
+type FXRate struct {
+	RateID        string
+	BaseCurrency  string
+	QuoteCurrency string
+	Rate          decimal.Decimal
+	ValidFrom     time.Time
+	ValidTo       sql.NullTime
+	CreatedAt     time.Time
+}
+
+// FXRateFilter filters the list of FX rates. All filters are optional.
+type FXRateFilter struct {
+	BaseCurrency  string
+	QuoteCurrency string
+	// At only returns the rates that are valid at the time.
+	At time.Time
+}
+
+// CreateFXRate stores a new FX rate. The rates are never updated, a new rate with a newer ValidFrom replaces the old one.
+func (p *Postgres) CreateFXRate(ctx context.Context, rate FXRate) error {
+	query := `
+		INSERT INTO fx_rates(rate_id, base_currency, quote_currency, rate, valid_from, valid_to, created_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7);
+	`
+	_, err := p.db.ExecContext(ctx, query, rate.RateID, rate.BaseCurrency, rate.QuoteCurrency, rate.Rate, rate.ValidFrom, rate.ValidTo, rate.CreatedAt)
+	return err
+}
+
+// GetFXRate returns the FX rate by its id. The function returns sql.ErrNoRows if the rate is not exist.
+func (p *Postgres) GetFXRate(ctx context.Context, rateID string) (FXRate, error) {
+	query := `
+		SELECT rate_id, base_currency, quote_currency, rate, valid_from, valid_to, created_at
+		FROM fx_rates
+		WHERE rate_id = $1;
+	`
+	rate := FXRate{}
+	err := p.db.QueryRowContext(ctx, query, rateID).Scan(
+		&rate.RateID,
+		&rate.BaseCurrency,
+		&rate.QuoteCurrency,
+		&rate.Rate,
+		&rate.ValidFrom,
+		&rate.ValidTo,
+		&rate.CreatedAt,
+	)
+	return rate, err
+}
+
+// GetFXRates returns the FX rates ordered by the newest ValidFrom first.
+func (p *Postgres) GetFXRates(ctx context.Context, filter FXRateFilter) ([]FXRate, error) {
+	builder := squirrel.Select("rate_id", "base_currency", "quote_currency", "rate", "valid_from", "valid_to", "created_at").
+		From("fx_rates")
+	if filter.BaseCurrency != "" {
+		builder = builder.Where(squirrel.Eq{"base_currency": filter.BaseCurrency})
+	}
+	if filter.QuoteCurrency != "" {
+		builder = builder.Where(squirrel.Eq{"quote_currency": filter.QuoteCurrency})
+	}
+	if !filter.At.IsZero() {
+		builder = builder.Where(squirrel.LtOrEq{"valid_from": filter.At}).
+			Where(squirrel.Or{squirrel.Eq{"valid_to": nil}, squirrel.Gt{"valid_to": filter.At}})
+	}
+	query, args, err := builder.OrderBy("valid_from DESC", "created_at DESC").PlaceholderFormat(squirrel.Dollar).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []FXRate
+	for rows.Next() {
+		rate := FXRate{}
+		if err := rows.Scan(
+			&rate.RateID,
+			&rate.BaseCurrency,
+			&rate.QuoteCurrency,
+			&rate.Rate,
+			&rate.ValidFrom,
+			&rate.ValidTo,
+			&rate.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
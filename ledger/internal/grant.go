@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// AccountGrant is the delegate access of the GranteeID to the account. A revoked grant is kept for the audit, and the
+// account can only have one active grant per grantee.
+type AccountGrant struct {
+	AccountID string
+	GranteeID string
+	// CreatedBy is the id of the client that granted the access.
+	CreatedBy sql.NullString
+	CreatedAt time.Time
+	RevokedAt sql.NullTime
+}
+
+// CreateAccountGrant grants the access to the account. Granting the access that is already granted doesn't change the
+// grant, and granting the revoked access creates a new grant, so the revoked grant is kept for the audit.
+func (p *Postgres) CreateAccountGrant(ctx context.Context, grant AccountGrant) error {
+	query := `
+		INSERT INTO account_grants(account_id, grantee_id, created_by, created_at)
+		VALUES($1,$2,$3,$4)
+		ON CONFLICT(account_id, grantee_id) WHERE revoked_at IS NULL DO NOTHING;
+	`
+	_, err := p.db.ExecContext(ctx, query, grant.AccountID, grant.GranteeID, grant.CreatedBy, grant.CreatedAt)
+	return err
+}
+
+// RevokeAccountGrant revokes the access to the account. The function returns sql.ErrNoRows if the access is not granted.
+func (p *Postgres) RevokeAccountGrant(ctx context.Context, accountID, granteeID string, revokedAt time.Time) error {
+	query := `
+		UPDATE account_grants SET revoked_at = $3
+		WHERE account_id = $1 AND grantee_id = $2 AND revoked_at IS NULL;
+	`
+	result, err := p.db.ExecContext(ctx, query, accountID, granteeID, revokedAt)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetAccountGrants returns the active grants of the account ordered by the grantee_id.
+func (p *Postgres) GetAccountGrants(ctx context.Context, accountID string) ([]AccountGrant, error) {
+	query := `
+		SELECT account_id, grantee_id, created_by, created_at, revoked_at
+		FROM account_grants
+		WHERE account_id = $1 AND revoked_at IS NULL
+		ORDER BY grantee_id;
+	`
+	rows, err := p.db.QueryContext(ctx, query, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []AccountGrant
+	for rows.Next() {
+		grant := AccountGrant{}
+		if err := rows.Scan(
+			&grant.AccountID,
+			&grant.GranteeID,
+			&grant.CreatedBy,
+			&grant.CreatedAt,
+			&grant.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
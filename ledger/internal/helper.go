@@ -4,6 +4,25 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ErrTransactionConflict returned when the database aborts the transaction because of a deadlock or a serialization failure.
+// The transaction is already rolled back, so it is safe to retry the transaction.
+var ErrTransactionConflict = errors.New("transaction conflict")
+
+const (
+	// pqSerializationFailure is the SQLSTATE of serialization_failure in Postgres.
+	pqSerializationFailure = "40001"
+	// pqDeadlockDetected is the SQLSTATE of deadlock_detected in Postgres.
+	pqDeadlockDetected = "40P01"
+	// pqQueryCanceled is the SQLSTATE of query_canceled in Postgres. The driver cancels the running query when the
+	// context is done, and the database cancels the query when the statement_timeout is reached.
+	pqQueryCanceled = "57014"
+	// accountsLedgerPrimaryKey is the primary key of the ledger entries, an account has one entry per transaction.
+	accountsLedgerPrimaryKey = "accounts_ledger_pkey"
 )
 
 func transact(ctx context.Context, db *sql.DB, txOptions *sql.TxOptions, fn func(ctx context.Context, tx *sql.Tx) error) error {
@@ -13,11 +32,39 @@ func transact(ctx context.Context, db *sql.DB, txOptions *sql.TxOptions, fn func
 	}
 	err = fn(ctx, tx)
 	if err != nil {
+		// The transaction is already rolled back by database/sql if the context is done, so ErrTxDone is expected.
 		errRollback := tx.Rollback()
-		if errRollback != nil {
+		if errRollback != nil && !errors.Is(errRollback, sql.ErrTxDone) {
 			err = errors.Join(err, errRollback)
 		}
+		return classifyError(err)
+	}
+	return classifyError(tx.Commit())
+}
+
+// classifyError marks the deadlock and the serialization failure as ErrTransactionConflict, and the repeated account in the
+// ledger entries of a transaction as ErrDuplicateLedgerEntry.
+func classifyError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
 		return err
 	}
-	return tx.Commit()
+	switch {
+	case pqErr.Code == pqSerializationFailure || pqErr.Code == pqDeadlockDetected:
+		return fmt.Errorf("%w: %w", ErrTransactionConflict, err)
+	case pqErr.Code == pqUniqueViolation && pqErr.Constraint == accountsLedgerPrimaryKey:
+		return fmt.Errorf("%w: %w", ErrDuplicateLedgerEntry, err)
+	}
+	return err
+}
+
+// IsCanceled returns true if the operation is stopped because the context is done, or the query is canceled by the
+// database. The driver doesn't return the error of the context when it cancels a running query, so the error of the
+// canceled query is checked as well.
+func IsCanceled(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pqQueryCanceled
 }
@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	HoldStatusAuthorized = "authorized"
+	HoldStatusCaptured   = "captured"
+	HoldStatusVoided     = "voided"
+)
+
+var (
+	// ErrHoldNotActive returned when the hold is already fully captured or voided.
+	ErrHoldNotActive = errors.New("hold is not active")
+	// ErrCaptureExceedsHold returned when the captured amount is bigger than the remaining amount of the hold.
+	ErrCaptureExceedsHold = errors.New("capture amount exceeds the remaining hold amount")
+)
+
+// Hold is the authorization record of a two-phase transfer. The hold reserves the money in the FromAccount, so it cannot
+// be used by other transactions, without creating any ledger entries. The ledger entries are created when the hold is captured.
+type Hold struct {
+	HoldID      string
+	FromAccount string
+	ToAccount   string
+	// Amount is the authorized amount of the hold.
+	Amount   decimal.Decimal
+	Currency string
+	// CapturedAmount is the total amount that already captured from the hold. The hold can be captured multiple
+	// times until the CapturedAmount is equal to the Amount.
+	CapturedAmount decimal.Decimal
+	Status         string
+	CreatedAt      time.Time
+	UpdatedAt      sql.NullTime
+}
+
+// Remaining returns the amount of the hold that is not yet captured.
+func (h Hold) Remaining() decimal.Decimal {
+	return h.Amount.Sub(h.CapturedAmount)
+}
+
+// CreateHold creates a new hold and reserves the amount of the hold from the available balance of the FromAccount.
+func (p *Postgres) CreateHold(ctx context.Context, hold Hold) error {
+	selectForUpdateQuery := `
+		SELECT currency, balance, held_balance, allow_negative, shards
+		FROM accounts_balance
+		WHERE account_id = $1
+		FOR UPDATE;
+	`
+	insertHoldQuery := `
+		INSERT INTO holds(hold_id, from_account, to_account, amount, currency, captured_amount, status, created_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8);
+	`
+
+	return transact(ctx, p.db, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+	}, func(ctx context.Context, db *sql.Tx) error {
+		balance := AccountBalance{}
+		row := db.QueryRowContext(ctx, selectForUpdateQuery, hold.FromAccount)
+		if err := row.Scan(
+			&balance.Currency,
+			&balance.Balance,
+			&balance.HeldBalance,
+			&balance.AllowNegative,
+			&balance.Shards,
+		); err != nil {
+			return err
+		}
+		// The sub-balances of a sharded account cannot change while the account is locked exclusively, so their SUM
+		// is the total balance of the account.
+		if balance.Shards > 0 {
+			sums, err := sumShardBalances(ctx, db, []string{hold.FromAccount})
+			if err != nil {
+				return err
+			}
+			balance.Balance = balance.Balance.Add(sums[hold.FromAccount])
+		}
+		if balance.Currency != hold.Currency {
+			return fmt.Errorf("%w: account_id %s is in %s, got %s", ErrCurrencyMismatch, hold.FromAccount, balance.Currency, hold.Currency)
+		}
+		// The hold is only allowed if the available balance is enough for the amount of the hold.
+		available := balance.Balance.Sub(balance.HeldBalance)
+		if available.Sub(hold.Amount).LessThan(decimal.Zero) && !balance.AllowNegative {
+			return ErrInsufficientBalance
+		}
+
+		_, err := db.ExecContext(ctx, insertHoldQuery, hold.HoldID, hold.FromAccount, hold.ToAccount, hold.Amount, hold.Currency, decimal.Zero, HoldStatusAuthorized, hold.CreatedAt)
+		if err != nil {
+			return err
+		}
+		return updateHeldBalance(ctx, db, hold.FromAccount, hold.Amount, hold.CreatedAt)
+	})
+}
+
+// GetHold returns the hold information. The function returns sql.ErrNoRows if the hold is not exist.
+func (p *Postgres) GetHold(ctx context.Context, holdID string) (Hold, error) {
+	query := `
+		SELECT hold_id, from_account, to_account, amount, currency, captured_amount, status, created_at, updated_at
+		FROM holds
+		WHERE hold_id = $1;
+	`
+	return scanHold(p.db.QueryRowContext(ctx, query, holdID))
+}
+
+// CaptureHold captures the amount from the hold and creates the transaction for the captured amount atomically. The amount
+// is released from the held balance of the FromAccount before the transaction is created, so the transaction can use it.
+func (p *Postgres) CaptureHold(ctx context.Context, holdID string, amount decimal.Decimal, tx CreateTransaction) error {
+	updateHoldQuery := `
+		UPDATE holds SET
+			captured_amount = $1,
+			status = $2,
+			updated_at = $3
+		WHERE hold_id = $4;
+	`
+
+	return transact(ctx, p.db, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+	}, func(ctx context.Context, db *sql.Tx) error {
+		hold, err := lockHold(ctx, db, holdID)
+		if err != nil {
+			return err
+		}
+		if hold.Status != HoldStatusAuthorized {
+			return ErrHoldNotActive
+		}
+		if amount.GreaterThan(hold.Remaining()) {
+			return ErrCaptureExceedsHold
+		}
+
+		status := HoldStatusAuthorized
+		capturedAmount := hold.CapturedAmount.Add(amount)
+		if capturedAmount.Equal(hold.Amount) {
+			status = HoldStatusCaptured
+		}
+		// Lock all accounts of the transaction before releasing the held balance, so the accounts are locked in the
+		// same order with other transactions.
+		if _, err := lockAccountsBalance(ctx, db, tx.accounts(), tx.debits()); err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, updateHoldQuery, capturedAmount, status, tx.CreatedAt, holdID); err != nil {
+			return err
+		}
+		if err := updateHeldBalance(ctx, db, hold.FromAccount, amount.Neg(), tx.CreatedAt); err != nil {
+			return err
+		}
+		return p.createTransaction(ctx, db, tx)
+	})
+}
+
+// VoidHold voids the hold and releases the remaining amount of the hold back to the available balance of the FromAccount.
+func (p *Postgres) VoidHold(ctx context.Context, holdID string, voidedAt time.Time) error {
+	updateHoldQuery := `
+		UPDATE holds SET
+			status = $1,
+			updated_at = $2
+		WHERE hold_id = $3;
+	`
+
+	return transact(ctx, p.db, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+	}, func(ctx context.Context, db *sql.Tx) error {
+		hold, err := lockHold(ctx, db, holdID)
+		if err != nil {
+			return err
+		}
+		if hold.Status != HoldStatusAuthorized {
+			return ErrHoldNotActive
+		}
+		if _, err := db.ExecContext(ctx, updateHoldQuery, HoldStatusVoided, voidedAt, holdID); err != nil {
+			return err
+		}
+		return updateHeldBalance(ctx, db, hold.FromAccount, hold.Remaining().Neg(), voidedAt)
+	})
+}
+
+// lockHold selects the hold with FOR UPDATE, so concurrent capture and void of the same hold are processed one by one.
+func lockHold(ctx context.Context, db *sql.Tx, holdID string) (Hold, error) {
+	query := `
+		SELECT hold_id, from_account, to_account, amount, currency, captured_amount, status, created_at, updated_at
+		FROM holds
+		WHERE hold_id = $1
+		FOR UPDATE;
+	`
+	return scanHold(db.QueryRowContext(ctx, query, holdID))
+}
+
+func scanHold(row *sql.Row) (Hold, error) {
+	hold := Hold{}
+	err := row.Scan(
+		&hold.HoldID,
+		&hold.FromAccount,
+		&hold.ToAccount,
+		&hold.Amount,
+		&hold.Currency,
+		&hold.CapturedAmount,
+		&hold.Status,
+		&hold.CreatedAt,
+		&hold.UpdatedAt,
+	)
+	return hold, err
+}
+
+// updateHeldBalance adds the amount to the held balance of the account. Use negative amount to release the held balance.
+func updateHeldBalance(ctx context.Context, db *sql.Tx, accountID string, amount decimal.Decimal, updatedAt time.Time) error {
+	query := `
+		UPDATE accounts_balance SET
+			held_balance = held_balance + $1,
+			updated_at = $2
+		WHERE account_id = $3;
+	`
+	_, err := db.ExecContext(ctx, query, amount, updatedAt, accountID)
+	return err
+}
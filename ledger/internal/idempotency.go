@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrDuplicateIdempotencyKey returned when the idempotency key is already used by another transaction. This usually
+// happens when two requests with the same key are racing each other, and the other request won.
+var ErrDuplicateIdempotencyKey = errors.New("duplicate idempotency key")
+
+// pqUniqueViolation is the SQLSTATE of unique_violation in Postgres.
+const pqUniqueViolation = "23505"
+
+// IdempotencyKey stores the idempotency key of a request and the transaction created by the request.
+type IdempotencyKey struct {
+	Key string
+	// RequestHash is the fingerprint of the request that first used the key.
+	RequestHash   string
+	TransactionID string
+	CreatedAt     time.Time
+}
+
+// GetIdempotencyKey returns the idempotency key record. The function returns sql.ErrNoRows if the key is not exist.
+func (p *Postgres) GetIdempotencyKey(ctx context.Context, key string) (IdempotencyKey, error) {
+	idem := IdempotencyKey{}
+	query := "SELECT idempotency_key, request_hash, transaction_id, created_at FROM idempotency_keys WHERE idempotency_key = $1;"
+	row := p.db.QueryRowContext(ctx, query, key)
+	err := row.Scan(
+		&idem.Key,
+		&idem.RequestHash,
+		&idem.TransactionID,
+		&idem.CreatedAt,
+	)
+	return idem, err
+}
+
+// createIdempotencyKey inserts the idempotency key inside the transaction. Because the key is the primary key of the table,
+// a concurrent transaction with the same key will wait until this transaction is finished and fail if this transaction
+// is committed.
+func createIdempotencyKey(ctx context.Context, db *sql.Tx, idem IdempotencyKey) error {
+	query := `
+		INSERT INTO idempotency_keys(idempotency_key, request_hash, transaction_id, created_at)
+		VALUES($1,$2,$3,$4);
+	`
+	_, err := db.ExecContext(ctx, query, idem.Key, idem.RequestHash, idem.TransactionID, idem.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation {
+			return ErrDuplicateIdempotencyKey
+		}
+		return err
+	}
+	return nil
+}
@@ -5,23 +5,43 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"strings"
+	"sort"
 	"time"
 
 	"github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
 	"github.com/shopspring/decimal"
 )
 
-// ErrInsufficientBalance returned when user's balance is not enough to do the transaction. The only different with the error in the
-// ledger package is this error located deeper in postgres layer. The benefit of this error is we can differentiate the location of the error.
+// ErrInsufficientBalance returned when user's balance is not enough to do the transaction. The ledger package uses the same error, the
+// location of the error can be differentiated by the message that is wrapped by the ledger package.
 var ErrInsufficientBalance = errors.New("account has insufficient balance")
 
+// ErrAccountAlreadyExists returned when the account_id is already used by other account.
+var ErrAccountAlreadyExists = errors.New("account already exists")
+
+// ErrCurrencyMismatch returned when the currency of the ledger entry is different from the currency of the account.
+var ErrCurrencyMismatch = errors.New("currency does not match the account currency")
+
+// ErrDuplicateLedgerEntry returned when the transaction has more than one ledger entry for an account, the ledger entries
+// are unique per transaction and account.
+var ErrDuplicateLedgerEntry = errors.New("account has more than one ledger entry in the transaction")
+
+// ErrAccountNotFound returned when the account of the transaction is not exist. The error is only returned by the write
+// function, the accounts are checked by the ledger package before the transaction is created on the other path.
+var ErrAccountNotFound = errors.New("account not found")
+
 type Account struct {
 	// ID is the unique identifier for each account.
 	ID          string
 	AccountType string
-	CreatedAt   time.Time
-	UpdatedAt   sql.NullTime
+	// Currency is the ISO 4217 currency code of the account.
+	Currency string
+	// OwnerID is the owner identity of the account, like the end user or the tenant, it is null for the accounts without
+	// owner.
+	OwnerID   sql.NullString
+	CreatedAt time.Time
+	UpdatedAt sql.NullTime
 
 	// AllowNegativeBalance is a special flag for account creation. This flag allows account balance
 	// to be negative in some cases.
@@ -29,20 +49,40 @@ type Account struct {
 }
 
 type AccountBalance struct {
-	AccountID         string
-	AllowNegative     bool
-	Balance           decimal.Decimal
+	AccountID     string
+	AllowNegative bool
+	Currency      string
+	// Balance is the posted balance of the account.
+	Balance decimal.Decimal
+	// HeldBalance is the total amount of active holds of the account. The available balance of the account is
+	// Balance - HeldBalance.
+	HeldBalance       decimal.Decimal
 	LastTransactionID string
 	CreatedAt         time.Time
 	UpdatedAt         sql.NullTime
+	// Shards is the number of sub-balances of the account, zero means the account is not sharded. The Balance of a
+	// sharded account is the SUM of the balance of all its sub-balances.
+	Shards        int
+	ShardStrategy string
 }
 
 type Transaction struct {
 	TransactionID   string
 	TransactionType string
-	Amount          string
-	CreatedAt       time.Time
-	UpdatedAt       sql.NullTime
+	Amount          decimal.Decimal
+	Currency        string
+	// ReversalOf is the original transaction_id if the transaction is a reversal of other transaction.
+	ReversalOf sql.NullString
+	// ReversedAmount is the total amount of the transaction that already reversed.
+	ReversedAmount decimal.Decimal
+	// FXRate and FXRateID are the executed rate of a conversion transaction, both are null for other transactions.
+	FXRate   decimal.NullDecimal
+	FXRateID sql.NullString
+	// CreatedBy is the id of the client that created the transaction, it is null for the transactions that are created
+	// before the clients are authenticated.
+	CreatedBy sql.NullString
+	CreatedAt time.Time
+	UpdatedAt sql.NullTime
 }
 
 // Ledger stores immutable records of money changes per account id.
@@ -52,11 +92,15 @@ type Ledger struct {
 	TransactionID string
 	AccountID     string
 	// Amount is the amount of balance change.
-	Amount decimal.Decimal
+	Amount   decimal.Decimal
+	Currency string
 	// CurrentBalance is the final amount after balance change.
-	CurrentBalance decimal.Decimal
+	CurrentBalance decimal.NullDecimal
 	// PreviousAmount is the previous amount before balance change.
-	PreviousBalance decimal.Decimal
+	//
+	// Both balances are null for the entries of a sharded account. The sub-balances of the account are changed
+	// concurrently, so there is no running balance of the account that can be recorded by the entries.
+	PreviousBalance decimal.NullDecimal
 	CreatedAt       time.Time
 	Timestamp       int64
 }
@@ -64,16 +108,21 @@ type Ledger struct {
 // CreateAccount creates a unique account for the user to allowed user to transact.
 // In the creation of the account, we will also create the account's balance in account_balance table.
 func (p *Postgres) CreateAccount(ctx context.Context, acc Account) error {
-	query := "INSERT INTO accounts(account_id, account_type, created_at) VALUES($1,$2,$3);"
+	query := "INSERT INTO accounts(account_id, account_type, currency, owner_id, created_at) VALUES($1,$2,$3,$4,$5);"
 
 	return transact(ctx, p.db, nil, func(ctx context.Context, db *sql.Tx) error {
-		_, err := db.Exec(query, acc.ID, acc.AccountType, acc.CreatedAt)
+		_, err := db.ExecContext(ctx, query, acc.ID, acc.AccountType, acc.Currency, acc.OwnerID, acc.CreatedAt)
 		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation {
+				return ErrAccountAlreadyExists
+			}
 			return err
 		}
 		if err := createAccountBalance(ctx, db, AccountBalance{
 			AccountID:         acc.ID,
 			AllowNegative:     acc.AllowNegativeBalance,
+			Currency:          acc.Currency,
 			Balance:           decimal.Zero,
 			LastTransactionID: "",
 			CreatedAt:         acc.CreatedAt,
@@ -86,21 +135,23 @@ func (p *Postgres) CreateAccount(ctx context.Context, acc Account) error {
 
 func createAccountBalance(ctx context.Context, db *sql.Tx, balance AccountBalance) error {
 	query := `
-		INSERT INTO accounts_balance(account_id, allow_negative, balance, last_transaction_id, created_at)
-		VALUES($1,$2,$3,$4,$5);
+		INSERT INTO accounts_balance(account_id, allow_negative, currency, balance, last_transaction_id, created_at)
+		VALUES($1,$2,$3,$4,$5,$6);
 	`
-	_, err := db.Exec(query, balance.AccountID, balance.AllowNegative, balance.Balance, "", balance.CreatedAt)
+	_, err := db.ExecContext(ctx, query, balance.AccountID, balance.AllowNegative, balance.Currency, balance.Balance, "", balance.CreatedAt)
 	return err
 }
 
 // GetAccount returns account information.
 func (p *Postgres) GetAccount(ctx context.Context, accountID string) (Account, error) {
 	acc := Account{}
-	query := "SELECT account_id, account_type, created_at, updated_at from accounts where account_id = $1;"
-	row := p.db.QueryRow(query, accountID)
+	query := "SELECT account_id, account_type, currency, owner_id, created_at, updated_at from accounts where account_id = $1;"
+	row := p.db.QueryRowContext(ctx, query, accountID)
 	err := row.Scan(
 		&acc.ID,
 		&acc.AccountType,
+		&acc.Currency,
+		&acc.OwnerID,
 		&acc.CreatedAt,
 		&acc.UpdatedAt,
 	)
@@ -109,19 +160,37 @@ func (p *Postgres) GetAccount(ctx context.Context, accountID string) (Account, e
 
 // GetAccounts retrieves multiple accounts_balance if the accounts in parameter is exist. The function
 // does not throw error if any one of the account is not available.
+//
+// The balance of the sharded accounts is aggregated from their sub-balances, and the last_transaction_id is taken from
+// the latest updated sub-balance.
 func (p *Postgres) GetAccountsBalance(ctx context.Context, accounts ...string) ([]AccountBalance, error) {
-	query, params, err := squirrel.Select("account_id", "allow_negative", "balance", "last_transaction_id", "created_at", "updated_at").
-		From("accounts_balance").
-		Where(squirrel.Eq{"account_id": accounts}).
-		PlaceholderFormat(squirrel.Dollar).
-		ToSql()
-	if err != nil {
-		return nil, err
-	}
-	rows, err := p.db.Query(query, params...)
+	query := `
+		SELECT
+			ab.account_id,
+			ab.allow_negative,
+			ab.currency,
+			ab.balance + COALESCE(s.balance, 0),
+			ab.held_balance,
+			COALESCE(NULLIF(s.last_transaction_id, ''), ab.last_transaction_id),
+			ab.created_at,
+			GREATEST(ab.updated_at, s.updated_at),
+			ab.shards,
+			ab.shard_strategy
+		FROM accounts_balance AS ab
+		LEFT JOIN LATERAL (
+			SELECT SUM(balance) OVER () AS balance, last_transaction_id, updated_at
+			FROM accounts_balance_shards
+			WHERE account_id = ab.account_id
+			ORDER BY updated_at DESC NULLS LAST
+			LIMIT 1
+		) AS s ON ab.shards > 0
+		WHERE ab.account_id = ANY($1);
+	`
+	rows, err := p.db.QueryContext(ctx, query, pq.Array(accounts))
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var accs []AccountBalance
 	for rows.Next() {
@@ -129,176 +198,457 @@ func (p *Postgres) GetAccountsBalance(ctx context.Context, accounts ...string) (
 		if err := rows.Scan(
 			&acc.AccountID,
 			&acc.AllowNegative,
+			&acc.Currency,
 			&acc.Balance,
+			&acc.HeldBalance,
 			&acc.LastTransactionID,
 			&acc.CreatedAt,
 			&acc.UpdatedAt,
+			&acc.Shards,
+			&acc.ShardStrategy,
 		); err != nil {
 			return nil, err
 		}
 		accs = append(accs, acc)
 	}
-	return accs, nil
+	return accs, rows.Err()
 }
 
 type CreateTransaction struct {
-	TransactionID string
-	Amount        decimal.Decimal
-	CreatedAt     time.Time
+	TransactionID   string
+	TransactionType string
+	Amount          decimal.Decimal
+	Currency        string
+	CreatedAt       time.Time
 	// LedgerEntries is the entries within the transaction.
 	LedgerEntries []Ledger
 	// Summaries is the summary of the transaction per account. This means this is the total of DEBIT/CREDIT
 	// per account basis. This information is needed as we will lock all the accounts listed here when doing
 	// a transaction.
 	Summaries map[string]decimal.Decimal
+	// Currencies is the currency of the ledger entries per account. The currency of the account is checked against it
+	// when the account is locked, so money in one currency cannot be moved into an account with different currency.
+	Currencies map[string]string
+	// IdempotencyKey is optional, and will be stored along with the RequestHash in the same transaction if not empty.
+	IdempotencyKey string
+	RequestHash    string
+	// ReversalOf is optional, it links the transaction to the original transaction that is reversed by this transaction.
+	ReversalOf string
+	// FXRate and FXRateID are optional, they record the rate used by a conversion transaction.
+	FXRate   decimal.NullDecimal
+	FXRateID string
+	// CreatedBy is optional, it records the client that created the transaction for the audit.
+	CreatedBy string
+}
+
+// accounts returns all accounts in the summaries ordered by the account_id.
+func (tx CreateTransaction) accounts() []string {
+	accountIDs := make([]string, 0, len(tx.Summaries))
+	for accID := range tx.Summaries {
+		accountIDs = append(accountIDs, accID)
+	}
+	sort.Strings(accountIDs)
+	return accountIDs
+}
+
+// debits returns the accounts that are debited by the transaction ordered by the account_id.
+func (tx CreateTransaction) debits() []string {
+	var accountIDs []string
+	for accID, amount := range tx.Summaries {
+		if amount.IsNegative() {
+			accountIDs = append(accountIDs, accID)
+		}
+	}
+	sort.Strings(accountIDs)
+	return accountIDs
 }
 
 // CreateTransaction creates a new transaction and transfers money from one account to another
 // depdends on the requirement of the ledger.
 //
 // The current implementation are doing this in one transaction with ReadCommitted transaction level:
-// 1. SELECT FOR UPDATE all affected accounts.
-// 2. Insert transaction record to transaction table.
-// 3. Update all balances based on calculation of balance changes.
-// 4. Insert all ledger entries records.
+// 1. Insert the idempotency key if the key is not empty.
+// 2. SELECT FOR UPDATE all affected accounts.
+// 3. Insert transaction record to transaction table.
+// 4. Update all balances based on calculation of balance changes.
+// 5. Insert all ledger entries records.
+//
+// A sharded account is not locked exclusively unless the total balance of the account needs to be checked. Instead, the
+// transaction locks and changes one of the sub-balances of the account, so the transactions to the same sharded account
+// can be done concurrently. The ledger entries of a sharded account don't have the previous and the current balance.
 func (p *Postgres) CreateTransaction(ctx context.Context, tx CreateTransaction) error {
-	counter := 0
-	accountIDs := make([]string, len(tx.Summaries))
-	for accID := range tx.Summaries {
-		accountIDs[counter] = "'" + accID + "'"
-		counter++
+	if p.config.WriteFunction {
+		return p.createTransactionFunction(ctx, tx)
 	}
-	accountIDsForQuery := strings.Join(accountIDs, ",")
+	ctx, span := tracer.Start(ctx, "Postgres.CreateTransaction")
+	defer span.End()
 
-	// selectForUpdateQuery is used to lock all accounts_balance listed in the transaction summaries. This is to ensure
-	// the balance is not changing while we are doing a transaction.
-	//
-	// Please NOTE that select for update is only works inside a TRANSACTION.
-	selectForUpdateQuery := fmt.Sprintf(`
-		SELECT account_id, balance, allow_negative
-		FROM accounts_balance
-		WHERE account_id IN(%s)
-		FOR UPDATE;
-	`, accountIDsForQuery)
+	endCommit := func(error) {}
+	err := transact(ctx, p.db, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+	}, func(ctx context.Context, db *sql.Tx) error {
+		if err := p.createTransaction(ctx, db, tx); err != nil {
+			return err
+		}
+		// The database transaction is committed by transact after this function returns.
+		_, endCommit = p.startPhase(ctx, PhaseCommit)
+		return nil
+	})
+	endCommit(err)
+	recordError(span, err)
+	return err
+}
+
+// createTransaction creates the transaction inside the given database transaction. The function is separated from CreateTransaction
+// so other operations can create a transaction atomically with their own changes, for example capturing a hold.
+func (p *Postgres) createTransaction(ctx context.Context, db *sql.Tx, tx CreateTransaction) error {
+	// Insert the idempotency key first, so the concurrent request with the same key will wait for this transaction
+	// before locking any balance.
+	if tx.IdempotencyKey != "" {
+		_, endIdempotency := p.startPhase(ctx, PhaseIdempotency)
+		err := createIdempotencyKey(ctx, db, IdempotencyKey{
+			Key:           tx.IdempotencyKey,
+			RequestHash:   tx.RequestHash,
+			TransactionID: tx.TransactionID,
+			CreatedAt:     tx.CreatedAt,
+		})
+		endIdempotency(err)
+		if err != nil {
+			return err
+		}
+	}
 
+	// Do SELECT FOR UPDATE to ensure we are locking the balance first.
+	lockCtx, endLock := p.startPhase(ctx, PhaseLock)
+	balances, err := lockAccountsBalance(lockCtx, db, tx.accounts(), tx.debits())
+	endLock(err)
+	if err != nil {
+		return fmt.Errorf("failed to lock accounts with error: %w", err)
+	}
+
+	// All changes after the lock are the write phase.
+	ctx, endWrite := p.startPhase(ctx, PhaseWrite)
+	err = p.writeTransaction(ctx, db, tx, balances)
+	endWrite(err)
+	return err
+}
+
+// writeTransaction updates the balances of the locked accounts, and inserts the transaction and the ledger entries.
+func (p *Postgres) writeTransaction(ctx context.Context, db *sql.Tx, tx CreateTransaction, balances []AccountBalance) error {
 	// insertTransactionQuery inserts new transaction to the record.
-	insertTransactionQuery := "INSERT INTO transaction(transaction_id, amount, created_at) VALUES($1,$2,$3)"
+	insertTransactionQuery := `
+		INSERT INTO transaction(transaction_id, transaction_type, amount, currency, reversal_of, fx_rate, fx_rate_id, created_by, created_at)
+		VALUES($1,$2,$3,$4,NULLIF($5,''),$6,NULLIF($7,''),NULLIF($8,''),$9);
+	`
 
-	// updateBalanceQuery updates multiple account balances with updated balance on each account.
+	// updateBalanceQuery updates multiple account balances with updated balance on each account. The account ids and the
+	// balances are passed as arrays and zipped with unnest, so the query is the same regardless of the number of accounts.
 	updateBalanceQuery := `
 		UPDATE accounts_balance AS ab SET
 			balance = v.balance,
-			last_transaction_id = v.transaction_id,
-			updated_at = v.updated_at
-		FROM (VALUES %s) AS v(balance, transaction_id, account_id, updated_at)
+			last_transaction_id = $3,
+			updated_at = $4
+		FROM unnest($1::varchar[], $2::numeric[]) AS v(account_id, balance)
 		WHERE ab.account_id = v.account_id;
 	`
 
 	// insertLedgerBuilder inserts multiple ledger records for affected accounts.
-	insertLedgerBuilder := squirrel.Insert("accounts_ledger").Columns("transaction_id", "account_id", "amount", "current_balance", "previous_balance", "created_at", "timestamp")
+	insertLedgerBuilder := squirrel.Insert("accounts_ledger").Columns("transaction_id", "account_id", "amount", "currency", "current_balance", "previous_balance", "created_at", "timestamp")
 	// maps all the ledger entries to each account.
 	ledgerMap := make(map[string][]Ledger)
 	for _, ledger := range tx.LedgerEntries {
 		ledgerMap[ledger.AccountID] = append(ledgerMap[ledger.AccountID], ledger)
 	}
 
-	return transact(ctx, p.db, &sql.TxOptions{
-		Isolation: sql.LevelReadCommitted,
-	}, func(ctx context.Context, db *sql.Tx) error {
-		var updateQuery string
-
-		// Do SELECT FOR UPDATE to ensure we are locking the balance first.
-		rows, err := db.Query(selectForUpdateQuery)
-		if err != nil {
-			return fmt.Errorf("failed to lock accounts with error: %v", err)
+	updateAccountIDs := make([]string, 0, len(balances))
+	updateBalances := make([]string, 0, len(balances))
+	for _, balance := range balances {
+		if currency, ok := tx.Currencies[balance.AccountID]; ok && currency != balance.Currency {
+			return fmt.Errorf("%w: account_id %s is in %s, got %s", ErrCurrencyMismatch, balance.AccountID, balance.Currency, currency)
 		}
-		if err := rows.Err(); err != nil {
-			return err
+		amount := tx.Summaries[balance.AccountID]
+		toBalance := balance.Balance.Add(amount)
+		// Check the balance of the accounts again, as there might be a gap from where select the balance previously
+		// up to this point where we select the balance for update. The held balance cannot be used by the transaction
+		// as it is reserved for the authorized holds.
+		//
+		// The balance of a sharded account is only checked for the debit, because only then the account is locked
+		// exclusively and the balance is the total balance of the account.
+		if toBalance.Sub(balance.HeldBalance).LessThan(decimal.Zero) && !balance.AllowNegative && (balance.Shards == 0 || amount.IsNegative()) {
+			return ErrInsufficientBalance
 		}
 
-		for rows.Next() {
-			balance := AccountBalance{}
-			if err := rows.Scan(
-				&balance.AccountID,
-				&balance.Balance,
-				&balance.AllowNegative,
-			); err != nil {
+		// Set the previous balance to the retrieved balance. We will change this variables to reflect the balance
+		// changes in the ledger. The balances are left empty for a sharded account, as the sub-balance is not the
+		// balance of the account.
+		previousBalance := decimal.NewNullDecimal(balance.Balance)
+		if balance.Shards > 0 {
+			shard := p.picker.pick(balance.AccountID, balance.Shards, balance.ShardStrategy)
+			if err := addShardBalance(ctx, db, balance.AccountID, shard, amount, tx.TransactionID, tx.CreatedAt); err != nil {
 				return err
 			}
-			toBalance := balance.Balance.Add(tx.Summaries[balance.AccountID])
-			// Check the balance of the accounts again, as there might be a gap from where select the balance previously
-			// up to this point where we select the balance for update.
-			if toBalance.LessThan(decimal.Zero) && !balance.AllowNegative {
-				return ErrInsufficientBalance
-			}
-			// Append the update query with ($1,$2,$3,$4) of to_balance, transaction_id, account_id, updated_at.
-			updateQuery = updateQuery + fmt.Sprintf("(%s, '%s', '%s', to_timestamp(%d)),", toBalance.String(), tx.TransactionID, balance.AccountID, tx.CreatedAt.Unix())
-
-			// Set the previous and current balance to the retrieved balance. We will change this variables to reflect
-			// the balance changes in the ledger.
-			previousBalance := balance.Balance
-			currentBalance := balance.Balance
-			// Loop through all the ledgers for the account to calculate the current_balance and the previous_balance. This is important because in
-			// one transaction, there might be multiple records on the same account. For example, transfering balance from one account to multiple accounts.
-			ledgers := ledgerMap[balance.AccountID]
-			for _, ledger := range ledgers {
-				// Set the current balance to current_balance + amount.
-				currentBalance = currentBalance.Add(ledger.Amount)
-				insertLedgerBuilder = insertLedgerBuilder.Values(
-					tx.TransactionID,
-					ledger.AccountID,
-					ledger.Amount,
-					currentBalance,
-					previousBalance,
-					ledger.CreatedAt,
-					ledger.CreatedAt.UnixNano(),
-				)
-				// Set the previous balance with the current balance as we have record the previous balance.
-				previousBalance = currentBalance
+			previousBalance = decimal.NullDecimal{}
+		} else {
+			updateAccountIDs = append(updateAccountIDs, balance.AccountID)
+			updateBalances = append(updateBalances, toBalance.String())
+		}
+
+		currentBalance := previousBalance
+		// Loop through all the ledgers for the account to calculate the current_balance and the previous_balance. This is important because in
+		// one transaction, there might be multiple records on the same account. For example, transfering balance from one account to multiple accounts.
+		ledgers := ledgerMap[balance.AccountID]
+		for _, ledger := range ledgers {
+			// Set the current balance to current_balance + amount.
+			if currentBalance.Valid {
+				currentBalance = decimal.NewNullDecimal(currentBalance.Decimal.Add(ledger.Amount))
 			}
+			insertLedgerBuilder = insertLedgerBuilder.Values(
+				tx.TransactionID,
+				ledger.AccountID,
+				ledger.Amount,
+				ledger.Currency,
+				currentBalance,
+				previousBalance,
+				ledger.CreatedAt,
+				ledger.CreatedAt.UnixNano(),
+			)
+			// Set the previous balance with the current balance as we have record the previous balance.
+			previousBalance = currentBalance
 		}
+	}
 
-		// Trim the last "," from the update query. Because it is basically VALUES(($1),($2),$(3)).
-		updateQuery = strings.TrimSuffix(updateQuery, ",")
-		updateBalanceQuery = fmt.Sprintf(updateBalanceQuery, updateQuery)
+	// Insert the transaction record.
+	_, err := db.ExecContext(ctx, insertTransactionQuery, tx.TransactionID, tx.TransactionType, tx.Amount, tx.Currency, tx.ReversalOf, tx.FXRate, tx.FXRateID, tx.CreatedBy, tx.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed insert new transaction with error: %w", err)
+	}
 
-		// Insert the transaction record.
-		_, err = db.Exec(insertTransactionQuery, tx.TransactionID, tx.Amount, tx.CreatedAt)
+	// Update the balance of the accounts that are not sharded.
+	if len(updateAccountIDs) > 0 {
+		_, err = db.ExecContext(ctx, updateBalanceQuery, pq.Array(updateAccountIDs), pq.Array(updateBalances), tx.TransactionID, tx.CreatedAt)
 		if err != nil {
-			return fmt.Errorf("failed insert new transaction with error: %v", err)
+			return fmt.Errorf("failed to update balances with error: %w", err)
 		}
+	}
+
+	// Insert all ledger entries.
+	insertLedgerQuery, args, err := insertLedgerBuilder.PlaceholderFormat(squirrel.Dollar).ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query for ledger entries with error: %v", err)
+	}
+	_, err = db.ExecContext(ctx, insertLedgerQuery, args...)
+	if err != nil {
+		return fmt.Errorf("failed to insert ledger entries with error: %w", err)
+	}
+	return nil
+}
+
+// lockAccountsBalance locks the accounts_balance of the accounts with SELECT FOR UPDATE, so the balance is not changing while
+// we are doing a transaction. Please NOTE that select for update is only works inside a TRANSACTION.
+//
+// The rows are locked in the order of the account_id, so two transactions that lock the same accounts always lock them
+// in the same order and cannot deadlock each other. All rows are read and closed before the function returns, so the
+// next query can be executed in the same database transaction.
+//
+// The sharded accounts are locked with FOR KEY SHARE instead, which only prevents the account from being resharded or
+// rebalanced, so the transactions to the same sharded account don't wait for each other. The exception is the debit of
+// a sharded account that doesn't allow negative balance, the account is locked with FOR UPDATE so the total balance of
+// its sub-balances cannot change until the balance is checked and updated.
+func lockAccountsBalance(ctx context.Context, db *sql.Tx, accountIDs, debits []string) ([]AccountBalance, error) {
+	lockExclusiveQuery := `
+		SELECT account_id, currency, balance, held_balance, allow_negative, shards, shard_strategy
+		FROM accounts_balance
+		WHERE account_id = ANY($1) AND (shards = 0 OR (NOT allow_negative AND account_id = ANY($2)))
+		ORDER BY account_id
+		FOR UPDATE;
+	`
+	lockSharedQuery := `
+		SELECT account_id, currency, balance, held_balance, allow_negative, shards, shard_strategy
+		FROM accounts_balance
+		WHERE account_id = ANY($1)
+		ORDER BY account_id
+		FOR KEY SHARE;
+	`
 
-		// Update the balance of the accounts.
-		_, err = db.Exec(updateBalanceQuery)
+	balances, err := queryAccountsBalance(ctx, db, lockExclusiveQuery, pq.Array(accountIDs), pq.Array(debits))
+	if err != nil {
+		return nil, err
+	}
+	var sharded []string
+	locked := make(map[string]bool, len(balances))
+	for _, balance := range balances {
+		locked[balance.AccountID] = true
+		if balance.Shards > 0 {
+			sharded = append(sharded, balance.AccountID)
+		}
+	}
+	// The total balance of the sharded accounts that are locked exclusively.
+	if len(sharded) > 0 {
+		sums, err := sumShardBalances(ctx, db, sharded)
 		if err != nil {
-			return fmt.Errorf("failed to update balances with error: %v", err)
+			return nil, err
+		}
+		for i := range balances {
+			balances[i].Balance = balances[i].Balance.Add(sums[balances[i].AccountID])
 		}
+	}
+	if len(balances) == len(accountIDs) {
+		return balances, nil
+	}
 
-		// Insert all ledger entries.
-		insertLedgerQuery, args, err := insertLedgerBuilder.PlaceholderFormat(squirrel.Dollar).ToSql()
-		if err != nil {
-			return fmt.Errorf("failed to build query for ledger entries with error: %v", err)
+	var remaining []string
+	for _, accountID := range accountIDs {
+		if !locked[accountID] {
+			remaining = append(remaining, accountID)
 		}
-		_, err = db.Exec(insertLedgerQuery, args...)
-		if err != nil {
-			return fmt.Errorf("failed to insert ledger entries with error: %v", err)
+	}
+	shared, err := queryAccountsBalance(ctx, db, lockSharedQuery, pq.Array(remaining))
+	if err != nil {
+		return nil, err
+	}
+	for _, balance := range shared {
+		// The account is skipped by the first query because it was sharded, but the account is no longer sharded now.
+		// The transaction is retried so the account can be locked exclusively.
+		if balance.Shards == 0 {
+			return nil, fmt.Errorf("%w: shards of account_id %s changed", ErrTransactionConflict, balance.AccountID)
 		}
-		return nil
-	})
+	}
+	return append(balances, shared...), nil
+}
+
+func queryAccountsBalance(ctx context.Context, db *sql.Tx, query string, args ...any) ([]AccountBalance, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var balances []AccountBalance
+	for rows.Next() {
+		balance := AccountBalance{}
+		if err := rows.Scan(
+			&balance.AccountID,
+			&balance.Currency,
+			&balance.Balance,
+			&balance.HeldBalance,
+			&balance.AllowNegative,
+			&balance.Shards,
+			&balance.ShardStrategy,
+		); err != nil {
+			return nil, err
+		}
+		balances = append(balances, balance)
+	}
+	return balances, rows.Err()
 }
 
-func (p *Postgres) GetLedgerByAccountID(ctx context.Context, accountID string) ([]Ledger, error) {
+// GetTransaction returns the transaction record. The function returns sql.ErrNoRows if the transaction is not exist.
+func (p *Postgres) GetTransaction(ctx context.Context, transactionID string) (Transaction, error) {
 	query := `
-		SELECT transaction_id, account_id, amount, current_balance, previous_balance, created_at, timestamp
-		FROM accounts_ledger
-		WHERE account_id = $1
-		ORDER BY timestamp ASC;
+		SELECT transaction_id, transaction_type, amount, currency, reversal_of, reversed_amount, fx_rate, fx_rate_id, created_by, created_at, updated_at
+		FROM transaction
+		WHERE transaction_id = $1;
 	`
+	return scanTransaction(p.db.QueryRowContext(ctx, query, transactionID))
+}
+
+func scanTransaction(row *sql.Row) (Transaction, error) {
+	tx := Transaction{}
+	err := row.Scan(
+		&tx.TransactionID,
+		&tx.TransactionType,
+		&tx.Amount,
+		&tx.Currency,
+		&tx.ReversalOf,
+		&tx.ReversedAmount,
+		&tx.FXRate,
+		&tx.FXRateID,
+		&tx.CreatedBy,
+		&tx.CreatedAt,
+		&tx.UpdatedAt,
+	)
+	return tx, err
+}
 
-	rows, err := p.db.Query(query, accountID)
+const (
+	DirectionDebit  = "debit"
+	DirectionCredit = "credit"
+)
+
+// LedgerCursor is the position of a ledger entry in the account history. The ledger entries of an account are ordered
+// by (timestamp, transaction_id), so the pair is unique and stable to paginate the history.
+type LedgerCursor struct {
+	Timestamp     int64
+	TransactionID string
+}
+
+// LedgerFilter filters and paginates the ledger entries of an account. All filters are optional.
+type LedgerFilter struct {
+	// Limit is the maximum number of entries returned. Zero means no limit.
+	Limit uint64
+	// After returns the entries after the cursor in ascending order.
+	After *LedgerCursor
+	// Before returns the entries before the cursor, the entries are still returned in ascending order.
+	Before *LedgerCursor
+	// FromTime is inclusive and ToTime is exclusive.
+	FromTime time.Time
+	ToTime   time.Time
+	// Direction is either DirectionDebit or DirectionCredit.
+	Direction string
+	// MinAmount and MaxAmount are inclusive and compared with the absolute amount of the entry.
+	MinAmount decimal.NullDecimal
+	MaxAmount decimal.NullDecimal
+}
+
+// GetLedgerByAccountID returns the ledger entries of the account ordered by (timestamp, transaction_id). All the filters
+// are pushed down into the query, so only the requested page is loaded from the database.
+func (p *Postgres) GetLedgerByAccountID(ctx context.Context, accountID string, filter LedgerFilter) ([]Ledger, error) {
+	builder := squirrel.Select("transaction_id", "account_id", "amount", "currency", "current_balance", "previous_balance", "created_at", "timestamp").
+		From("accounts_ledger").
+		Where(squirrel.Eq{"account_id": accountID})
+
+	order := "ASC"
+	switch {
+	case filter.After != nil:
+		builder = builder.Where("(timestamp, transaction_id) > (?, ?)", filter.After.Timestamp, filter.After.TransactionID)
+	case filter.Before != nil:
+		// Walk the history backward from the cursor, the result is reversed back to ascending order below.
+		builder = builder.Where("(timestamp, transaction_id) < (?, ?)", filter.Before.Timestamp, filter.Before.TransactionID)
+		order = "DESC"
+	}
+	if !filter.FromTime.IsZero() {
+		builder = builder.Where(squirrel.GtOrEq{"timestamp": filter.FromTime.UnixNano()})
+	}
+	if !filter.ToTime.IsZero() {
+		builder = builder.Where(squirrel.Lt{"timestamp": filter.ToTime.UnixNano()})
+	}
+	switch filter.Direction {
+	case DirectionDebit:
+		builder = builder.Where(squirrel.Lt{"amount": 0})
+	case DirectionCredit:
+		builder = builder.Where(squirrel.Gt{"amount": 0})
+	}
+	if filter.MinAmount.Valid {
+		builder = builder.Where("ABS(amount) >= ?", filter.MinAmount.Decimal)
+	}
+	if filter.MaxAmount.Valid {
+		builder = builder.Where("ABS(amount) <= ?", filter.MaxAmount.Decimal)
+	}
+	builder = builder.OrderBy("timestamp "+order, "transaction_id "+order)
+	if filter.Limit > 0 {
+		builder = builder.Limit(filter.Limit)
+	}
+
+	query, args, err := builder.PlaceholderFormat(squirrel.Dollar).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := p.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
 	var entries []Ledger
 	for rows.Next() {
 		ledger := Ledger{}
@@ -306,6 +656,7 @@ func (p *Postgres) GetLedgerByAccountID(ctx context.Context, accountID string) (
 			&ledger.TransactionID,
 			&ledger.AccountID,
 			&ledger.Amount,
+			&ledger.Currency,
 			&ledger.CurrentBalance,
 			&ledger.PreviousBalance,
 			&ledger.CreatedAt,
@@ -315,21 +666,32 @@ func (p *Postgres) GetLedgerByAccountID(ctx context.Context, accountID string) (
 		}
 		entries = append(entries, ledger)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if order == "DESC" {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
 	return entries, nil
 }
 
 func (p *Postgres) GetLedgerByTransactionID(ctx context.Context, transactionID string) ([]Ledger, error) {
 	query := `
-		SELECT transaction_id, account_id, amount, current_balance, previous_balance, created_at, timestamp
+		SELECT transaction_id, account_id, amount, currency, current_balance, previous_balance, created_at, timestamp
 		FROM accounts_ledger
 		WHERE transaction_id = $1
 		ORDER BY timestamp ASC;
 	`
 
-	rows, err := p.db.Query(query, transactionID)
+	rows, err := p.db.QueryContext(ctx, query, transactionID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
 	var entries []Ledger
 	for rows.Next() {
 		ledger := Ledger{}
@@ -337,6 +699,7 @@ func (p *Postgres) GetLedgerByTransactionID(ctx context.Context, transactionID s
 			&ledger.TransactionID,
 			&ledger.AccountID,
 			&ledger.Amount,
+			&ledger.Currency,
 			&ledger.CurrentBalance,
 			&ledger.PreviousBalance,
 			&ledger.CreatedAt,
@@ -346,5 +709,5 @@ func (p *Postgres) GetLedgerByTransactionID(ctx context.Context, transactionID s
 		}
 		entries = append(entries, ledger)
 	}
-	return entries, nil
+	return entries, rows.Err()
 }
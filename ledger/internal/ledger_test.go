@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -24,16 +25,18 @@ func TestCreateAccount(t *testing.T) {
 	expectAccount := Account{
 		ID:          accountID,
 		AccountType: "user",
+		Currency:    "IDR",
 		CreatedAt:   createdAt,
 	}
 	expectAccountBalance := AccountBalance{
 		AccountID:         accountID,
+		Currency:          "IDR",
 		CreatedAt:         createdAt,
 		Balance:           decimal.NewFromInt(0),
 		LastTransactionID: "",
 	}
 
-	if err := testPG.CreateAccount(context.Background(), Account{ID: accountID, AccountType: "user", CreatedAt: createdAt}); err != nil {
+	if err := testPG.CreateAccount(context.Background(), Account{ID: accountID, AccountType: "user", Currency: "IDR", CreatedAt: createdAt}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -275,7 +278,7 @@ func TestCorrectBalance(t *testing.T) {
 		}
 
 		// Check ledger entries of 'one'. The total of the entries should be -99_000
-		entries1, err := testPG.GetLedgerByAccountID(context.Background(), "one")
+		entries1, err := testPG.GetLedgerByAccountID(context.Background(), "one", LedgerFilter{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -288,7 +291,7 @@ func TestCorrectBalance(t *testing.T) {
 		}
 
 		// Check ledger entries of 'two'. The total of the entries should be -99_000
-		entries2, err := testPG.GetLedgerByAccountID(context.Background(), "two")
+		entries2, err := testPG.GetLedgerByAccountID(context.Background(), "two", LedgerFilter{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -301,3 +304,318 @@ func TestCorrectBalance(t *testing.T) {
 		}
 	})
 }
+
+// TestCreateTransactionSpecialCharacters tests whether the account ids and the transaction id are always passed as
+// parameters. The ids with quotes and SQL metacharacters must be stored as is, and must not change the query.
+func TestCreateTransactionSpecialCharacters(t *testing.T) {
+	t.Cleanup(func() {
+		TruncateTables(t, testPG, "accounts", "accounts_balance", "transaction", "accounts_ledger")
+	})
+
+	fundingID := "fund'); DROP TABLE accounts_balance;--"
+	accountIDs := []string{
+		"acc'1",
+		`acc"2`,
+		"acc'',3",
+		`acc\4`,
+		"acc'), ('5",
+		"acc$1;6",
+	}
+	if err := testPG.CreateAccount(context.Background(), Account{
+		ID:                   fundingID,
+		AccountType:          "funding",
+		Currency:             "IDR",
+		AllowNegativeBalance: true,
+		CreatedAt:            time.Now(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, accountID := range accountIDs {
+		if err := testPG.CreateAccount(context.Background(), Account{
+			ID:          accountID,
+			AccountType: "user",
+			Currency:    "IDR",
+			CreatedAt:   time.Now(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	txID := "tx'; UPDATE accounts_balance SET balance = 1000000;--"
+	tx := CreateTransaction{
+		TransactionID:   txID,
+		TransactionType: "posting",
+		Amount:          decimal.NewFromInt(int64(len(accountIDs)) * 10),
+		Currency:        "IDR",
+		CreatedAt:       time.Now(),
+		LedgerEntries: []Ledger{
+			{TransactionID: txID, AccountID: fundingID, Amount: decimal.NewFromInt(int64(len(accountIDs)) * -10), Currency: "IDR", CreatedAt: time.Now()},
+		},
+		Summaries: map[string]decimal.Decimal{
+			fundingID: decimal.NewFromInt(int64(len(accountIDs)) * -10),
+		},
+		Currencies: map[string]string{
+			fundingID: "IDR",
+		},
+	}
+	for _, accountID := range accountIDs {
+		tx.LedgerEntries = append(tx.LedgerEntries, Ledger{TransactionID: txID, AccountID: accountID, Amount: decimal.NewFromInt(10), Currency: "IDR", CreatedAt: time.Now()})
+		tx.Summaries[accountID] = decimal.NewFromInt(10)
+		tx.Currencies[accountID] = "IDR"
+	}
+	if err := testPG.CreateTransaction(context.Background(), tx); err != nil {
+		t.Fatal(err)
+	}
+
+	balances, err := testPG.GetAccountsBalance(context.Background(), append(accountIDs, fundingID)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != len(accountIDs)+1 {
+		t.Fatalf("expecting %d account balances but got %d", len(accountIDs)+1, len(balances))
+	}
+	for _, balance := range balances {
+		expect := decimal.NewFromInt(10)
+		if balance.AccountID == fundingID {
+			expect = decimal.NewFromInt(int64(len(accountIDs)) * -10)
+		}
+		if !balance.Balance.Equal(expect) {
+			t.Fatalf("expecting balance of %s to be %s but got %s", balance.AccountID, expect, balance.Balance)
+		}
+		if balance.LastTransactionID != txID {
+			t.Fatalf("expecting last transaction id %s but got %s", txID, balance.LastTransactionID)
+		}
+	}
+
+	entries, err := testPG.GetLedgerByTransactionID(context.Background(), txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(accountIDs)+1 {
+		t.Fatalf("expecting %d ledger entries but got %d", len(accountIDs)+1, len(entries))
+	}
+}
+
+// TestCreateTransactionDuplicateLedgerEntry tests the transaction with more than one ledger entry for an account is rejected
+// by the primary key of accounts_ledger with ErrDuplicateLedgerEntry, on both write paths.
+func TestCreateTransactionDuplicateLedgerEntry(t *testing.T) {
+	t.Cleanup(func() {
+		TruncateTables(t, testPG, "accounts", "accounts_balance", "transaction", "accounts_ledger")
+	})
+
+	for _, account := range []Account{
+		{ID: "dup-fund", AccountType: "funding", Currency: "IDR", AllowNegativeBalance: true, CreatedAt: time.Now()},
+		{ID: "dup-acc", AccountType: "user", Currency: "IDR", CreatedAt: time.Now()},
+	} {
+		if err := testPG.CreateAccount(context.Background(), account); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, storage := range []*Postgres{testPG, NewPostgres(testPG.db, PostgresConfig{WriteFunction: true})} {
+		txID := uuid.NewString()
+		err := storage.CreateTransaction(context.Background(), CreateTransaction{
+			TransactionID:   txID,
+			TransactionType: "posting",
+			Amount:          decimal.NewFromInt(20),
+			Currency:        "IDR",
+			CreatedAt:       time.Now(),
+			LedgerEntries: []Ledger{
+				{TransactionID: txID, AccountID: "dup-fund", Amount: decimal.NewFromInt(-20), Currency: "IDR", CreatedAt: time.Now()},
+				{TransactionID: txID, AccountID: "dup-acc", Amount: decimal.NewFromInt(10), Currency: "IDR", CreatedAt: time.Now()},
+				{TransactionID: txID, AccountID: "dup-acc", Amount: decimal.NewFromInt(10), Currency: "IDR", CreatedAt: time.Now()},
+			},
+			Summaries:  map[string]decimal.Decimal{"dup-fund": decimal.NewFromInt(-20), "dup-acc": decimal.NewFromInt(20)},
+			Currencies: map[string]string{"dup-fund": "IDR", "dup-acc": "IDR"},
+		})
+		if !errors.Is(err, ErrDuplicateLedgerEntry) {
+			t.Fatalf("expecting error %v but got %v", ErrDuplicateLedgerEntry, err)
+		}
+	}
+
+	balances, err := testPG.GetAccountsBalance(context.Background(), "dup-acc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 1 || !balances[0].Balance.IsZero() {
+		t.Fatalf("expecting the balance to be unchanged but got %+v", balances)
+	}
+}
+
+// TestGrantAgain tests granting the revoked access again creates a new grant, and the revoked grant is kept.
+func TestGrantAgain(t *testing.T) {
+	t.Cleanup(func() {
+		TruncateTables(t, testPG, "account_grants")
+	})
+	ctx := context.Background()
+
+	grant := AccountGrant{AccountID: "grant-acc", GranteeID: "tenant-2", CreatedBy: sql.NullString{String: "tenant-1", Valid: true}, CreatedAt: time.Now()}
+	for i := 0; i < 2; i++ {
+		if err := testPG.CreateAccountGrant(ctx, grant); err != nil {
+			t.Fatal(err)
+		}
+		if err := testPG.RevokeAccountGrant(ctx, "grant-acc", "tenant-2", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := testPG.CreateAccountGrant(ctx, grant); err != nil {
+		t.Fatal(err)
+	}
+	// Granting the active access again doesn't create a new grant.
+	if err := testPG.CreateAccountGrant(ctx, grant); err != nil {
+		t.Fatal(err)
+	}
+
+	grants, err := testPG.GetAccountGrants(ctx, "grant-acc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 1 {
+		t.Fatalf("expecting one active grant but got %+v", grants)
+	}
+	var revoked int
+	err = testPG.db.QueryRowContext(ctx, `
+		SELECT count(*) FROM account_grants
+		WHERE account_id = 'grant-acc' AND grantee_id = 'tenant-2' AND revoked_at IS NOT NULL;
+	`).Scan(&revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked != 2 {
+		t.Fatalf("expecting 2 revoked grants to be kept but got %d", revoked)
+	}
+}
+
+func TestCreateTransactionOppositeDirections(t *testing.T) {
+	t.Cleanup(func() {
+		TruncateTables(t, testPG, "accounts", "accounts_balance", "transaction", "accounts_ledger")
+	})
+
+	for _, accountID := range []string{"one", "two"} {
+		if err := testPG.CreateAccount(context.Background(), Account{
+			ID:                   accountID,
+			AccountType:          "user",
+			Currency:             "IDR",
+			AllowNegativeBalance: true,
+			CreatedAt:            time.Now(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Transfers from one to two and from two to one lock the same accounts in different direction, the transactions
+	// must not deadlock each other because the accounts are always locked in the same order.
+	var wg sync.WaitGroup
+	errC := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		from, to := "one", "two"
+		if i%2 == 1 {
+			from, to = to, from
+		}
+		wg.Add(1)
+		go func(from, to string) {
+			defer wg.Done()
+			txID := uuid.NewString()
+			errC <- testPG.CreateTransaction(context.Background(), CreateTransaction{
+				TransactionID:   txID,
+				TransactionType: "transfer",
+				Amount:          decimal.NewFromInt(10),
+				Currency:        "IDR",
+				CreatedAt:       time.Now(),
+				LedgerEntries: []Ledger{
+					{TransactionID: txID, AccountID: from, Amount: decimal.NewFromInt(-10), Currency: "IDR", CreatedAt: time.Now()},
+					{TransactionID: txID, AccountID: to, Amount: decimal.NewFromInt(10), Currency: "IDR", CreatedAt: time.Now()},
+				},
+				Summaries:  map[string]decimal.Decimal{from: decimal.NewFromInt(-10), to: decimal.NewFromInt(10)},
+				Currencies: map[string]string{from: "IDR", to: "IDR"},
+			})
+		}(from, to)
+	}
+	wg.Wait()
+	close(errC)
+	for err := range errC {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	balances, err := testPG.GetAccountsBalance(context.Background(), "one", "two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, balance := range balances {
+		if !balance.Balance.IsZero() {
+			t.Fatalf("expecting zero balance of %s but got %s", balance.AccountID, balance.Balance)
+		}
+	}
+}
+
+// TestShardedAccount tests whether the concurrent postings to a sharded account are aggregated back into the balance of the
+// account, and unsharding the account moves the balance back into accounts_balance.
+func TestShardedAccount(t *testing.T) {
+	t.Cleanup(func() {
+		TruncateTables(t, testPG, "accounts", "accounts_balance", "accounts_balance_shards", "transaction", "accounts_ledger")
+	})
+
+	for _, acc := range []Account{
+		{ID: "fund", AccountType: "funding", Currency: "IDR", AllowNegativeBalance: true, CreatedAt: time.Now()},
+		{ID: "user", AccountType: "user", Currency: "IDR", CreatedAt: time.Now()},
+	} {
+		if err := testPG.CreateAccount(context.Background(), acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := testPG.SetAccountShards(context.Background(), "fund", 4, ShardStrategyRandom, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errC := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			txID := uuid.NewString()
+			errC <- testPG.CreateTransaction(context.Background(), CreateTransaction{
+				TransactionID:   txID,
+				TransactionType: "transfer",
+				Amount:          decimal.NewFromInt(10),
+				Currency:        "IDR",
+				CreatedAt:       time.Now(),
+				LedgerEntries: []Ledger{
+					{TransactionID: txID, AccountID: "fund", Amount: decimal.NewFromInt(-10), Currency: "IDR", CreatedAt: time.Now()},
+					{TransactionID: txID, AccountID: "user", Amount: decimal.NewFromInt(10), Currency: "IDR", CreatedAt: time.Now()},
+				},
+				Summaries:  map[string]decimal.Decimal{"fund": decimal.NewFromInt(-10), "user": decimal.NewFromInt(10)},
+				Currencies: map[string]string{"fund": "IDR", "user": "IDR"},
+			})
+		}()
+	}
+	wg.Wait()
+	close(errC)
+	for err := range errC {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkBalance := func(t *testing.T, expect int64) {
+		t.Helper()
+		balances, err := testPG.GetAccountsBalance(context.Background(), "fund")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(balances) != 1 || !balances[0].Balance.Equal(decimal.NewFromInt(expect)) {
+			t.Fatalf("expecting balance %d but got %v", expect, balances)
+		}
+	}
+	checkBalance(t, -200)
+	if err := testPG.RebalanceShards(context.Background(), "fund", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(t, -200)
+	if err := testPG.SetAccountShards(context.Background(), "fund", 0, ShardStrategyRandom, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(t, -200)
+}
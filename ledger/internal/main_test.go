@@ -16,7 +16,7 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	testPG = NewPostgres(db)
+	testPG = NewPostgres(db, PostgresConfig{})
 
 	os.Exit(m.Run())
 }
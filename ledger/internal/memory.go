@@ -0,0 +1,736 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Memory is the in-memory implementation of the Storage. The records are lost when the process stops, so the storage is
+// mostly useful for tests that don't want to depend on a running Postgres.
+//
+// The semantics are the same with Postgres. All write operations are done inside a transaction that locks the records,
+// so the balance cannot change between the balance check and the update, and all changes of the transaction are discarded
+// if the transaction returns an error. Unlike Postgres, the transaction locks the whole storage instead of only the affected
+// rows, so the write operations are processed one by one.
+type Memory struct {
+	mu              sync.RWMutex
+	accounts        *table[Account]
+	balances        *table[AccountBalance]
+	shards          *table[AccountBalanceShard]
+	transactions    *table[Transaction]
+	holds           *table[Hold]
+	idempotencyKeys *table[IdempotencyKey]
+	fxRates         *table[FXRate]
+	grants          *table[AccountGrant]
+	// ledgers is append only, just like the accounts_ledger table.
+	ledgers       []Ledger
+	stagedLedgers []Ledger
+	picker        *shardPicker
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		accounts:        newTable[Account](),
+		balances:        newTable[AccountBalance](),
+		shards:          newTable[AccountBalanceShard](),
+		transactions:    newTable[Transaction](),
+		holds:           newTable[Hold](),
+		idempotencyKeys: newTable[IdempotencyKey](),
+		fxRates:         newTable[FXRate](),
+		grants:          newTable[AccountGrant](),
+		picker:          &shardPicker{},
+	}
+}
+
+// table stores the records by their primary key. The changes inside a transaction are staged first, and only applied
+// to the records when the transaction is committed.
+type table[T any] struct {
+	rows   map[string]T
+	staged map[string]T
+}
+
+func newTable[T any]() *table[T] {
+	return &table[T]{
+		rows:   make(map[string]T),
+		staged: make(map[string]T),
+	}
+}
+
+func (t *table[T]) get(key string) (T, bool) {
+	if row, ok := t.staged[key]; ok {
+		return row, true
+	}
+	row, ok := t.rows[key]
+	return row, ok
+}
+
+func (t *table[T]) put(key string, row T) {
+	t.staged[key] = row
+}
+
+// insert puts the row only if the key is not exist, the function returns false if the key is already used.
+func (t *table[T]) insert(key string, row T) bool {
+	if _, ok := t.get(key); ok {
+		return false
+	}
+	t.put(key, row)
+	return true
+}
+
+func (t *table[T]) commit() {
+	for key, row := range t.staged {
+		t.rows[key] = row
+	}
+	clear(t.staged)
+}
+
+func (t *table[T]) rollback() {
+	clear(t.staged)
+}
+
+// transact runs fn with the write lock held. The staged changes are committed if fn returns nil, and discarded otherwise.
+func (m *Memory) transact(ctx context.Context, fn func() error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		m.accounts.rollback()
+		m.balances.rollback()
+		m.shards.rollback()
+		m.transactions.rollback()
+		m.holds.rollback()
+		m.idempotencyKeys.rollback()
+		m.fxRates.rollback()
+		m.grants.rollback()
+		m.stagedLedgers = nil
+		return err
+	}
+	m.accounts.commit()
+	m.balances.commit()
+	m.shards.commit()
+	m.transactions.commit()
+	m.holds.commit()
+	m.idempotencyKeys.commit()
+	m.fxRates.commit()
+	m.grants.commit()
+	m.ledgers = append(m.ledgers, m.stagedLedgers...)
+	m.stagedLedgers = nil
+	return nil
+}
+
+// Reset removes all records from the storage.
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.accounts = newTable[Account]()
+	m.balances = newTable[AccountBalance]()
+	m.shards = newTable[AccountBalanceShard]()
+	m.transactions = newTable[Transaction]()
+	m.holds = newTable[Hold]()
+	m.idempotencyKeys = newTable[IdempotencyKey]()
+	m.fxRates = newTable[FXRate]()
+	m.grants = newTable[AccountGrant]()
+	m.ledgers = nil
+}
+
+func (m *Memory) CreateAccount(ctx context.Context, acc Account) error {
+	return m.transact(ctx, func() error {
+		if !m.accounts.insert(acc.ID, Account{
+			ID:          acc.ID,
+			AccountType: acc.AccountType,
+			Currency:    acc.Currency,
+			OwnerID:     acc.OwnerID,
+			CreatedAt:   acc.CreatedAt,
+		}) {
+			return ErrAccountAlreadyExists
+		}
+		m.balances.put(acc.ID, AccountBalance{
+			AccountID:         acc.ID,
+			AllowNegative:     acc.AllowNegativeBalance,
+			Currency:          acc.Currency,
+			Balance:           decimal.Zero,
+			HeldBalance:       decimal.Zero,
+			LastTransactionID: "",
+			CreatedAt:         acc.CreatedAt,
+		})
+		return nil
+	})
+}
+
+func (m *Memory) GetAccount(ctx context.Context, accountID string) (Account, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	acc, ok := m.accounts.get(accountID)
+	if !ok {
+		return Account{}, sql.ErrNoRows
+	}
+	return acc, nil
+}
+
+func (m *Memory) GetAccountsBalance(ctx context.Context, accounts ...string) ([]AccountBalance, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var balances []AccountBalance
+	seen := make(map[string]bool, len(accounts))
+	for _, accountID := range accounts {
+		if seen[accountID] {
+			continue
+		}
+		seen[accountID] = true
+		if balance, ok := m.balances.get(accountID); ok {
+			balances = append(balances, m.aggregateBalance(balance))
+		}
+	}
+	return balances, nil
+}
+
+func (m *Memory) CreateTransaction(ctx context.Context, tx CreateTransaction) error {
+	return m.transact(ctx, func() error {
+		return m.createTransaction(tx)
+	})
+}
+
+// createTransaction follows the same steps with the Postgres implementation, and must be called inside transact.
+func (m *Memory) createTransaction(tx CreateTransaction) error {
+	// Same with the primary key of accounts_ledger, an account can only have one ledger entry in a transaction.
+	entries := make(map[string]bool, len(tx.LedgerEntries))
+	for _, ledger := range tx.LedgerEntries {
+		if entries[ledger.AccountID] {
+			return fmt.Errorf("%w: account_id %s", ErrDuplicateLedgerEntry, ledger.AccountID)
+		}
+		entries[ledger.AccountID] = true
+	}
+	if tx.IdempotencyKey != "" {
+		if !m.idempotencyKeys.insert(tx.IdempotencyKey, IdempotencyKey{
+			Key:           tx.IdempotencyKey,
+			RequestHash:   tx.RequestHash,
+			TransactionID: tx.TransactionID,
+			CreatedAt:     tx.CreatedAt,
+		}) {
+			return ErrDuplicateIdempotencyKey
+		}
+	}
+
+	ledgerMap := make(map[string][]Ledger)
+	for _, ledger := range tx.LedgerEntries {
+		ledgerMap[ledger.AccountID] = append(ledgerMap[ledger.AccountID], ledger)
+	}
+
+	updatedAt := sql.NullTime{Time: tx.CreatedAt, Valid: true}
+	for accID, amount := range tx.Summaries {
+		// Same with SELECT FOR UPDATE, the account that is not exist is ignored.
+		balance, ok := m.balances.get(accID)
+		if !ok {
+			continue
+		}
+		if currency, ok := tx.Currencies[accID]; ok && currency != balance.Currency {
+			return fmt.Errorf("%w: account_id %s is in %s, got %s", ErrCurrencyMismatch, accID, balance.Currency, currency)
+		}
+		total := m.aggregateBalance(balance).Balance
+		if total.Add(amount).Sub(balance.HeldBalance).LessThan(decimal.Zero) && !balance.AllowNegative && (balance.Shards == 0 || amount.IsNegative()) {
+			return ErrInsufficientBalance
+		}
+
+		previousBalance := decimal.NewNullDecimal(balance.Balance)
+		if balance.Shards > 0 {
+			shard, ok := m.shards.get(shardKey(accID, m.picker.pick(accID, balance.Shards, balance.ShardStrategy)))
+			if !ok {
+				return fmt.Errorf("shard of account_id %s is not exist", accID)
+			}
+			previousBalance = decimal.NullDecimal{}
+			shard.Balance = shard.Balance.Add(amount)
+			shard.LastTransactionID = tx.TransactionID
+			shard.UpdatedAt = updatedAt
+			m.shards.put(shardKey(accID, shard.Shard), shard)
+		} else {
+			balance.Balance = balance.Balance.Add(amount)
+			balance.LastTransactionID = tx.TransactionID
+			balance.UpdatedAt = updatedAt
+			m.balances.put(accID, balance)
+		}
+
+		currentBalance := previousBalance
+		for _, ledger := range ledgerMap[accID] {
+			if currentBalance.Valid {
+				currentBalance = decimal.NewNullDecimal(currentBalance.Decimal.Add(ledger.Amount))
+			}
+			m.stagedLedgers = append(m.stagedLedgers, Ledger{
+				TransactionID:   tx.TransactionID,
+				AccountID:       ledger.AccountID,
+				Amount:          ledger.Amount,
+				Currency:        ledger.Currency,
+				CurrentBalance:  currentBalance,
+				PreviousBalance: previousBalance,
+				CreatedAt:       ledger.CreatedAt,
+				Timestamp:       ledger.CreatedAt.UnixNano(),
+			})
+			previousBalance = currentBalance
+		}
+	}
+
+	if !m.transactions.insert(tx.TransactionID, Transaction{
+		TransactionID:   tx.TransactionID,
+		TransactionType: tx.TransactionType,
+		Amount:          tx.Amount,
+		Currency:        tx.Currency,
+		ReversalOf:      sql.NullString{String: tx.ReversalOf, Valid: tx.ReversalOf != ""},
+		ReversedAmount:  decimal.Zero,
+		FXRate:          tx.FXRate,
+		FXRateID:        sql.NullString{String: tx.FXRateID, Valid: tx.FXRateID != ""},
+		CreatedBy:       sql.NullString{String: tx.CreatedBy, Valid: tx.CreatedBy != ""},
+		CreatedAt:       tx.CreatedAt,
+	}) {
+		return fmt.Errorf("failed insert new transaction with error: duplicate transaction_id %s", tx.TransactionID)
+	}
+	return nil
+}
+
+func (m *Memory) GetTransaction(ctx context.Context, transactionID string) (Transaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tx, ok := m.transactions.get(transactionID)
+	if !ok {
+		return Transaction{}, sql.ErrNoRows
+	}
+	return tx, nil
+}
+
+func (m *Memory) ReverseTransaction(ctx context.Context, originalTransactionID string, amount decimal.Decimal, tx CreateTransaction) error {
+	return m.transact(ctx, func() error {
+		original, ok := m.transactions.get(originalTransactionID)
+		if !ok {
+			return sql.ErrNoRows
+		}
+		if original.ReversalOf.Valid {
+			return ErrReverseReversal
+		}
+		if original.ReversedAmount.Add(amount).GreaterThan(original.Amount) {
+			return ErrReversalExceedsAmount
+		}
+		original.ReversedAmount = original.ReversedAmount.Add(amount)
+		original.UpdatedAt = sql.NullTime{Time: tx.CreatedAt, Valid: true}
+		m.transactions.put(originalTransactionID, original)
+
+		tx.ReversalOf = originalTransactionID
+		return m.createTransaction(tx)
+	})
+}
+
+// GetLedgerByAccountID returns the ledger entries of the account ordered by (timestamp, transaction_id).
+func (m *Memory) GetLedgerByAccountID(ctx context.Context, accountID string, filter LedgerFilter) ([]Ledger, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []Ledger
+	for _, ledger := range m.ledgers {
+		if ledger.AccountID != accountID || !matchLedgerFilter(ledger, filter) {
+			continue
+		}
+		entries = append(entries, ledger)
+	}
+	sortLedgers(entries)
+
+	if filter.Limit > 0 && uint64(len(entries)) > filter.Limit {
+		// Walking the history backward from the Before cursor returns the entries right before the cursor.
+		if filter.Before != nil {
+			return entries[uint64(len(entries))-filter.Limit:], nil
+		}
+		return entries[:filter.Limit], nil
+	}
+	return entries, nil
+}
+
+func matchLedgerFilter(ledger Ledger, filter LedgerFilter) bool {
+	switch {
+	case filter.After != nil:
+		if compareLedgerCursor(ledger, *filter.After) <= 0 {
+			return false
+		}
+	case filter.Before != nil:
+		if compareLedgerCursor(ledger, *filter.Before) >= 0 {
+			return false
+		}
+	}
+	if !filter.FromTime.IsZero() && ledger.Timestamp < filter.FromTime.UnixNano() {
+		return false
+	}
+	if !filter.ToTime.IsZero() && ledger.Timestamp >= filter.ToTime.UnixNano() {
+		return false
+	}
+	switch filter.Direction {
+	case DirectionDebit:
+		if !ledger.Amount.IsNegative() {
+			return false
+		}
+	case DirectionCredit:
+		if !ledger.Amount.IsPositive() {
+			return false
+		}
+	}
+	if filter.MinAmount.Valid && ledger.Amount.Abs().LessThan(filter.MinAmount.Decimal) {
+		return false
+	}
+	if filter.MaxAmount.Valid && ledger.Amount.Abs().GreaterThan(filter.MaxAmount.Decimal) {
+		return false
+	}
+	return true
+}
+
+// compareLedgerCursor compares the (timestamp, transaction_id) of the ledger with the cursor.
+func compareLedgerCursor(ledger Ledger, cursor LedgerCursor) int {
+	switch {
+	case ledger.Timestamp < cursor.Timestamp:
+		return -1
+	case ledger.Timestamp > cursor.Timestamp:
+		return 1
+	case ledger.TransactionID < cursor.TransactionID:
+		return -1
+	case ledger.TransactionID > cursor.TransactionID:
+		return 1
+	}
+	return 0
+}
+
+func sortLedgers(entries []Ledger) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return compareLedgerCursor(entries[i], LedgerCursor{
+			Timestamp:     entries[j].Timestamp,
+			TransactionID: entries[j].TransactionID,
+		}) < 0
+	})
+}
+
+func (m *Memory) GetLedgerByTransactionID(ctx context.Context, transactionID string) ([]Ledger, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []Ledger
+	for _, ledger := range m.ledgers {
+		if ledger.TransactionID == transactionID {
+			entries = append(entries, ledger)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp < entries[j].Timestamp
+	})
+	return entries, nil
+}
+
+func (m *Memory) CreateHold(ctx context.Context, hold Hold) error {
+	return m.transact(ctx, func() error {
+		balance, ok := m.balances.get(hold.FromAccount)
+		if !ok {
+			return sql.ErrNoRows
+		}
+		if balance.Currency != hold.Currency {
+			return fmt.Errorf("%w: account_id %s is in %s, got %s", ErrCurrencyMismatch, hold.FromAccount, balance.Currency, hold.Currency)
+		}
+		available := m.aggregateBalance(balance).Balance.Sub(balance.HeldBalance)
+		if available.Sub(hold.Amount).LessThan(decimal.Zero) && !balance.AllowNegative {
+			return ErrInsufficientBalance
+		}
+
+		if !m.holds.insert(hold.HoldID, Hold{
+			HoldID:         hold.HoldID,
+			FromAccount:    hold.FromAccount,
+			ToAccount:      hold.ToAccount,
+			Amount:         hold.Amount,
+			Currency:       hold.Currency,
+			CapturedAmount: decimal.Zero,
+			Status:         HoldStatusAuthorized,
+			CreatedAt:      hold.CreatedAt,
+		}) {
+			return fmt.Errorf("duplicate hold_id %s", hold.HoldID)
+		}
+		m.updateHeldBalance(hold.FromAccount, hold.Amount, hold.CreatedAt)
+		return nil
+	})
+}
+
+func (m *Memory) GetHold(ctx context.Context, holdID string) (Hold, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hold, ok := m.holds.get(holdID)
+	if !ok {
+		return Hold{}, sql.ErrNoRows
+	}
+	return hold, nil
+}
+
+func (m *Memory) CaptureHold(ctx context.Context, holdID string, amount decimal.Decimal, tx CreateTransaction) error {
+	return m.transact(ctx, func() error {
+		hold, ok := m.holds.get(holdID)
+		if !ok {
+			return sql.ErrNoRows
+		}
+		if hold.Status != HoldStatusAuthorized {
+			return ErrHoldNotActive
+		}
+		if amount.GreaterThan(hold.Remaining()) {
+			return ErrCaptureExceedsHold
+		}
+
+		hold.CapturedAmount = hold.CapturedAmount.Add(amount)
+		if hold.CapturedAmount.Equal(hold.Amount) {
+			hold.Status = HoldStatusCaptured
+		}
+		hold.UpdatedAt = sql.NullTime{Time: tx.CreatedAt, Valid: true}
+		m.holds.put(holdID, hold)
+		m.updateHeldBalance(hold.FromAccount, amount.Neg(), tx.CreatedAt)
+		return m.createTransaction(tx)
+	})
+}
+
+func (m *Memory) VoidHold(ctx context.Context, holdID string, voidedAt time.Time) error {
+	return m.transact(ctx, func() error {
+		hold, ok := m.holds.get(holdID)
+		if !ok {
+			return sql.ErrNoRows
+		}
+		if hold.Status != HoldStatusAuthorized {
+			return ErrHoldNotActive
+		}
+		hold.Status = HoldStatusVoided
+		hold.UpdatedAt = sql.NullTime{Time: voidedAt, Valid: true}
+		m.holds.put(holdID, hold)
+		m.updateHeldBalance(hold.FromAccount, hold.Remaining().Neg(), voidedAt)
+		return nil
+	})
+}
+
+// updateHeldBalance adds the amount to the held balance of the account, and must be called inside transact.
+func (m *Memory) updateHeldBalance(accountID string, amount decimal.Decimal, updatedAt time.Time) {
+	balance, ok := m.balances.get(accountID)
+	if !ok {
+		return
+	}
+	balance.HeldBalance = balance.HeldBalance.Add(amount)
+	balance.UpdatedAt = sql.NullTime{Time: updatedAt, Valid: true}
+	m.balances.put(accountID, balance)
+}
+
+func (m *Memory) GetIdempotencyKey(ctx context.Context, key string) (IdempotencyKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	idem, ok := m.idempotencyKeys.get(key)
+	if !ok {
+		return IdempotencyKey{}, sql.ErrNoRows
+	}
+	return idem, nil
+}
+
+func (m *Memory) CreateFXRate(ctx context.Context, rate FXRate) error {
+	return m.transact(ctx, func() error {
+		if !m.fxRates.insert(rate.RateID, rate) {
+			return fmt.Errorf("duplicate rate_id %s", rate.RateID)
+		}
+		return nil
+	})
+}
+
+func (m *Memory) GetFXRate(ctx context.Context, rateID string) (FXRate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rate, ok := m.fxRates.get(rateID)
+	if !ok {
+		return FXRate{}, sql.ErrNoRows
+	}
+	return rate, nil
+}
+
+// GetFXRates returns the FX rates ordered by the newest ValidFrom first.
+func (m *Memory) GetFXRates(ctx context.Context, filter FXRateFilter) ([]FXRate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rates []FXRate
+	for _, rate := range m.fxRates.rows {
+		if filter.BaseCurrency != "" && rate.BaseCurrency != filter.BaseCurrency {
+			continue
+		}
+		if filter.QuoteCurrency != "" && rate.QuoteCurrency != filter.QuoteCurrency {
+			continue
+		}
+		if !filter.At.IsZero() {
+			if rate.ValidFrom.After(filter.At) || (rate.ValidTo.Valid && !rate.ValidTo.Time.After(filter.At)) {
+				continue
+			}
+		}
+		rates = append(rates, rate)
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		if !rates[i].ValidFrom.Equal(rates[j].ValidFrom) {
+			return rates[i].ValidFrom.After(rates[j].ValidFrom)
+		}
+		return rates[i].CreatedAt.After(rates[j].CreatedAt)
+	})
+	return rates, nil
+}
+
+func grantKey(accountID, granteeID string) string {
+	return accountID + "/" + granteeID
+}
+
+// CreateAccountGrant keeps the active grant under grantKey. Like Postgres, the revoked grant is kept for the audit, it is
+// moved under its own key before the access is granted again.
+func (m *Memory) CreateAccountGrant(ctx context.Context, grant AccountGrant) error {
+	return m.transact(ctx, func() error {
+		key := grantKey(grant.AccountID, grant.GranteeID)
+		existing, ok := m.grants.get(key)
+		if ok && !existing.RevokedAt.Valid {
+			return nil
+		}
+		for n := 0; ok && !m.grants.insert(fmt.Sprintf("%s/revoked/%d", key, n), existing); n++ {
+		}
+		m.grants.put(key, grant)
+		return nil
+	})
+}
+
+func (m *Memory) RevokeAccountGrant(ctx context.Context, accountID, granteeID string, revokedAt time.Time) error {
+	return m.transact(ctx, func() error {
+		grant, ok := m.grants.get(grantKey(accountID, granteeID))
+		if !ok || grant.RevokedAt.Valid {
+			return sql.ErrNoRows
+		}
+		grant.RevokedAt = sql.NullTime{Time: revokedAt, Valid: true}
+		m.grants.put(grantKey(accountID, granteeID), grant)
+		return nil
+	})
+}
+
+// GetAccountGrants returns the active grants of the account ordered by the grantee_id.
+func (m *Memory) GetAccountGrants(ctx context.Context, accountID string) ([]AccountGrant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var grants []AccountGrant
+	for _, grant := range m.grants.rows {
+		if grant.AccountID == accountID && !grant.RevokedAt.Valid {
+			grants = append(grants, grant)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].GranteeID < grants[j].GranteeID
+	})
+	return grants, nil
+}
+
+func shardKey(accountID string, shard int) string {
+	return fmt.Sprintf("%s/%d", accountID, shard)
+}
+
+// accountShards returns the sub-balances of the account ordered by the shard.
+func (m *Memory) accountShards(balance AccountBalance) []AccountBalanceShard {
+	shards := make([]AccountBalanceShard, 0, balance.Shards)
+	for i := 0; i < balance.Shards; i++ {
+		if shard, ok := m.shards.get(shardKey(balance.AccountID, i)); ok {
+			shards = append(shards, shard)
+		}
+	}
+	return shards
+}
+
+// aggregateBalance returns the balance with the total balance of the sub-balances if the account is sharded.
+func (m *Memory) aggregateBalance(balance AccountBalance) AccountBalance {
+	if balance.Shards == 0 {
+		return balance
+	}
+	shards := m.accountShards(balance)
+	balance.Balance, balance.LastTransactionID = aggregateShards(balance, shards)
+	for _, shard := range shards {
+		if shard.UpdatedAt.Valid && (!balance.UpdatedAt.Valid || shard.UpdatedAt.Time.After(balance.UpdatedAt.Time)) {
+			balance.UpdatedAt = shard.UpdatedAt
+		}
+	}
+	return balance
+}
+
+func (m *Memory) SetAccountShards(ctx context.Context, accountID string, shards int, strategy string, updatedAt time.Time) error {
+	return m.transact(ctx, func() error {
+		balance, ok := m.balances.get(accountID)
+		if !ok {
+			return sql.ErrNoRows
+		}
+		total, lastTransactionID := aggregateShards(balance, m.accountShards(balance))
+		// The old sub-balances are emptied instead of deleted, they are not part of the balance of the account anymore
+		// once the number of shards is changed.
+		for _, shard := range m.accountShards(balance) {
+			shard.Balance = decimal.Zero
+			m.shards.put(shardKey(accountID, shard.Shard), shard)
+		}
+
+		balance.Balance = total
+		if shards > 0 {
+			balance.Balance = decimal.Zero
+			for shard, shardBalance := range splitBalance(total, shards) {
+				m.shards.put(shardKey(accountID, shard), AccountBalanceShard{
+					AccountID: accountID,
+					Shard:     shard,
+					Balance:   shardBalance,
+					CreatedAt: updatedAt,
+				})
+			}
+		}
+		balance.LastTransactionID = lastTransactionID
+		balance.Shards = shards
+		balance.ShardStrategy = strategy
+		balance.UpdatedAt = sql.NullTime{Time: updatedAt, Valid: true}
+		m.balances.put(accountID, balance)
+		return nil
+	})
+}
+
+func (m *Memory) RebalanceShards(ctx context.Context, accountID string, updatedAt time.Time) error {
+	return m.transact(ctx, func() error {
+		balance, ok := m.balances.get(accountID)
+		if !ok {
+			return sql.ErrNoRows
+		}
+		if balance.Shards == 0 {
+			return nil
+		}
+		shards := m.accountShards(balance)
+		total, _ := aggregateShards(balance, shards)
+		for i, shardBalance := range splitBalance(total, len(shards)) {
+			shards[i].Balance = shardBalance
+			m.shards.put(shardKey(accountID, shards[i].Shard), shards[i])
+		}
+		if !balance.Balance.IsZero() {
+			balance.Balance = decimal.Zero
+			balance.UpdatedAt = sql.NullTime{Time: updatedAt, Valid: true}
+			m.balances.put(accountID, balance)
+		}
+		return nil
+	})
+}
+
+func (m *Memory) GetShardedAccounts(ctx context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var accountIDs []string
+	for accountID, balance := range m.balances.rows {
+		if balance.Shards > 0 {
+			accountIDs = append(accountIDs, accountID)
+		}
+	}
+	sort.Strings(accountIDs)
+	return accountIDs, nil
+}
@@ -0,0 +1,471 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func createMemoryAccounts(t *testing.T, mem *Memory, accounts ...Account) {
+	t.Helper()
+
+	for _, acc := range accounts {
+		if acc.Currency == "" {
+			acc.Currency = "IDR"
+		}
+		acc.CreatedAt = time.Now()
+		if err := mem.CreateAccount(context.Background(), acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func memoryTransfer(from, to string, amount int64) CreateTransaction {
+	txID := uuid.NewString()
+	now := time.Now()
+	return CreateTransaction{
+		TransactionID:   txID,
+		TransactionType: "transfer",
+		Amount:          decimal.NewFromInt(amount),
+		Currency:        "IDR",
+		CreatedAt:       now,
+		LedgerEntries: []Ledger{
+			{TransactionID: txID, AccountID: from, Amount: decimal.NewFromInt(-amount), Currency: "IDR", CreatedAt: now},
+			{TransactionID: txID, AccountID: to, Amount: decimal.NewFromInt(amount), Currency: "IDR", CreatedAt: now},
+		},
+		Summaries: map[string]decimal.Decimal{
+			from: decimal.NewFromInt(-amount),
+			to:   decimal.NewFromInt(amount),
+		},
+		Currencies: map[string]string{
+			from: "IDR",
+			to:   "IDR",
+		},
+	}
+}
+
+func TestMemoryCreateAccount(t *testing.T) {
+	t.Parallel()
+	mem := NewMemory()
+
+	createMemoryAccounts(t, mem, Account{ID: "acc-1", AccountType: "user"})
+	err := mem.CreateAccount(context.Background(), Account{ID: "acc-1", AccountType: "user", Currency: "IDR"})
+	if !errors.Is(err, ErrAccountAlreadyExists) {
+		t.Fatalf("expecting error %v but got %v", ErrAccountAlreadyExists, err)
+	}
+
+	if _, err := mem.GetAccount(context.Background(), "unknown"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expecting error %v but got %v", sql.ErrNoRows, err)
+	}
+	balances, err := mem.GetAccountsBalance(context.Background(), "acc-1", "acc-1", "unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 1 {
+		t.Fatalf("expecting 1 account balance but got %d", len(balances))
+	}
+}
+
+// TestMemoryCreateTransactionRollback tests whether all changes of the failed transaction are discarded.
+func TestMemoryCreateTransactionRollback(t *testing.T) {
+	t.Parallel()
+	mem := NewMemory()
+
+	createMemoryAccounts(t, mem,
+		Account{ID: "fund", AccountType: "funding", AllowNegativeBalance: true},
+		Account{ID: "acc-1", AccountType: "user"},
+		Account{ID: "acc-2", AccountType: "user"},
+	)
+	if err := mem.CreateTransaction(context.Background(), memoryTransfer("fund", "acc-1", 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := memoryTransfer("acc-1", "acc-2", 101)
+	tx.IdempotencyKey = "key-1"
+	if err := mem.CreateTransaction(context.Background(), tx); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expecting error %v but got %v", ErrInsufficientBalance, err)
+	}
+
+	if _, err := mem.GetTransaction(context.Background(), tx.TransactionID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expecting error %v but got %v", sql.ErrNoRows, err)
+	}
+	if _, err := mem.GetIdempotencyKey(context.Background(), "key-1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expecting error %v but got %v", sql.ErrNoRows, err)
+	}
+	entries, err := mem.GetLedgerByTransactionID(context.Background(), tx.TransactionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expecting no ledger entries but got %d", len(entries))
+	}
+	balances, err := mem.GetAccountsBalance(context.Background(), "acc-1", "acc-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !balances[0].Balance.Equal(decimal.NewFromInt(100)) || !balances[1].Balance.IsZero() {
+		t.Fatalf("expecting balances to be unchanged but got %s and %s", balances[0].Balance, balances[1].Balance)
+	}
+
+	// The same key can be used again as the failed transaction is rolled back.
+	tx = memoryTransfer("acc-1", "acc-2", 100)
+	tx.IdempotencyKey = "key-1"
+	if err := mem.CreateTransaction(context.Background(), tx); err != nil {
+		t.Fatal(err)
+	}
+	tx = memoryTransfer("acc-2", "acc-1", 10)
+	tx.IdempotencyKey = "key-1"
+	if err := mem.CreateTransaction(context.Background(), tx); !errors.Is(err, ErrDuplicateIdempotencyKey) {
+		t.Fatalf("expecting error %v but got %v", ErrDuplicateIdempotencyKey, err)
+	}
+}
+
+// TestMemoryDuplicateLedgerEntry tests the transaction with more than one ledger entry for an account is rejected, like the
+// primary key of accounts_ledger in Postgres.
+func TestMemoryDuplicateLedgerEntry(t *testing.T) {
+	t.Parallel()
+	mem := NewMemory()
+
+	createMemoryAccounts(t, mem,
+		Account{ID: "fund", AccountType: "funding", AllowNegativeBalance: true},
+		Account{ID: "acc-1", AccountType: "user"},
+	)
+	tx := memoryTransfer("fund", "acc-1", 100)
+	tx.LedgerEntries = append(tx.LedgerEntries,
+		Ledger{TransactionID: tx.TransactionID, AccountID: "fund", Amount: decimal.NewFromInt(-10), Currency: "IDR", CreatedAt: tx.CreatedAt},
+		Ledger{TransactionID: tx.TransactionID, AccountID: "acc-1", Amount: decimal.NewFromInt(10), Currency: "IDR", CreatedAt: tx.CreatedAt},
+	)
+	tx.Summaries = map[string]decimal.Decimal{"fund": decimal.NewFromInt(-110), "acc-1": decimal.NewFromInt(110)}
+	if err := mem.CreateTransaction(context.Background(), tx); !errors.Is(err, ErrDuplicateLedgerEntry) {
+		t.Fatalf("expecting error %v but got %v", ErrDuplicateLedgerEntry, err)
+	}
+	if _, err := mem.GetTransaction(context.Background(), tx.TransactionID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expecting error %v but got %v", sql.ErrNoRows, err)
+	}
+	balances, err := mem.GetAccountsBalance(context.Background(), "acc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !balances[0].Balance.IsZero() {
+		t.Fatalf("expecting balance to be unchanged but got %s", balances[0].Balance)
+	}
+}
+
+// TestMemoryGrantAgain tests the revoked grant is kept when the access is granted again, like the grants in Postgres.
+func TestMemoryGrantAgain(t *testing.T) {
+	t.Parallel()
+	mem := NewMemory()
+	ctx := context.Background()
+
+	grant := AccountGrant{AccountID: "acc-1", GranteeID: "tenant-2", CreatedBy: sql.NullString{String: "tenant-1", Valid: true}, CreatedAt: time.Now()}
+	for i := 0; i < 2; i++ {
+		if err := mem.CreateAccountGrant(ctx, grant); err != nil {
+			t.Fatal(err)
+		}
+		if err := mem.RevokeAccountGrant(ctx, "acc-1", "tenant-2", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mem.CreateAccountGrant(ctx, grant); err != nil {
+		t.Fatal(err)
+	}
+	// Granting the active access again doesn't create a new grant.
+	if err := mem.CreateAccountGrant(ctx, grant); err != nil {
+		t.Fatal(err)
+	}
+
+	grants, err := mem.GetAccountGrants(ctx, "acc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 1 || grants[0].RevokedAt.Valid {
+		t.Fatalf("expecting one active grant but got %+v", grants)
+	}
+	var revoked int
+	for _, grant := range mem.grants.rows {
+		if grant.RevokedAt.Valid {
+			revoked++
+		}
+	}
+	if revoked != 2 {
+		t.Fatalf("expecting 2 revoked grants to be kept but got %d", revoked)
+	}
+}
+
+// TestMemoryCorrectBalance is the same with TestCorrectBalance, the balance cannot go negative even if the transactions
+// are created concurrently.
+func TestMemoryCorrectBalance(t *testing.T) {
+	t.Parallel()
+	mem := NewMemory()
+
+	createMemoryAccounts(t, mem,
+		Account{ID: "fund", AccountType: "funding", AllowNegativeBalance: true},
+		Account{ID: "one", AccountType: "user"},
+		Account{ID: "two", AccountType: "user"},
+	)
+	if err := mem.CreateTransaction(context.Background(), memoryTransfer("fund", "one", 100_000)); err != nil {
+		t.Fatal(err)
+	}
+
+	var errs []error
+	var errLock sync.Mutex
+	wg := sync.WaitGroup{}
+
+	// Create transaction of 3.000 each, and do 40 transactions concurrently with total of 120.000.
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := mem.CreateTransaction(context.Background(), memoryTransfer("one", "two", 3_000)); err != nil {
+				errLock.Lock()
+				errs = append(errs, err)
+				errLock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) != 7 {
+		t.Fatalf("expecting 7 errors but got %d", len(errs))
+	}
+	balances, err := mem.GetAccountsBalance(context.Background(), "one", "two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !balances[0].Balance.Equal(decimal.NewFromInt(1_000)) {
+		t.Fatalf("expecting balance %d but got %s", 1_000, balances[0].Balance)
+	}
+	if !balances[1].Balance.Equal(decimal.NewFromInt(99_000)) {
+		t.Fatalf("expecting balance %d but got %s", 99_000, balances[1].Balance)
+	}
+
+	// The ledger entries must be chained, the previous balance of an entry is the current balance of the entry before.
+	entries, err := mem.GetLedgerByAccountID(context.Background(), "two", LedgerFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 33 {
+		t.Fatalf("expecting 33 ledger entries but got %d", len(entries))
+	}
+	total := decimal.Zero
+	for _, entry := range entries {
+		total = total.Add(entry.Amount)
+	}
+	if !total.Equal(decimal.NewFromInt(99_000)) {
+		t.Fatalf("expecting total of 99000 but got %s", total)
+	}
+}
+
+func TestMemoryHold(t *testing.T) {
+	t.Parallel()
+	mem := NewMemory()
+
+	createMemoryAccounts(t, mem,
+		Account{ID: "fund", AccountType: "funding", AllowNegativeBalance: true},
+		Account{ID: "acc-1", AccountType: "user"},
+		Account{ID: "acc-2", AccountType: "user"},
+	)
+	if err := mem.CreateTransaction(context.Background(), memoryTransfer("fund", "acc-1", 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	hold := Hold{
+		HoldID:      uuid.NewString(),
+		FromAccount: "acc-1",
+		ToAccount:   "acc-2",
+		Amount:      decimal.NewFromInt(80),
+		Currency:    "IDR",
+		CreatedAt:   time.Now(),
+	}
+	if err := mem.CreateHold(context.Background(), hold); err != nil {
+		t.Fatal(err)
+	}
+
+	// The held balance cannot be used by other transaction.
+	if err := mem.CreateTransaction(context.Background(), memoryTransfer("acc-1", "acc-2", 30)); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expecting error %v but got %v", ErrInsufficientBalance, err)
+	}
+
+	// The capture that fails in the transaction must not change the hold.
+	capture := memoryTransfer("acc-1", "unknown", 50)
+	capture.Currencies["unknown"] = "USD"
+	capture.Currencies["acc-1"] = "USD"
+	if err := mem.CaptureHold(context.Background(), hold.HoldID, decimal.NewFromInt(50), capture); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Fatalf("expecting error %v but got %v", ErrCurrencyMismatch, err)
+	}
+	if err := mem.CaptureHold(context.Background(), hold.HoldID, decimal.NewFromInt(50), memoryTransfer("acc-1", "acc-2", 50)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.CaptureHold(context.Background(), hold.HoldID, decimal.NewFromInt(31), memoryTransfer("acc-1", "acc-2", 31)); !errors.Is(err, ErrCaptureExceedsHold) {
+		t.Fatalf("expecting error %v but got %v", ErrCaptureExceedsHold, err)
+	}
+	if err := mem.VoidHold(context.Background(), hold.HoldID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.VoidHold(context.Background(), hold.HoldID, time.Now()); !errors.Is(err, ErrHoldNotActive) {
+		t.Fatalf("expecting error %v but got %v", ErrHoldNotActive, err)
+	}
+
+	got, err := mem.GetHold(context.Background(), hold.HoldID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != HoldStatusVoided || !got.CapturedAmount.Equal(decimal.NewFromInt(50)) {
+		t.Fatalf("expecting voided hold with captured amount 50 but got %s with %s", got.Status, got.CapturedAmount)
+	}
+	balances, err := mem.GetAccountsBalance(context.Background(), "acc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !balances[0].Balance.Equal(decimal.NewFromInt(50)) || !balances[0].HeldBalance.IsZero() {
+		t.Fatalf("expecting balance 50 and held balance 0 but got %s and %s", balances[0].Balance, balances[0].HeldBalance)
+	}
+}
+
+func TestMemoryGetLedgerByAccountID(t *testing.T) {
+	t.Parallel()
+	mem := NewMemory()
+
+	createMemoryAccounts(t, mem,
+		Account{ID: "fund", AccountType: "funding", AllowNegativeBalance: true},
+		Account{ID: "acc-1", AccountType: "user"},
+	)
+	for i := int64(1); i <= 5; i++ {
+		if err := mem.CreateTransaction(context.Background(), memoryTransfer("fund", "acc-1", i*10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := mem.GetLedgerByAccountID(context.Background(), "acc-1", LedgerFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 5 {
+		t.Fatalf("expecting 5 ledger entries but got %d", len(all))
+	}
+
+	after, err := mem.GetLedgerByAccountID(context.Background(), "acc-1", LedgerFilter{
+		Limit: 2,
+		After: &LedgerCursor{Timestamp: all[1].Timestamp, TransactionID: all[1].TransactionID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 2 || !after[0].Amount.Equal(decimal.NewFromInt(30)) || !after[1].Amount.Equal(decimal.NewFromInt(40)) {
+		t.Fatalf("expecting entries of 30 and 40 after the cursor but got %v", after)
+	}
+
+	before, err := mem.GetLedgerByAccountID(context.Background(), "acc-1", LedgerFilter{
+		Limit:  2,
+		Before: &LedgerCursor{Timestamp: all[4].Timestamp, TransactionID: all[4].TransactionID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 2 || !before[0].Amount.Equal(decimal.NewFromInt(30)) || !before[1].Amount.Equal(decimal.NewFromInt(40)) {
+		t.Fatalf("expecting entries of 30 and 40 before the cursor but got %v", before)
+	}
+
+	filtered, err := mem.GetLedgerByAccountID(context.Background(), "fund", LedgerFilter{
+		Direction: DirectionDebit,
+		MinAmount: decimal.NewNullDecimal(decimal.NewFromInt(20)),
+		MaxAmount: decimal.NewNullDecimal(decimal.NewFromInt(40)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered) != 3 {
+		t.Fatalf("expecting 3 ledger entries but got %d", len(filtered))
+	}
+}
+
+// TestMemoryShards tests whether the postings to a sharded account are spread across the sub-balances, and the balance of
+// the account is the SUM of the sub-balances.
+func TestMemoryShards(t *testing.T) {
+	t.Parallel()
+	mem := NewMemory()
+
+	createMemoryAccounts(t, mem,
+		Account{ID: "fund", AccountType: "funding", AllowNegativeBalance: true},
+		Account{ID: "acc-1", AccountType: "user"},
+	)
+	if err := mem.CreateTransaction(context.Background(), memoryTransfer("fund", "acc-1", 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.SetAccountShards(context.Background(), "fund", 3, ShardStrategyRoundRobin, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := mem.CreateTransaction(context.Background(), memoryTransfer("fund", "acc-1", 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	balance, ok := mem.balances.get("fund")
+	if !ok {
+		t.Fatal("fund account is not exist")
+	}
+	// The balance before sharding is split across the sub-balances, and every sub-balance is debited once.
+	for _, shard := range mem.accountShards(balance) {
+		if !shard.Balance.Equal(decimal.NewFromInt(-10).Add(splitBalance(decimal.NewFromInt(-10), 3)[shard.Shard])) {
+			t.Fatalf("unexpected balance %s of shard %d", shard.Balance, shard.Shard)
+		}
+	}
+	balances, err := mem.GetAccountsBalance(context.Background(), "fund")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !balances[0].Balance.Equal(decimal.NewFromInt(-40)) {
+		t.Fatalf("expecting balance -40 but got %s", balances[0].Balance)
+	}
+
+	if err := mem.RebalanceShards(context.Background(), "fund", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	expect := splitBalance(decimal.NewFromInt(-40), 3)
+	for _, shard := range mem.accountShards(balance) {
+		if !shard.Balance.Equal(expect[shard.Shard]) {
+			t.Fatalf("expecting balance %s of shard %d but got %s", expect[shard.Shard], shard.Shard, shard.Balance)
+		}
+	}
+
+	if err := mem.SetAccountShards(context.Background(), "fund", 0, ShardStrategyRandom, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	balances, err = mem.GetAccountsBalance(context.Background(), "fund")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balances[0].Shards != 0 || !balances[0].Balance.Equal(decimal.NewFromInt(-40)) {
+		t.Fatalf("expecting unsharded balance -40 but got %s with %d shards", balances[0].Balance, balances[0].Shards)
+	}
+}
+
+func TestMemorySplitBalance(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		total  string
+		n      int
+		expect []string
+	}{
+		{total: "100", n: 3, expect: []string{"34", "33", "33"}},
+		{total: "100.01", n: 3, expect: []string{"33.35", "33.33", "33.33"}},
+		{total: "-10", n: 4, expect: []string{"-4", "-2", "-2", "-2"}},
+		{total: "0", n: 2, expect: []string{"0", "0"}},
+	}
+	for _, test := range tests {
+		balances := splitBalance(decimal.RequireFromString(test.total), test.n)
+		for i, balance := range balances {
+			if !balance.Equal(decimal.RequireFromString(test.expect[i])) {
+				t.Fatalf("split %s into %d: expecting %v but got %v", test.total, test.n, test.expect, balances)
+			}
+		}
+	}
+}
@@ -1,13 +1,77 @@
 package internal
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/albertwidi/ftest/ledger/internal")
+
+// The phases of creating a transaction, the duration of every phase is reported to PostgresConfig.ObservePhase.
+const (
+	// PhaseIdempotency is the insert of the idempotency key.
+	PhaseIdempotency = "idempotency"
+	// PhaseLock is the lock of the balances, it includes the time to wait for the other transactions.
+	PhaseLock = "lock"
+	// PhaseWrite is the update of the balances and the insert of the transaction and the ledger entries.
+	PhaseWrite = "write"
+	// PhaseCommit is the commit of the database transaction.
+	PhaseCommit = "commit"
+	// PhaseFunction is the call of the ledger_create_transaction function, which does all the phases above in the database.
+	PhaseFunction = "function"
+)
+
+// PostgresConfig is the configuration of the Postgres storage.
+type PostgresConfig struct {
+	// WriteFunction creates the transactions with the ledger_create_transaction function instead of multiple statements
+	// inside a database transaction. The function also checks whether the accounts exist and have enough balance, so the
+	// caller doesn't need to check the balances before creating the transaction.
+	WriteFunction bool
+	// ObservePhase is called with the duration of every phase of creating a transaction, it is optional.
+	ObservePhase func(phase string, duration time.Duration)
+}
 
 type Postgres struct {
-	db *sql.DB
+	db     *sql.DB
+	config PostgresConfig
+	picker *shardPicker
 }
 
-func NewPostgres(db *sql.DB) *Postgres {
+func NewPostgres(db *sql.DB, config PostgresConfig) *Postgres {
 	return &Postgres{
-		db: db,
+		db:     db,
+		config: config,
+		picker: &shardPicker{},
+	}
+}
+
+// startPhase starts the span of the phase. The returned function ends the span, and reports the duration of the phase
+// if the phase succeeds.
+func (p *Postgres) startPhase(ctx context.Context, phase string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "Postgres.CreateTransaction."+phase)
+	return ctx, func(err error) {
+		defer span.End()
+		if err != nil {
+			recordError(span, err)
+			return
+		}
+		if p.config.ObservePhase != nil {
+			p.config.ObservePhase(phase, time.Since(start))
+		}
+	}
+}
+
+// recordError marks the span as failed with the error, if any.
+func recordError(span trace.Span, err error) {
+	if err == nil {
+		return
 	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
 }
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	// ErrReversalExceedsAmount returned when the cumulative amount of the reversals exceeds the amount of the original transaction.
+	ErrReversalExceedsAmount = errors.New("reversal amount exceeds the remaining amount of the transaction")
+	// ErrReverseReversal returned when trying to reverse a transaction that is a reversal itself.
+	ErrReverseReversal = errors.New("cannot reverse a reversal transaction")
+)
+
+// ReverseTransaction creates the reversal transaction of the original transaction. The original transaction is locked
+// so the cumulative amount of the reversals can be checked against the amount of the original transaction.
+func (p *Postgres) ReverseTransaction(ctx context.Context, originalTransactionID string, amount decimal.Decimal, tx CreateTransaction) error {
+	lockTransactionQuery := `
+		SELECT transaction_id, transaction_type, amount, currency, reversal_of, reversed_amount, fx_rate, fx_rate_id, created_by, created_at, updated_at
+		FROM transaction
+		WHERE transaction_id = $1
+		FOR UPDATE;
+	`
+	updateReversedAmountQuery := `
+		UPDATE transaction SET
+			reversed_amount = reversed_amount + $1,
+			updated_at = $2
+		WHERE transaction_id = $3;
+	`
+
+	return transact(ctx, p.db, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+	}, func(ctx context.Context, db *sql.Tx) error {
+		original, err := scanTransaction(db.QueryRowContext(ctx, lockTransactionQuery, originalTransactionID))
+		if err != nil {
+			return err
+		}
+		if original.ReversalOf.Valid {
+			return ErrReverseReversal
+		}
+		if original.ReversedAmount.Add(amount).GreaterThan(original.Amount) {
+			return ErrReversalExceedsAmount
+		}
+		if _, err := db.ExecContext(ctx, updateReversedAmountQuery, amount, tx.CreatedAt, originalTransactionID); err != nil {
+			return err
+		}
+		tx.ReversalOf = originalTransactionID
+		return p.createTransaction(ctx, db, tx)
+	})
+}
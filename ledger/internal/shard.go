@@ -0,0 +1,292 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+// The strategy to choose the sub-balance of a sharded account for a posting.
+const (
+	ShardStrategyRandom     = "random"
+	ShardStrategyRoundRobin = "round_robin"
+)
+
+// AccountBalanceShard is a sub-balance of a sharded account. The balance of a sharded account is split into multiple
+// sub-balances, and a posting only locks and changes one of them. This way the postings to a hot account, for example the
+// funding account, don't have to wait for each other.
+type AccountBalanceShard struct {
+	AccountID         string
+	Shard             int
+	Balance           decimal.Decimal
+	LastTransactionID string
+	CreatedAt         time.Time
+	UpdatedAt         sql.NullTime
+}
+
+// shardPicker chooses the sub-balance for a posting. The round-robin counter is kept per process, so the postings are
+// only spread evenly by the postings of the same process.
+type shardPicker struct {
+	counters sync.Map
+}
+
+func (p *shardPicker) pick(accountID string, shards int, strategy string) int {
+	if strategy == ShardStrategyRoundRobin {
+		counter, _ := p.counters.LoadOrStore(accountID, new(atomic.Uint64))
+		return int((counter.(*atomic.Uint64).Add(1) - 1) % uint64(shards))
+	}
+	return rand.Intn(shards)
+}
+
+// splitBalance splits the total into n balances as even as possible. The remainder of the division goes to the first
+// balance, so the SUM of the balances is always exactly the total.
+func splitBalance(total decimal.Decimal, n int) []decimal.Decimal {
+	var precision int32
+	if total.Exponent() < 0 {
+		precision = -total.Exponent()
+	}
+	share, remainder := total.QuoRem(decimal.NewFromInt(int64(n)), precision)
+	balances := make([]decimal.Decimal, n)
+	for i := range balances {
+		balances[i] = share
+	}
+	balances[0] = balances[0].Add(remainder)
+	return balances
+}
+
+// SetAccountShards changes the number of sub-balances of the account. The balance of the account is moved into the new
+// sub-balances, or back into the accounts_balance if the shards is zero. The function returns sql.ErrNoRows if the account
+// is not exist.
+//
+// The account is locked exclusively, so the function waits for all ongoing postings to the account.
+func (p *Postgres) SetAccountShards(ctx context.Context, accountID string, shards int, strategy string, updatedAt time.Time) error {
+	deleteShardsQuery := "DELETE FROM accounts_balance_shards WHERE account_id = $1;"
+	insertShardQuery := `
+		INSERT INTO accounts_balance_shards(account_id, shard, balance, last_transaction_id, created_at)
+		VALUES($1,$2,$3,'',$4);
+	`
+	updateBalanceQuery := `
+		UPDATE accounts_balance SET
+			balance = $1,
+			last_transaction_id = $2,
+			shards = $3,
+			shard_strategy = $4,
+			updated_at = $5
+		WHERE account_id = $6;
+	`
+
+	return transact(ctx, p.db, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+	}, func(ctx context.Context, db *sql.Tx) error {
+		balance, err := lockAccountBalance(ctx, db, accountID)
+		if err != nil {
+			return err
+		}
+		subBalances, err := getAccountBalanceShards(ctx, db, accountID)
+		if err != nil {
+			return err
+		}
+		total, lastTransactionID := aggregateShards(balance, subBalances)
+		if _, err := db.ExecContext(ctx, deleteShardsQuery, accountID); err != nil {
+			return err
+		}
+
+		balanceAfter := total
+		if shards > 0 {
+			balanceAfter = decimal.Zero
+			for shard, shardBalance := range splitBalance(total, shards) {
+				if _, err := db.ExecContext(ctx, insertShardQuery, accountID, shard, shardBalance, updatedAt); err != nil {
+					return err
+				}
+			}
+		}
+		_, err = db.ExecContext(ctx, updateBalanceQuery, balanceAfter, lastTransactionID, shards, strategy, updatedAt, accountID)
+		return err
+	})
+}
+
+// RebalanceShards spreads the balance of the sharded account evenly across its sub-balances. The postings change the
+// sub-balances unevenly, so without rebalancing the sub-balances drift away from each other. Rebalancing only moves the
+// balance between the sub-balances of the same account, so no transaction or ledger entry is created.
+//
+// The account is locked exclusively while rebalancing, so the postings to the account wait until the rebalance is done.
+func (p *Postgres) RebalanceShards(ctx context.Context, accountID string, updatedAt time.Time) error {
+	updateShardsQuery := `
+		UPDATE accounts_balance_shards AS abs SET
+			balance = v.balance
+		FROM unnest($2::int[], $3::numeric[]) AS v(shard, balance)
+		WHERE abs.account_id = $1 AND abs.shard = v.shard;
+	`
+	updateBalanceQuery := "UPDATE accounts_balance SET balance = 0, updated_at = $1 WHERE account_id = $2;"
+
+	return transact(ctx, p.db, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+	}, func(ctx context.Context, db *sql.Tx) error {
+		balance, err := lockAccountBalance(ctx, db, accountID)
+		if err != nil {
+			return err
+		}
+		if balance.Shards == 0 {
+			return nil
+		}
+		subBalances, err := getAccountBalanceShards(ctx, db, accountID)
+		if err != nil {
+			return err
+		}
+		total, _ := aggregateShards(balance, subBalances)
+
+		shards := make([]int64, 0, len(subBalances))
+		balances := make([]string, 0, len(subBalances))
+		for i, shardBalance := range splitBalance(total, len(subBalances)) {
+			shards = append(shards, int64(subBalances[i].Shard))
+			balances = append(balances, shardBalance.String())
+		}
+		if _, err := db.ExecContext(ctx, updateShardsQuery, accountID, pq.Array(shards), pq.Array(balances)); err != nil {
+			return err
+		}
+		if !balance.Balance.IsZero() {
+			if _, err := db.ExecContext(ctx, updateBalanceQuery, updatedAt, accountID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetShardedAccounts returns the account_id of all sharded accounts.
+func (p *Postgres) GetShardedAccounts(ctx context.Context) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT account_id FROM accounts_balance WHERE shards > 0 ORDER BY account_id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accountIDs []string
+	for rows.Next() {
+		var accountID string
+		if err := rows.Scan(&accountID); err != nil {
+			return nil, err
+		}
+		accountIDs = append(accountIDs, accountID)
+	}
+	return accountIDs, rows.Err()
+}
+
+// lockAccountBalance locks the accounts_balance of the account exclusively. For a sharded account, this also prevents
+// all postings to the sub-balances of the account until the transaction ends.
+func lockAccountBalance(ctx context.Context, db *sql.Tx, accountID string) (AccountBalance, error) {
+	query := `
+		SELECT account_id, currency, balance, held_balance, allow_negative, last_transaction_id, shards, shard_strategy
+		FROM accounts_balance
+		WHERE account_id = $1
+		FOR UPDATE;
+	`
+	balance := AccountBalance{}
+	err := db.QueryRowContext(ctx, query, accountID).Scan(
+		&balance.AccountID,
+		&balance.Currency,
+		&balance.Balance,
+		&balance.HeldBalance,
+		&balance.AllowNegative,
+		&balance.LastTransactionID,
+		&balance.Shards,
+		&balance.ShardStrategy,
+	)
+	return balance, err
+}
+
+// getAccountBalanceShards returns the sub-balances of the account ordered by the shard.
+func getAccountBalanceShards(ctx context.Context, db *sql.Tx, accountID string) ([]AccountBalanceShard, error) {
+	query := `
+		SELECT account_id, shard, balance, last_transaction_id, created_at, updated_at
+		FROM accounts_balance_shards
+		WHERE account_id = $1
+		ORDER BY shard;
+	`
+	rows, err := db.QueryContext(ctx, query, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shards []AccountBalanceShard
+	for rows.Next() {
+		shard := AccountBalanceShard{}
+		if err := rows.Scan(
+			&shard.AccountID,
+			&shard.Shard,
+			&shard.Balance,
+			&shard.LastTransactionID,
+			&shard.CreatedAt,
+			&shard.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		shards = append(shards, shard)
+	}
+	return shards, rows.Err()
+}
+
+// sumShardBalances returns the total balance of the sub-balances per account.
+func sumShardBalances(ctx context.Context, db *sql.Tx, accountIDs []string) (map[string]decimal.Decimal, error) {
+	query := `
+		SELECT account_id, SUM(balance)
+		FROM accounts_balance_shards
+		WHERE account_id = ANY($1)
+		GROUP BY account_id;
+	`
+	rows, err := db.QueryContext(ctx, query, pq.Array(accountIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sums := make(map[string]decimal.Decimal, len(accountIDs))
+	for rows.Next() {
+		var accountID string
+		var sum decimal.Decimal
+		if err := rows.Scan(&accountID, &sum); err != nil {
+			return nil, err
+		}
+		sums[accountID] = sum
+	}
+	return sums, rows.Err()
+}
+
+// addShardBalance adds the amount to the sub-balance. The sub-balance is locked by the update until the transaction ends.
+func addShardBalance(ctx context.Context, db *sql.Tx, accountID string, shard int, amount decimal.Decimal, transactionID string, updatedAt time.Time) error {
+	query := `
+		UPDATE accounts_balance_shards SET
+			balance = balance + $1,
+			last_transaction_id = $2,
+			updated_at = $3
+		WHERE account_id = $4 AND shard = $5
+		RETURNING shard;
+	`
+	if err := db.QueryRowContext(ctx, query, amount, transactionID, updatedAt, accountID, shard).Scan(&shard); err != nil {
+		return fmt.Errorf("failed to update shard %d of account_id %s with error: %w", shard, accountID, err)
+	}
+	return nil
+}
+
+// aggregateShards returns the total balance of the account and the last transaction_id that changed the balance.
+func aggregateShards(balance AccountBalance, shards []AccountBalanceShard) (decimal.Decimal, string) {
+	total := balance.Balance
+	lastTransactionID := balance.LastTransactionID
+	var lastUpdatedAt time.Time
+	for _, shard := range shards {
+		total = total.Add(shard.Balance)
+		if shard.LastTransactionID != "" && shard.UpdatedAt.Valid && shard.UpdatedAt.Time.After(lastUpdatedAt) {
+			lastTransactionID = shard.LastTransactionID
+			lastUpdatedAt = shard.UpdatedAt.Time
+		}
+	}
+	return total, lastTransactionID
+}
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Storage is the storage of the ledger. All implementations must have the same semantics, the balance of the accounts
+// are checked and updated atomically, and all changes of a failed operation are discarded. The functions that return a
+// single record return sql.ErrNoRows if the record is not exist.
+type Storage interface {
+	CreateAccount(ctx context.Context, acc Account) error
+	GetAccount(ctx context.Context, accountID string) (Account, error)
+	GetAccountsBalance(ctx context.Context, accounts ...string) ([]AccountBalance, error)
+	CreateTransaction(ctx context.Context, tx CreateTransaction) error
+	CreateTransactions(ctx context.Context, txs []CreateTransaction) []error
+	GetTransaction(ctx context.Context, transactionID string) (Transaction, error)
+	ReverseTransaction(ctx context.Context, originalTransactionID string, amount decimal.Decimal, tx CreateTransaction) error
+	GetLedgerByAccountID(ctx context.Context, accountID string, filter LedgerFilter) ([]Ledger, error)
+	GetLedgerByTransactionID(ctx context.Context, transactionID string) ([]Ledger, error)
+	CreateHold(ctx context.Context, hold Hold) error
+	GetHold(ctx context.Context, holdID string) (Hold, error)
+	CaptureHold(ctx context.Context, holdID string, amount decimal.Decimal, tx CreateTransaction) error
+	VoidHold(ctx context.Context, holdID string, voidedAt time.Time) error
+	GetIdempotencyKey(ctx context.Context, key string) (IdempotencyKey, error)
+	CreateFXRate(ctx context.Context, rate FXRate) error
+	GetFXRate(ctx context.Context, rateID string) (FXRate, error)
+	GetFXRates(ctx context.Context, filter FXRateFilter) ([]FXRate, error)
+	SetAccountShards(ctx context.Context, accountID string, shards int, strategy string, updatedAt time.Time) error
+	RebalanceShards(ctx context.Context, accountID string, updatedAt time.Time) error
+	GetShardedAccounts(ctx context.Context) ([]string, error)
+	CreateAccountGrant(ctx context.Context, grant AccountGrant) error
+	RevokeAccountGrant(ctx context.Context, accountID, granteeID string, revokedAt time.Time) error
+	GetAccountGrants(ctx context.Context, accountID string) ([]AccountGrant, error)
+}
+
+var (
+	_ Storage = (*Postgres)(nil)
+	_ Storage = (*Memory)(nil)
+)
@@ -1,9 +1,17 @@
 package internal
 
 import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"net/url"
 	"strings"
 	"testing"
+
+	"github.com/albertwidi/ftest/database"
+	"github.com/albertwidi/ftest/database/migrate"
 )
 
 // TruncateTables truncates list of tables passed in the parameter. This function is guarded by
@@ -22,3 +30,69 @@ func TruncateTables(t *testing.T, pg *Postgres, tables ...string) {
 		t.Log(err)
 	}
 }
+
+// NewTestDB creates a new schema in the Postgres of the dsn and applies the ledger migrations to the schema. The returned
+// database only uses the schema, so every test has its own tables and the tests can run in parallel against the same
+// Postgres. The schema is dropped when the test finishes.
+func NewTestDB(t *testing.T, dsn string) *sql.DB {
+	if !testing.Testing() {
+		panic("cannot create test database outside of go test")
+	}
+	t.Helper()
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + hex.EncodeToString(suffix)
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("failed to create schema %s: %v", schema, err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Logf("failed to drop schema %s: %v", schema, err)
+		}
+	})
+
+	schemaDSN, err := withSearchPath(dsn, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("postgres", schemaDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cleanups run in the reverse order, so the connections are closed before the schema is dropped.
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, database.LedgerMigrations, database.LedgerMigrationsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("failed to migrate schema %s: %v", schema, err)
+	}
+	return db
+}
+
+// withSearchPath sets the search_path of the connections of the dsn to the schema. The dsn is either a postgres:// URL or
+// a list of key=value, and the search_path is passed to Postgres as a run-time parameter of the connection.
+func withSearchPath(dsn, schema string) (string, error) {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " search_path=" + schema, nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
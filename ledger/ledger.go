@@ -10,20 +10,68 @@ import (
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 
+	"github.com/albertwidi/ftest/auth"
 	"github.com/albertwidi/ftest/ledger/internal"
 )
 
 const (
 	AccountTypeUser    = "user"
 	AccountTypeFunding = "funding"
+	// AccountTypeFXPosition is the type of the FX position accounts, the accounts are created automatically by the conversion.
+	AccountTypeFXPosition = "fx_position"
 )
 
-// TransactionBuildeer is an interface to define what type can build a transaction. The interface is a bit unique
-// because its implemented where it being defined because only want to use the interface internally to generalize
-// the builder type.
+const (
+	DirectionDebit  = internal.DirectionDebit
+	DirectionCredit = internal.DirectionCredit
+)
+
+const (
+	// DefaultPageLimit is the default number of ledger entries in a page.
+	DefaultPageLimit = 100
+	// MaxPageLimit is the maximum number of ledger entries in a page.
+	MaxPageLimit = 1000
+)
+
+const (
+	TransactionTypeTransfer = "transfer"
+	TransactionTypePosting  = "posting"
+	TransactionTypeCapture  = "capture"
+	TransactionTypeReversal = "reversal"
+	// TransactionTypeConversion is the type of the transaction that converts money between currencies with an FX rate.
+	TransactionTypeConversion = "conversion"
+)
+
+// Entry is a single DEBIT or CREDIT of a transaction for an account. A negative amount means DEBIT(money goes out of
+// the account), and a positive amount means CREDIT(money goes into the account).
+//
+// The Currency is the ISO 4217 currency code of the amount, DefaultCurrency is used if the currency is empty.
+//
+// Entry is also the DEBIT or CREDIT leg of a Posting, where the amount is always positive, see Posting.
+type Entry struct {
+	AccountID string
+	Amount    decimal.Decimal
+	Currency  string
+}
+
+// TransactionBuilder is an interface to define what type can build a transaction. Any type that implements the interface
+// can be posted to the ledger via Ledger.Post, and the ledger ensures the entries are balanced before creating the transaction.
 type TransactionBuilder interface {
-	validate() error
-	buildTransaction(transactionID string) internal.CreateTransaction
+	// Validate validates the request of the transaction before the entries are built.
+	Validate() error
+	// Entries returns all DEBIT and CREDIT entries of the transaction.
+	Entries() []Entry
+}
+
+// transactionTyper is implemented by the builders inside the package to define the type of the transaction. Builders
+// that don't implement the interface are recorded as TransactionTypePosting.
+type transactionTyper interface {
+	transactionType() string
+}
+
+// fxRated is implemented by the builders that convert money between currencies, so the executed rate is recorded on the transaction.
+type fxRated interface {
+	fxRate() (rate decimal.Decimal, rateID string)
 }
 
 // txSummaries is the summaries of the transaction per account. It contains the SUM of DEBIT/CREDIT amount.
@@ -41,31 +89,138 @@ func (sums txSumaries) accounts() []string {
 	return accounts
 }
 
+const (
+	// WritePathStatements creates the transaction with multiple statements inside a database transaction. The balances
+	// are checked by the ledger before the transaction is created.
+	WritePathStatements = "statements"
+	// WritePathFunction creates the transaction with a single call to the ledger_create_transaction function. The function
+	// validates the accounts, locks and updates the balances and inserts the ledger entries, so a transaction only takes
+	// one round trip to the database.
+	WritePathFunction = "function"
+)
+
+// Config is the configuration of the ledger.
+type Config struct {
+	// WritePath is the way the transactions are written to the database, WritePathStatements is used if empty.
+	WritePath string
+	// Batch is the group commit of the transactions, the batching is disabled by default.
+	Batch BatchConfig
+	// Observer receives the metrics of the ledger, it is optional.
+	Observer Observer
+}
+
+func (c Config) validate() error {
+	if c.WritePath != WritePathStatements && c.WritePath != WritePathFunction {
+		return fmt.Errorf("write path must be either %s or %s", WritePathStatements, WritePathFunction)
+	}
+	return c.Batch.validate()
+}
+
 type Ledger struct {
-	pg *internal.Postgres
+	storage      internal.Storage
+	retryPolicy  retryPolicy
+	retryCounter *retryCounter
+	// singleRoundTrip is true when the storage validates the transaction while creating it, so the ledger doesn't need to
+	// check the balances and the idempotency key before creating the transaction.
+	singleRoundTrip bool
+	// batcher groups the transactions into one database transaction, it is nil if the batching is disabled.
+	batcher  *batcher
+	observer Observer
 }
 
 // New creates new ledger object to interact with ledger service.
-func New(db *sql.DB) *Ledger {
+func New(db *sql.DB, config Config) (*Ledger, error) {
+	if config.WritePath == "" {
+		config.WritePath = WritePathStatements
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if config.Observer == nil {
+		config.Observer = noopObserver{}
+	}
+	writeFunction := config.WritePath == WritePathFunction
+	l := &Ledger{
+		storage: internal.NewPostgres(db, internal.PostgresConfig{
+			WriteFunction: writeFunction,
+			ObservePhase:  config.Observer.ObservePhase,
+		}),
+		retryPolicy:     defaultRetryPolicy,
+		retryCounter:    &retryCounter{},
+		singleRoundTrip: writeFunction,
+		observer:        config.Observer,
+	}
+	if config.Batch.enabled() {
+		l.batcher = newBatcher(config.Batch, l.storage)
+	}
+	return l, nil
+}
+
+// Stop creates the pending batch of transactions without waiting for the batch latency, and waits until all batches are
+// created. Stop must be called before the database is closed, the transactions after Stop are created without batching.
+func (l *Ledger) Stop() {
+	if l.batcher != nil {
+		l.batcher.stop()
+	}
+}
+
+// NewMemory creates new ledger object that stores everything in the memory. The ledger behaves the same with the ledger
+// that is backed by Postgres, but all the records are lost when the process stops, so it should only be used for testing.
+func NewMemory() *Ledger {
 	return &Ledger{
-		pg: internal.NewPostgres(db),
+		storage:      internal.NewMemory(),
+		retryPolicy:  defaultRetryPolicy,
+		retryCounter: &retryCounter{},
+		observer:     noopObserver{},
 	}
 }
 
 type Account struct {
 	ID                   string
 	AccountType          string
+	Currency             string
 	AllowNegativeBalance bool
-	CreatedAt            time.Time
-	UpdatedAt            time.Time
+	// OwnerID is the owner identity of the account, like the end user or the tenant that owns the account.
+	OwnerID   string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CreateAccount creates a new account in the given currency. The DefaultCurrency is used if the currency is empty, and the
+// currency of the account cannot be changed after the account is created. The account is owned by the owner of the
+// principal of the context, if any.
+func (l *Ledger) CreateAccount(ctx context.Context, accountID, accountType, currency string) (Account, error) {
+	return l.CreateOwnedAccount(ctx, "", accountID, accountType, currency)
 }
 
-func (l *Ledger) CreateAccount(ctx context.Context, accountID, accountType string) (Account, error) {
+// CreateOwnedAccount creates a new account like CreateAccount that is owned by the ownerID, the owner of the account
+// cannot be changed after the account is created. The principal with an owner can only create the accounts it owns, so
+// the ownerID must be either empty or the owner of the principal. The account has no owner if both are empty.
+func (l *Ledger) CreateOwnedAccount(ctx context.Context, ownerID, accountID, accountType, currency string) (Account, error) {
+	if owner := accountOwner(ctx); owner != "" {
+		if ownerID != "" && ownerID != owner {
+			return Account{}, fmt.Errorf("%w: cannot create an account for owner %s", ErrForbidden, ownerID)
+		}
+		ownerID = owner
+	}
+	if len(ownerID) > maxOwnerIDLength {
+		return Account{}, validationError(fmt.Errorf("owner_id cannot be longer than %d characters", maxOwnerIDLength))
+	}
 	var allowNegative bool
 	if accountType == "" {
 		accountType = AccountTypeUser
 	}
+	if accountType != AccountTypeUser && accountType != AccountTypeFunding {
+		return Account{}, validationError(fmt.Errorf("account type must be either %s or %s", AccountTypeUser, AccountTypeFunding))
+	}
+	currency = currencyOrDefault(currency)
+	if err := validateCurrency(currency); err != nil {
+		return Account{}, err
+	}
 	if accountType == AccountTypeFunding {
+		if !isFundingAdmin(ctx) {
+			return Account{}, fmt.Errorf("%w: creating a funding account requires scope %s", ErrForbidden, auth.ScopeFundingAdmin)
+		}
 		allowNegative = true
 	}
 
@@ -73,12 +228,14 @@ func (l *Ledger) CreateAccount(ctx context.Context, accountID, accountType strin
 		accountID = uuid.NewString()
 	} else if len(accountID) > 10 {
 		// For self creation, we forbid ID that longer than 10.
-		return Account{}, errors.New("self account creation cannot have more than 10 character")
+		return Account{}, validationError(errors.New("self account creation cannot have more than 10 character"))
 	}
 	createdAt := time.Now()
-	err := l.pg.CreateAccount(ctx, internal.Account{
+	err := l.storage.CreateAccount(ctx, internal.Account{
 		ID:                   accountID,
 		AccountType:          accountType,
+		Currency:             currency,
+		OwnerID:              sql.NullString{String: ownerID, Valid: ownerID != ""},
 		AllowNegativeBalance: allowNegative,
 		CreatedAt:            createdAt,
 	})
@@ -89,7 +246,9 @@ func (l *Ledger) CreateAccount(ctx context.Context, accountID, accountType strin
 	return Account{
 		ID:                   accountID,
 		AccountType:          accountType,
+		Currency:             currency,
 		AllowNegativeBalance: allowNegative,
+		OwnerID:              ownerID,
 		CreatedAt:            createdAt,
 		UpdatedAt:            createdAt,
 	}, nil
@@ -98,8 +257,14 @@ func (l *Ledger) CreateAccount(ctx context.Context, accountID, accountType strin
 // AccountBalance stores the information of the account balance. This representation is different from the database layer
 // as we might have some informations stripped or we want to use struct tag in the database layer.
 type AccountBalance struct {
-	AccountID         string
-	Balance           decimal.Decimal
+	AccountID string
+	Currency  string
+	// Balance is the posted balance of the account, it is the SUM of all ledger entries of the account.
+	Balance decimal.Decimal
+	// HeldBalance is the total amount of the active holds of the account.
+	HeldBalance decimal.Decimal
+	// AvailableBalance is the balance that can be used for a new transaction, it is Balance - HeldBalance.
+	AvailableBalance  decimal.Decimal
 	AllowNegative     bool
 	LastTransactionID string
 	CreatedAt         time.Time
@@ -108,7 +273,10 @@ type AccountBalance struct {
 
 // GetAccountBalance returns account balance by passing account_id.
 func (l *Ledger) GetAccountBalance(ctx context.Context, accountID string) (AccountBalance, error) {
-	balances, err := l.pg.GetAccountsBalance(ctx, accountID)
+	if err := l.checkAccountsAccess(ctx, accountID); err != nil {
+		return AccountBalance{}, err
+	}
+	balances, err := l.storage.GetAccountsBalance(ctx, accountID)
 	if err != nil {
 		return AccountBalance{}, err
 	}
@@ -117,7 +285,10 @@ func (l *Ledger) GetAccountBalance(ctx context.Context, accountID string) (Accou
 	}
 	return AccountBalance{
 		AccountID:         balances[0].AccountID,
+		Currency:          balances[0].Currency,
 		Balance:           balances[0].Balance,
+		HeldBalance:       balances[0].HeldBalance,
+		AvailableBalance:  balances[0].Balance.Sub(balances[0].HeldBalance),
 		AllowNegative:     balances[0].AllowNegative,
 		LastTransactionID: balances[0].LastTransactionID,
 		CreatedAt:         balances[0].CreatedAt,
@@ -126,21 +297,172 @@ func (l *Ledger) GetAccountBalance(ctx context.Context, accountID string) (Accou
 }
 
 type LedgerEntry struct {
-	TransactionID   string
-	AccountID       string
-	Amount          decimal.Decimal
-	CurrentBalance  decimal.Decimal
-	PreviousBalance decimal.Decimal
+	TransactionID string
+	AccountID     string
+	Amount        decimal.Decimal
+	Currency      string
+	// CurrentBalance and PreviousBalance are the balances of the account after and before the entry. Both are null for
+	// the entries of a sharded account, as the sub-balances are changed concurrently and the account doesn't have a
+	// running balance.
+	CurrentBalance  decimal.NullDecimal
+	PreviousBalance decimal.NullDecimal
 	CreatedAt       time.Time
 }
 
-func (l *Ledger) GetAccountLedgerEntries(ctx context.Context, accountID string) ([]LedgerEntry, error) {
-	entries, err := l.pg.GetLedgerByAccountID(ctx, accountID)
+// LedgerEntriesFilter filters and paginates the ledger entries of an account. All fields are optional.
+type LedgerEntriesFilter struct {
+	// Limit is the maximum number of entries in a page. DefaultPageLimit is used if the limit is zero.
+	Limit int
+	// Cursor is the NextCursor or PrevCursor from the previous page.
+	Cursor string
+	// FromTime is inclusive and ToTime is exclusive.
+	FromTime time.Time
+	ToTime   time.Time
+	// Direction is either DirectionDebit or DirectionCredit.
+	Direction string
+	// MinAmount and MaxAmount are inclusive and compared with the absolute amount of the entry.
+	MinAmount decimal.NullDecimal
+	MaxAmount decimal.NullDecimal
+}
+
+// LedgerEntriesPage is a page of the ledger entries of an account. The cursors are empty if there are no more
+// entries in that direction.
+type LedgerEntriesPage struct {
+	Entries    []LedgerEntry
+	NextCursor string
+	PrevCursor string
+}
+
+// GetAccountLedgerEntries returns a page of the ledger entries of the account ordered by time of the entry.
+func (l *Ledger) GetAccountLedgerEntries(ctx context.Context, accountID string, filter LedgerEntriesFilter) (LedgerEntriesPage, error) {
+	if filter.Limit == 0 {
+		filter.Limit = DefaultPageLimit
+	}
+	if filter.Limit < 0 || filter.Limit > MaxPageLimit {
+		return LedgerEntriesPage{}, validationError(fmt.Errorf("limit must be between 1 and %d", MaxPageLimit))
+	}
+	if filter.Direction != "" && filter.Direction != DirectionDebit && filter.Direction != DirectionCredit {
+		return LedgerEntriesPage{}, validationError(fmt.Errorf("direction must be either %s or %s", DirectionDebit, DirectionCredit))
+	}
+	if err := l.checkAccountsAccess(ctx, accountID); err != nil {
+		return LedgerEntriesPage{}, err
+	}
+
+	pgFilter := internal.LedgerFilter{
+		// Get one more entry than the limit to know whether there is another page.
+		Limit:     uint64(filter.Limit) + 1,
+		FromTime:  filter.FromTime,
+		ToTime:    filter.ToTime,
+		Direction: filter.Direction,
+		MinAmount: filter.MinAmount,
+		MaxAmount: filter.MaxAmount,
+	}
+	var cur cursor
+	if filter.Cursor != "" {
+		var err error
+		cur, err = decodeCursor(filter.Cursor)
+		if err != nil {
+			return LedgerEntriesPage{}, err
+		}
+		position := &internal.LedgerCursor{Timestamp: cur.Timestamp, TransactionID: cur.TransactionID}
+		if cur.Backward {
+			pgFilter.Before = position
+		} else {
+			pgFilter.After = position
+		}
+	}
+
+	entries, err := l.storage.GetLedgerByAccountID(ctx, accountID, pgFilter)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrAccountNotFound
+			return LedgerEntriesPage{}, ErrAccountNotFound
 		}
-		return nil, err
+		return LedgerEntriesPage{}, err
+	}
+
+	hasMore := len(entries) > filter.Limit
+	if hasMore {
+		if cur.Backward {
+			// The entries are walked backward, so the extra entry is the first entry.
+			entries = entries[1:]
+		} else {
+			entries = entries[:filter.Limit]
+		}
+	}
+
+	page := LedgerEntriesPage{
+		Entries: make([]LedgerEntry, len(entries)),
+	}
+	for idx, entry := range entries {
+		page.Entries[idx] = LedgerEntry{
+			TransactionID:   entry.TransactionID,
+			AccountID:       entry.AccountID,
+			Amount:          entry.Amount,
+			Currency:        entry.Currency,
+			CurrentBalance:  entry.CurrentBalance,
+			PreviousBalance: entry.PreviousBalance,
+			CreatedAt:       entry.CreatedAt,
+		}
+	}
+	if len(entries) == 0 {
+		return page, nil
+	}
+
+	first, last := entries[0], entries[len(entries)-1]
+	// There are more entries before the page if we are walking backward and have more entries, or if we are
+	// walking forward from a cursor.
+	if (cur.Backward && hasMore) || (!cur.Backward && filter.Cursor != "") {
+		page.PrevCursor = cursor{Timestamp: first.Timestamp, TransactionID: first.TransactionID, Backward: true}.encode()
+	}
+	// There are more entries after the page if we are walking forward and have more entries, or if we are
+	// walking backward from a cursor.
+	if (!cur.Backward && hasMore) || (cur.Backward && filter.Cursor != "") {
+		page.NextCursor = cursor{Timestamp: last.Timestamp, TransactionID: last.TransactionID}.encode()
+	}
+	return page, nil
+}
+
+// Transaction is the transaction record along with all of its ledger entries.
+type Transaction struct {
+	ID     string
+	Type   string
+	Amount decimal.Decimal
+	// Currency is the currency of the amount. For a transaction with legs in multiple currencies, it is the currency
+	// of the first leg.
+	Currency string
+	// ReversalOf is the original transaction_id if the transaction is a reversal.
+	ReversalOf string
+	// ReversedAmount is the total amount of the transaction that already reversed.
+	ReversedAmount decimal.Decimal
+	// FXRate and FXRateID are the executed rate of a conversion transaction, both are empty for other transactions.
+	FXRate   decimal.Decimal
+	FXRateID string
+	// CreatedBy is the id of the API key that created the transaction, it is empty for the transactions that are created
+	// by the service itself.
+	CreatedBy string
+	CreatedAt time.Time
+	Entries   []LedgerEntry
+}
+
+// GetTransaction returns the transaction and all of its ledger entries by passing transaction_id.
+func (l *Ledger) GetTransaction(ctx context.Context, transactionID string) (Transaction, error) {
+	tx, err := l.storage.GetTransaction(ctx, transactionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Transaction{}, ErrTransactionNotFound
+		}
+		return Transaction{}, err
+	}
+	entries, err := l.storage.GetLedgerByTransactionID(ctx, transactionID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	accountIDs := make([]string, len(entries))
+	for idx, entry := range entries {
+		accountIDs[idx] = entry.AccountID
+	}
+	if err := l.checkAnyAccountAccess(ctx, accountIDs...); err != nil {
+		return Transaction{}, err
 	}
 
 	le := make([]LedgerEntry, len(entries))
@@ -149,27 +471,63 @@ func (l *Ledger) GetAccountLedgerEntries(ctx context.Context, accountID string)
 			TransactionID:   entry.TransactionID,
 			AccountID:       entry.AccountID,
 			Amount:          entry.Amount,
+			Currency:        entry.Currency,
 			CurrentBalance:  entry.CurrentBalance,
 			PreviousBalance: entry.PreviousBalance,
 			CreatedAt:       entry.CreatedAt,
 		}
 	}
-	return le, nil
+	return Transaction{
+		ID:             tx.TransactionID,
+		Type:           tx.TransactionType,
+		Amount:         tx.Amount,
+		Currency:       tx.Currency,
+		ReversalOf:     tx.ReversalOf.String,
+		ReversedAmount: tx.ReversedAmount,
+		FXRate:         tx.FXRate.Decimal,
+		FXRateID:       tx.FXRateID.String,
+		CreatedBy:      tx.CreatedBy.String,
+		CreatedAt:      tx.CreatedAt,
+		Entries:        le,
+	}, nil
 }
 
-// checkBalances retrieves all accounts balance information and do checks on them. This function checks two things:
+// createTransaction creates the transaction in the storage, or in the next batch if the batching is enabled.
+func (l *Ledger) createTransaction(ctx context.Context, tx internal.CreateTransaction) error {
+	if l.batcher != nil {
+		return l.batcher.createTransaction(ctx, tx)
+	}
+	return l.storage.CreateTransaction(ctx, tx)
+}
+
+// checkBalances retrieves all accounts balance information and do checks on them. This function checks three things:
 // 1. Whether the account is already created or not.
-// 2. Whether the account that doing transaction have enough money or not.
-func (l *Ledger) checkBalances(ctx context.Context, summaries txSumaries) error {
+// 2. Whether the currency of the account is the same with the currency of the entries, if the currency is listed in currencies.
+// 3. Whether the account that doing transaction have enough money or not.
+func (l *Ledger) checkBalances(ctx context.Context, summaries txSumaries, currencies map[string]string, ledgerDebits map[string]bool) (err error) {
+	ctx, span := tracer.Start(ctx, "Ledger.checkBalances")
+	defer func() { endSpan(span, err) }()
+
 	accounts := summaries.accounts()
 	// GetAccountsBalance also acts as checking whether the account is present or not.
-	balances, err := l.pg.GetAccountsBalance(ctx, accounts...)
+	balances, err := l.storage.GetAccountsBalance(ctx, accounts...)
 	if err != nil {
 		return err
 	}
 	if len(balances) == 0 {
 		return ErrAllAccountsNotfound
 	}
+	if err := checkFundingDebits(ctx, summaries, balances, ledgerDebits); err != nil {
+		return err
+	}
+
+	// Check the currency of all accounts first, so the currency mismatch is always reported regardless of the order of
+	// the accounts in the summaries.
+	for _, balance := range balances {
+		if currency, ok := currencies[balance.AccountID]; ok && currency != balance.Currency {
+			return fmt.Errorf("%w: account_id %s is in %s, got %s", ErrCurrencyMismatch, balance.AccountID, balance.Currency, currency)
+		}
+	}
 
 	// We are finding the account_id inside of the list of accounts balance with loop inside loop O(n^2). This should
 	// be fine if n is small. And for this case, the n is small.
@@ -179,9 +537,9 @@ func (l *Ledger) checkBalances(ctx context.Context, summaries txSumaries) error
 			if accID == balance.AccountID {
 				found = true
 
-				// Check for the balance and if it goes negative, check whether the account can go below zero(0).
+				// Check for the available balance and if it goes negative, check whether the account can go below zero(0).
 				// We allow some accounts to go below 0, for example the account to fund user's money.
-				if sum.Add(balance.Balance).LessThan(decimal.Zero) && !balance.AllowNegative {
+				if sum.Add(balance.Balance).Sub(balance.HeldBalance).LessThan(decimal.Zero) && !balance.AllowNegative {
 					return fmt.Errorf("%w: account_id %s doesn't have enough balance for this transaction", ErrInsufficientBalance, accID)
 				}
 				// Break the loop as we already found the account_id.
@@ -189,41 +547,162 @@ func (l *Ledger) checkBalances(ctx context.Context, summaries txSumaries) error
 			}
 		}
 		if !found {
-			return fmt.Errorf("account_id %s not found", accID)
+			return fmt.Errorf("%w: account_id %s", ErrAccountNotFound, accID)
 		}
 	}
 	return nil
 }
 
-// buildTransaction creates a transaction for the database layer, and it validates the builder via validate function.
-// The function also checks whether the SUM of the ledger entries is 0. This is important because the final value of the
-// ledger should be zero(as we are doing double entry bookeeping).
+// buildTransaction creates a transaction for the database layer, and it validates the builder via Validate function.
+// The function also checks whether the SUM of the ledger entries is 0 for every currency in the transaction. This is important
+// because the final value of the ledger should be zero(as we are doing double entry bookeeping), and money in one currency
+// cannot be balanced by money in other currency.
 func buildTransaction(transactionID string, builder TransactionBuilder) (internal.CreateTransaction, error) {
-	if err := builder.validate(); err != nil {
-		return internal.CreateTransaction{}, err
+	if err := builder.Validate(); err != nil {
+		return internal.CreateTransaction{}, validationError(err)
+	}
+
+	entries := builder.Entries()
+	// A transaction needs at least one DEBIT and one CREDIT entry.
+	if len(entries) < 2 {
+		return internal.CreateTransaction{}, ErrInvalidLedgerEntriesLength
+	}
+
+	txType := TransactionTypePosting
+	if typer, ok := builder.(transactionTyper); ok {
+		txType = typer.transactionType()
 	}
 
-	tx := builder.buildTransaction(transactionID)
+	txTime := time.Now()
+	tx := internal.CreateTransaction{
+		TransactionID:   transactionID,
+		TransactionType: txType,
+		Amount:          decimal.Zero,
+		// The currency of the transaction is the currency of the first entry.
+		Currency:      currencyOrDefault(entries[0].Currency),
+		CreatedAt:     txTime,
+		LedgerEntries: make([]internal.Ledger, len(entries)),
+	}
 	// txSummaries is the total DEBIT/CREDIT of money per account. This information will be used
 	// later to pre-check the balance availability of the account.
 	txSummaries := make(txSumaries)
-
-	ledgerEntriesLen := len(tx.LedgerEntries)
-	if ledgerEntriesLen == 0 || ledgerEntriesLen%2 != 0 {
-		return internal.CreateTransaction{}, ErrInvalidLedgerEntriesLength
-	}
+	// currencies is the currency per account, an account can only have one entry in a transaction.
+	currencies := make(map[string]string)
 
 	// Validate the transaction as we need to check whether the total amount of transaction
 	// is the same with the amount of the ledger. At the end of the day, the ledger SUM amount
-	// should be 0.
-	sum := decimal.NewFromInt(0)
-	for _, ledger := range tx.LedgerEntries {
-		sum = sum.Add(ledger.Amount)
-		txSummaries[ledger.AccountID] = txSummaries[ledger.AccountID].Add(ledger.Amount)
+	// should be 0 for every currency.
+	sums := make(map[string]decimal.Decimal)
+	for idx, entry := range entries {
+		if entry.AccountID == "" {
+			return internal.CreateTransaction{}, validationError(errors.New("ledger entry account cannot be empty"))
+		}
+		if entry.Amount.IsZero() {
+			return internal.CreateTransaction{}, ErrZeroLedgerEntryAmount
+		}
+		currency := currencyOrDefault(entry.Currency)
+		if err := validateAmountScale(entry.Amount, currency); err != nil {
+			return internal.CreateTransaction{}, err
+		}
+		// The storage keeps one ledger entry per account in a transaction, so the account cannot be repeated.
+		if _, ok := currencies[entry.AccountID]; ok {
+			return internal.CreateTransaction{}, validationError(fmt.Errorf("account_id %s cannot have more than one entry in a transaction", entry.AccountID))
+		}
+		currencies[entry.AccountID] = currency
+
+		tx.LedgerEntries[idx] = internal.Ledger{
+			AccountID: entry.AccountID,
+			Amount:    entry.Amount,
+			Currency:  currency,
+			CreatedAt: txTime,
+		}
+		// The amount of the transaction is the total of all CREDIT entries in the currency of the transaction.
+		if entry.Amount.IsPositive() && currency == tx.Currency {
+			tx.Amount = tx.Amount.Add(entry.Amount)
+		}
+		sums[currency] = sums[currency].Add(entry.Amount)
+		txSummaries[entry.AccountID] = txSummaries[entry.AccountID].Add(entry.Amount)
 	}
-	if !sum.IsZero() {
-		return internal.CreateTransaction{}, ErrLedgerEntriesTotalNotZero
+	for _, sum := range sums {
+		if !sum.IsZero() {
+			return internal.CreateTransaction{}, ErrLedgerEntriesTotalNotZero
+		}
 	}
 	tx.Summaries = txSummaries
+	tx.Currencies = currencies
+	if rated, ok := builder.(fxRated); ok {
+		rate, rateID := rated.fxRate()
+		tx.FXRate = decimal.NewNullDecimal(rate)
+		tx.FXRateID = rateID
+	}
 	return tx, nil
 }
+
+// Post creates a new transaction from the builder and returns the transaction_id. All balances of the affected accounts are
+// changed atomically in one transaction. The idempotencyKey is optional, a replay with the same key returns the original
+// transaction_id instead of creating a new transaction.
+//
+// The transaction that is aborted because of a deadlock or a serialization failure is retried, ErrTransactionConflict is
+// returned if it still conflicts after all the retries.
+func (l *Ledger) Post(ctx context.Context, builder TransactionBuilder, idempotencyKey string) (string, error) {
+	return l.retryOnConflict(ctx, "posting", func() (string, error) {
+		return l.post(ctx, builder, idempotencyKey)
+	})
+}
+
+// post is a single attempt of Post.
+func (l *Ledger) post(ctx context.Context, builder TransactionBuilder, idempotencyKey string) (string, error) {
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return "", validationError(fmt.Errorf("idempotency key cannot be longer than %d characters", maxIdempotencyKeyLength))
+	}
+
+	txID := uuid.NewString()
+	tx, err := buildTransaction(txID, builder)
+	if err != nil {
+		return txID, err
+	}
+	if idempotencyKey != "" {
+		tx.IdempotencyKey = idempotencyKey
+		tx.RequestHash = builderRequestHash(builder, tx)
+	}
+	tx.CreatedBy = createdBy(ctx)
+	if err := checkFXLeg(ctx, builder); err != nil {
+		return txID, err
+	}
+	debits := ledgerDebits(builder, tx.Summaries)
+	if err := l.checkDebitsAccess(ctx, tx.Summaries, debits); err != nil {
+		return txID, err
+	}
+	// With the single round trip, the storage checks the idempotency key and the balances while creating the transaction.
+	// A replay is then detected by the ErrDuplicateIdempotencyKey below.
+	if !l.singleRoundTrip {
+		if tx.IdempotencyKey != "" {
+			// Return the previous transaction if the request is a replay. This needs to be done before checking the balances,
+			// because the balances might already changed by the previous request.
+			prevTxID, found, err := l.idempotentTransaction(ctx, tx.IdempotencyKey, tx.RequestHash)
+			if found || err != nil {
+				return prevTxID, err
+			}
+		}
+		if err := l.checkBalances(ctx, tx.Summaries, tx.Currencies, debits); err != nil {
+			return txID, err
+		}
+	} else if err := l.authorizeFundingDebits(ctx, tx.Summaries, debits); err != nil {
+		return txID, err
+	}
+	err = l.createTransaction(ctx, tx)
+	if errors.Is(err, internal.ErrDuplicateIdempotencyKey) {
+		// Other request with the same key is committed first, so we return the result of that request.
+		prevTxID, found, err := l.idempotentTransaction(ctx, tx.IdempotencyKey, tx.RequestHash)
+		if err != nil {
+			return prevTxID, err
+		}
+		// The request that took the key is not visible, for example it is rolled back with its batch, so the transaction
+		// is retried as a conflict instead of returning an empty transaction_id.
+		if !found {
+			return txID, fmt.Errorf("%w: idempotency key %s is not committed", ErrTransactionConflict, tx.IdempotencyKey)
+		}
+		return prevTxID, nil
+	}
+	return txID, err
+}
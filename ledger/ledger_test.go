@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"testing"
-	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -30,25 +29,216 @@ func TestBuildTransaction(t *testing.T) {
 				Amount:      createDecimalFromString("10"),
 			},
 			expectTx: internal.CreateTransaction{
-				TransactionID: "tx-id-1",
-				Amount:        createDecimalFromString("10"),
+				TransactionID:   "tx-id-1",
+				TransactionType: TransactionTypeTransfer,
+				Amount:          createDecimalFromString("10"),
+				Currency:        DefaultCurrency,
 				LedgerEntries: []internal.Ledger{
 					{
 						AccountID: "acc-1",
 						Amount:    createDecimalFromString("-10"),
+						Currency:  DefaultCurrency,
 					},
 					{
 						AccountID: "acc-2",
 						Amount:    createDecimalFromString("10"),
+						Currency:  DefaultCurrency,
 					},
 				},
 				Summaries: map[string]decimal.Decimal{
 					"acc-1": createDecimalFromString("-10"),
 					"acc-2": createDecimalFromString("10"),
 				},
+				Currencies: map[string]string{
+					"acc-1": DefaultCurrency,
+					"acc-2": DefaultCurrency,
+				},
+			},
+			err: nil,
+		},
+		{
+			name:          "multi-leg posting transaction",
+			transactionID: "tx-id-2",
+			builder: Posting{
+				Debits: []Entry{
+					{AccountID: "acc-1", Amount: createDecimalFromString("100")},
+				},
+				Credits: []Entry{
+					{AccountID: "merchant", Amount: createDecimalFromString("88")},
+					{AccountID: "fee", Amount: createDecimalFromString("2")},
+					{AccountID: "tax", Amount: createDecimalFromString("10")},
+				},
+			},
+			expectTx: internal.CreateTransaction{
+				TransactionID:   "tx-id-2",
+				TransactionType: TransactionTypePosting,
+				Amount:          createDecimalFromString("100"),
+				Currency:        DefaultCurrency,
+				LedgerEntries: []internal.Ledger{
+					{
+						AccountID: "acc-1",
+						Amount:    createDecimalFromString("-100"),
+						Currency:  DefaultCurrency,
+					},
+					{
+						AccountID: "merchant",
+						Amount:    createDecimalFromString("88"),
+						Currency:  DefaultCurrency,
+					},
+					{
+						AccountID: "fee",
+						Amount:    createDecimalFromString("2"),
+						Currency:  DefaultCurrency,
+					},
+					{
+						AccountID: "tax",
+						Amount:    createDecimalFromString("10"),
+						Currency:  DefaultCurrency,
+					},
+				},
+				Summaries: map[string]decimal.Decimal{
+					"acc-1":    createDecimalFromString("-100"),
+					"merchant": createDecimalFromString("88"),
+					"fee":      createDecimalFromString("2"),
+					"tax":      createDecimalFromString("10"),
+				},
+				Currencies: map[string]string{
+					"acc-1":    DefaultCurrency,
+					"merchant": DefaultCurrency,
+					"fee":      DefaultCurrency,
+					"tax":      DefaultCurrency,
+				},
 			},
 			err: nil,
 		},
+		{
+			name:          "unbalanced posting",
+			transactionID: "tx-id-3",
+			builder: Posting{
+				Debits: []Entry{
+					{AccountID: "acc-1", Amount: createDecimalFromString("100")},
+				},
+				Credits: []Entry{
+					{AccountID: "merchant", Amount: createDecimalFromString("88")},
+					{AccountID: "fee", Amount: createDecimalFromString("2")},
+				},
+			},
+			err: ErrLedgerEntriesTotalNotZero,
+		},
+		{
+			name:          "cross-currency transfer with fx leg",
+			transactionID: "tx-id-4",
+			builder: Transfer{
+				FromAccount: "acc-usd",
+				ToAccount:   "acc-idr",
+				Amount:      createDecimalFromString("10"),
+				Currency:    "USD",
+				FX: &FXLeg{
+					FromPosition: FXPositionAccountID("USD"),
+					ToPosition:   FXPositionAccountID("IDR"),
+					Amount:       createDecimalFromString("155000"),
+					Currency:     "IDR",
+				},
+			},
+			expectTx: internal.CreateTransaction{
+				TransactionID:   "tx-id-4",
+				TransactionType: TransactionTypeTransfer,
+				Amount:          createDecimalFromString("10"),
+				Currency:        "USD",
+				LedgerEntries: []internal.Ledger{
+					{AccountID: "acc-usd", Amount: createDecimalFromString("-10"), Currency: "USD"},
+					{AccountID: FXPositionAccountID("USD"), Amount: createDecimalFromString("10"), Currency: "USD"},
+					{AccountID: FXPositionAccountID("IDR"), Amount: createDecimalFromString("-155000"), Currency: "IDR"},
+					{AccountID: "acc-idr", Amount: createDecimalFromString("155000"), Currency: "IDR"},
+				},
+				Summaries: map[string]decimal.Decimal{
+					"acc-usd":                  createDecimalFromString("-10"),
+					FXPositionAccountID("USD"): createDecimalFromString("10"),
+					FXPositionAccountID("IDR"): createDecimalFromString("-155000"),
+					"acc-idr":                  createDecimalFromString("155000"),
+				},
+				Currencies: map[string]string{
+					"acc-usd":                  "USD",
+					FXPositionAccountID("USD"): "USD",
+					FXPositionAccountID("IDR"): "IDR",
+					"acc-idr":                  "IDR",
+				},
+			},
+			err: nil,
+		},
+		{
+			name:          "posting balanced across currencies",
+			transactionID: "tx-id-5",
+			builder: Posting{
+				Debits: []Entry{
+					{AccountID: "acc-1", Amount: createDecimalFromString("100"), Currency: "USD"},
+				},
+				Credits: []Entry{
+					{AccountID: "acc-2", Amount: createDecimalFromString("100"), Currency: "SGD"},
+				},
+			},
+			err: ErrLedgerEntriesTotalNotZero,
+		},
+		{
+			name:          "posting with the same account in debit and credit",
+			transactionID: "tx-id-8",
+			builder: Posting{
+				Debits: []Entry{
+					{AccountID: "acc-1", Amount: createDecimalFromString("100")},
+				},
+				Credits: []Entry{
+					{AccountID: "acc-2", Amount: createDecimalFromString("60")},
+					{AccountID: "acc-1", Amount: createDecimalFromString("40")},
+				},
+			},
+			err: ErrValidationFailed,
+		},
+		{
+			name:          "posting with the same account in two credits",
+			transactionID: "tx-id-9",
+			builder: Posting{
+				Debits: []Entry{
+					{AccountID: "acc-1", Amount: createDecimalFromString("100")},
+				},
+				Credits: []Entry{
+					{AccountID: "acc-2", Amount: createDecimalFromString("60")},
+					{AccountID: "acc-2", Amount: createDecimalFromString("40")},
+				},
+			},
+			err: ErrValidationFailed,
+		},
+		{
+			name:          "transfer to the same account",
+			transactionID: "tx-id-10",
+			builder: Transfer{
+				FromAccount: "acc-1",
+				ToAccount:   "acc-1",
+				Amount:      createDecimalFromString("10"),
+			},
+			err: ErrValidationFailed,
+		},
+		{
+			name:          "amount exceeds the minor unit of the currency",
+			transactionID: "tx-id-6",
+			builder: Transfer{
+				FromAccount: "acc-1",
+				ToAccount:   "acc-2",
+				Amount:      createDecimalFromString("10.5"),
+				Currency:    "JPY",
+			},
+			err: ErrInvalidAmountScale,
+		},
+		{
+			name:          "unsupported currency",
+			transactionID: "tx-id-7",
+			builder: Transfer{
+				FromAccount: "acc-1",
+				ToAccount:   "acc-2",
+				Amount:      createDecimalFromString("10"),
+				Currency:    "XXX",
+			},
+			err: ErrUnsupportedCurrency,
+		},
 		{
 			name:          "invalid ledger entries",
 			transactionID: "tx-id-1",
@@ -75,7 +265,7 @@ func TestBuildTransaction(t *testing.T) {
 		tt := test
 		t.Run(test.name, func(t *testing.T) {
 			tx, err := buildTransaction(tt.transactionID, tt.builder)
-			if err != tt.err {
+			if !errors.Is(err, tt.err) {
 				t.Fatalf("expecting error %v but got %v", tt.err, err)
 			}
 
@@ -91,20 +281,17 @@ func TestBuildTransaction(t *testing.T) {
 }
 
 func TestCheckBalance(t *testing.T) {
-	t.Cleanup(func() {
-		internal.TruncateTables(
-			t, testLedger.pg,
-			"accounts", "accounts_balance", "transaction", "accounts_ledger",
-		)
-	})
+	t.Parallel()
+	testLedger := NewTest(t)
+
 	fundingAccount := createFundingAccount(t, testLedger)
 
 	t.Run("both have sufficient balance", func(t *testing.T) {
-		acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser)
+		acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
 		if err != nil {
 			t.Fatal(err)
 		}
-		acc2, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser)
+		acc2, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -126,7 +313,7 @@ func TestCheckBalance(t *testing.T) {
 		if err := testLedger.checkBalances(context.Background(), map[string]decimal.Decimal{
 			acc1.ID: createDecimalFromString("-100"),
 			acc2.ID: createDecimalFromString("100"),
-		}); err != nil {
+		}, nil, nil); err != nil {
 			t.Fatal(err)
 		}
 	})
@@ -135,18 +322,18 @@ func TestCheckBalance(t *testing.T) {
 		err := testLedger.checkBalances(context.Background(), map[string]decimal.Decimal{
 			"one": decimal.Zero,
 			"two": decimal.Zero,
-		})
+		}, nil, nil)
 		if err != ErrAllAccountsNotfound {
 			t.Fatalf("expecing error %v but got %v", ErrAllAccountsNotfound, err)
 		}
 	})
 
 	t.Run("insufficient balance", func(t *testing.T) {
-		acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser)
+		acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
 		if err != nil {
 			t.Fatal(err)
 		}
-		acc2, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser)
+		acc2, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -168,10 +355,31 @@ func TestCheckBalance(t *testing.T) {
 		if err := testLedger.checkBalances(context.Background(), map[string]decimal.Decimal{
 			acc1.ID: createDecimalFromString("-200"),
 			acc2.ID: createDecimalFromString("100"),
-		}); !errors.Is(err, ErrInsufficientBalance) {
+		}, nil, nil); !errors.Is(err, ErrInsufficientBalance) {
 			t.Fatalf("expecting error %v but got %v", ErrInsufficientBalance, err)
 		}
 	})
+
+	t.Run("currency mismatch", func(t *testing.T) {
+		acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acc2, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Transferring IDR into the USD account is rejected without an FX leg.
+		_, err = testLedger.Transfer(context.Background(), Transfer{
+			FromAccount: acc1.ID,
+			ToAccount:   acc2.ID,
+			Amount:      createDecimalFromString("100"),
+		})
+		if !errors.Is(err, ErrCurrencyMismatch) {
+			t.Fatalf("expecting error %v but got %v", ErrCurrencyMismatch, err)
+		}
+	})
 }
 
 func createDecimalFromString(amount string) decimal.Decimal {
@@ -188,22 +396,17 @@ type invalidLedgerEntries struct {
 	Amount      decimal.Decimal
 }
 
-func (i invalidLedgerEntries) validate() error { return nil }
+func (i invalidLedgerEntries) Validate() error { return nil }
 
-func (i invalidLedgerEntries) buildTransaction(transactionID string) internal.CreateTransaction {
-	return internal.CreateTransaction{
-		TransactionID: transactionID,
-		Amount:        i.Amount,
-		CreatedAt:     time.Now(),
-		LedgerEntries: []internal.Ledger{
-			{
-				AccountID: i.FromAccount,
-				Amount:    i.Amount,
-			},
-			{
-				AccountID: i.ToAccount,
-				Amount:    i.Amount,
-			},
+func (i invalidLedgerEntries) Entries() []Entry {
+	return []Entry{
+		{
+			AccountID: i.FromAccount,
+			Amount:    i.Amount,
+		},
+		{
+			AccountID: i.ToAccount,
+			Amount:    i.Amount,
 		},
 	}
 }
@@ -214,18 +417,13 @@ type invalidLedgerEntriesLength struct {
 	Amount      decimal.Decimal
 }
 
-func (i invalidLedgerEntriesLength) validate() error { return nil }
+func (i invalidLedgerEntriesLength) Validate() error { return nil }
 
-func (i invalidLedgerEntriesLength) buildTransaction(transactionID string) internal.CreateTransaction {
-	return internal.CreateTransaction{
-		TransactionID: transactionID,
-		Amount:        i.Amount,
-		CreatedAt:     time.Now(),
-		LedgerEntries: []internal.Ledger{
-			{
-				AccountID: i.FromAccount,
-				Amount:    i.Amount,
-			},
+func (i invalidLedgerEntriesLength) Entries() []Entry {
+	return []Entry{
+		{
+			AccountID: i.FromAccount,
+			Amount:    i.Amount,
 		},
 	}
 }
@@ -0,0 +1,81 @@
+package ledger
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/albertwidi/ftest/ledger/internal"
+)
+
+// tracer creates the spans of the ledger. The spans are only exported if a tracer provider is set with otel.SetTracerProvider.
+var tracer = otel.Tracer("github.com/albertwidi/ftest/ledger")
+
+// The outcomes of a transfer that are reported to Observer.ObserveTransfer.
+const (
+	TransferOutcomeOK                  = "ok"
+	TransferOutcomeInsufficientBalance = "insufficient_balance"
+	TransferOutcomeNotFound            = "not_found"
+	// TransferOutcomeDeadlockRetry is reported for every attempt of a transfer that is aborted because of a deadlock or a
+	// serialization failure. The transfer is retried, so the final outcome of the transfer is reported separately.
+	TransferOutcomeDeadlockRetry = "deadlock_retry"
+	// TransferOutcomeConflict is reported when the transfer still conflicts after all the retries.
+	TransferOutcomeConflict = "conflict"
+	// TransferOutcomeRejected is reported for all other errors that are caused by the request.
+	TransferOutcomeRejected = "rejected"
+	// TransferOutcomeError is reported for the internal errors.
+	TransferOutcomeError = "error"
+)
+
+// The phases of creating a transaction that are reported to Observer.ObservePhase.
+const (
+	PhaseIdempotency = internal.PhaseIdempotency
+	PhaseLock        = internal.PhaseLock
+	PhaseWrite       = internal.PhaseWrite
+	PhaseCommit      = internal.PhaseCommit
+	PhaseFunction    = internal.PhaseFunction
+)
+
+// Observer receives the metrics of the ledger. The ledger doesn't depend on any metrics library, so the service can
+// implement the Observer with the library of its choice.
+type Observer interface {
+	// ObserveTransfer is called with the outcome of every transfer.
+	ObserveTransfer(outcome string)
+	// ObservePhase is called with the duration of every phase of creating a transaction in the database. Only the Postgres
+	// storage reports the phases.
+	ObservePhase(phase string, duration time.Duration)
+}
+
+type noopObserver struct{}
+
+func (noopObserver) ObserveTransfer(string)             {}
+func (noopObserver) ObservePhase(string, time.Duration) {}
+
+// transferOutcome returns the outcome of the transfer based on the error code.
+func transferOutcome(err error) string {
+	if err == nil {
+		return TransferOutcomeOK
+	}
+	switch ErrorCode(err) {
+	case CodeInsufficientBalance:
+		return TransferOutcomeInsufficientBalance
+	case CodeAccountNotFound:
+		return TransferOutcomeNotFound
+	case CodeTransactionConflict:
+		return TransferOutcomeConflict
+	case CodeInternal, CodeTimeout:
+		return TransferOutcomeError
+	}
+	return TransferOutcomeRejected
+}
+
+// endSpan ends the span and marks the span as failed if there is an error.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
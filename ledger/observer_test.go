@@ -0,0 +1,136 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/albertwidi/ftest/ledger/internal"
+)
+
+type observerRecorder struct {
+	mu       sync.Mutex
+	outcomes []string
+}
+
+func (r *observerRecorder) ObserveTransfer(outcome string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outcomes = append(r.outcomes, outcome)
+}
+
+func (r *observerRecorder) ObservePhase(string, time.Duration) {}
+
+// conflictOnce aborts the first transaction with ErrTransactionConflict, like a deadlock in Postgres.
+type conflictOnce struct {
+	internal.Storage
+	once sync.Once
+}
+
+func (c *conflictOnce) CreateTransaction(ctx context.Context, tx internal.CreateTransaction) error {
+	var err error
+	c.once.Do(func() {
+		err = fmt.Errorf("%w: deadlock detected", ErrTransactionConflict)
+	})
+	if err != nil {
+		return err
+	}
+	return c.Storage.CreateTransaction(ctx, tx)
+}
+
+func TestObserveTransfer(t *testing.T) {
+	t.Parallel()
+
+	testLedger := NewTest(t)
+	recorder := &observerRecorder{}
+	testLedger.observer = recorder
+	testLedger.retryPolicy = retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testLedger.storage = &conflictOnce{Storage: testLedger.storage}
+
+	transfers := []Transfer{
+		// The first attempt is aborted by the conflict, and the transfer succeeds after the retry.
+		{FromAccount: fundingAccount.ID, ToAccount: acc.ID, Amount: createDecimalFromString("10")},
+		{FromAccount: acc.ID, ToAccount: fundingAccount.ID, Amount: createDecimalFromString("11")},
+		{FromAccount: acc.ID, ToAccount: "unknown", Amount: createDecimalFromString("1")},
+		{ToAccount: fundingAccount.ID, Amount: createDecimalFromString("1")},
+	}
+	for _, transfer := range transfers {
+		testLedger.Transfer(context.Background(), transfer)
+	}
+
+	expect := []string{
+		TransferOutcomeDeadlockRetry,
+		TransferOutcomeOK,
+		TransferOutcomeInsufficientBalance,
+		TransferOutcomeNotFound,
+		TransferOutcomeRejected,
+	}
+	if diff := cmp.Diff(expect, recorder.outcomes); diff != "" {
+		t.Fatalf("(-want/+got) outcomes:\n%s", diff)
+	}
+}
+
+// TestTransferSpans tests whether the spans of the transfer are created under the span of the caller.
+func TestTransferSpans(t *testing.T) {
+	t.Parallel()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+
+	testLedger := NewTest(t)
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, root := provider.Tracer("test").Start(context.Background(), "request")
+	_, err = testLedger.Transfer(ctx, Transfer{FromAccount: acc.ID, ToAccount: fundingAccount.ID, Amount: createDecimalFromString("1")})
+	root.End()
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expecting error %v but got %v", ErrInsufficientBalance, err)
+	}
+
+	// The other tests might create spans at the same time, so only the spans of this trace are checked.
+	parents := make(map[string]string)
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			continue
+		}
+		spans[span.Name()] = span
+		parents[span.Name()] = ""
+		for _, parent := range recorder.Ended() {
+			if parent.SpanContext().SpanID() == span.Parent().SpanID() {
+				parents[span.Name()] = parent.Name()
+			}
+		}
+	}
+	expect := map[string]string{
+		"request":              "",
+		"Ledger.Transfer":      "request",
+		"Ledger.checkBalances": "Ledger.Transfer",
+	}
+	if diff := cmp.Diff(expect, parents); diff != "" {
+		t.Fatalf("(-want/+got) parents:\n%s", diff)
+	}
+	if status := spans["Ledger.Transfer"].Status().Code; status != codes.Error {
+		t.Fatalf("expecting error status but got %v", status)
+	}
+}
@@ -0,0 +1,74 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxPostingLegs is the maximum number of DEBIT and CREDIT legs in a single posting. We are locking all accounts in the
+// posting inside one database transaction, so we don't want the list to be unbounded.
+const maxPostingLegs = 50
+
+// Posting is a multi-leg transaction where money from multiple accounts(DEBIT) is moved to multiple accounts(CREDIT) in one
+// atomic transaction. For example, a payout that is split to the merchant, platform fee and tax accounts.
+//
+// The legs are entries with positive amount, the direction of the money is decided by whether the leg is in the Debits or
+// in the Credits. An account can only have one leg in a posting. The currency of the posting is used for the legs without
+// currency. The total amount of the DEBIT legs must be the same with the total amount of the CREDIT legs for every
+// currency.
+type Posting struct {
+	// Currency is the currency of the legs, DefaultCurrency is used if the currency is empty.
+	Currency string
+	Debits   []Entry
+	Credits  []Entry
+}
+
+func (p Posting) Validate() error {
+	if len(p.Debits) == 0 {
+		return errors.New("posting must have at least one debit")
+	}
+	if len(p.Credits) == 0 {
+		return errors.New("posting must have at least one credit")
+	}
+	if len(p.Debits)+len(p.Credits) > maxPostingLegs {
+		return fmt.Errorf("posting cannot have more than %d legs", maxPostingLegs)
+	}
+	for _, legs := range [][]Entry{p.Debits, p.Credits} {
+		for _, leg := range legs {
+			if leg.AccountID == "" {
+				return errors.New("leg account cannot be empty")
+			}
+			if !leg.Amount.IsPositive() {
+				return fmt.Errorf("leg amount for account %s must be positive", leg.AccountID)
+			}
+		}
+	}
+	return nil
+}
+
+func (p Posting) Entries() []Entry {
+	entries := make([]Entry, 0, len(p.Debits)+len(p.Credits))
+	for _, debit := range p.Debits {
+		entries = append(entries, Entry{
+			AccountID: debit.AccountID,
+			Amount:    debit.Amount.Neg(),
+			Currency:  p.legCurrency(debit),
+		})
+	}
+	for _, credit := range p.Credits {
+		entries = append(entries, Entry{
+			AccountID: credit.AccountID,
+			Amount:    credit.Amount,
+			Currency:  p.legCurrency(credit),
+		})
+	}
+	return entries
+}
+
+// legCurrency returns the currency of the leg, or the currency of the posting if the leg doesn't have its own currency.
+func (p Posting) legCurrency(leg Entry) string {
+	if leg.Currency != "" {
+		return leg.Currency
+	}
+	return p.Currency
+}
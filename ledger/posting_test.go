@@ -0,0 +1,92 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestPosting tests a multi-leg posting where the money from one account is split into multiple accounts.
+func TestPosting(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	payer, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merchant, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tax, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: fundingAccount.ID,
+		ToAccount:   payer.ID,
+		Amount:      createDecimalFromString("100"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posting := Posting{
+		Debits: []Entry{
+			{AccountID: payer.ID, Amount: createDecimalFromString("100")},
+		},
+		Credits: []Entry{
+			{AccountID: merchant.ID, Amount: createDecimalFromString("88.5")},
+			{AccountID: fee.ID, Amount: createDecimalFromString("1.5")},
+			{AccountID: tax.ID, Amount: createDecimalFromString("10")},
+		},
+	}
+	if _, err := testLedger.Post(context.Background(), posting, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	expectBalances := map[string]string{
+		payer.ID:    "0",
+		merchant.ID: "88.5",
+		fee.ID:      "1.5",
+		tax.ID:      "10",
+	}
+	for accountID, expect := range expectBalances {
+		balance, err := testLedger.GetAccountBalance(context.Background(), accountID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance.Balance.Cmp(createDecimalFromString(expect)) != 0 {
+			t.Fatalf("%s: expecting balance %s but got %s", accountID, expect, balance.Balance.String())
+		}
+	}
+
+	// The payer doesn't have any money left, so the second posting should fail and no balance is changed.
+	_, err = testLedger.Post(context.Background(), posting, "")
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expecting error %v but got %v", ErrInsufficientBalance, err)
+	}
+
+	// An account can only have one leg in a posting, the repeated account is rejected before the posting is created.
+	_, err = testLedger.Post(context.Background(), Posting{
+		Debits: []Entry{
+			{AccountID: merchant.ID, Amount: createDecimalFromString("10")},
+		},
+		Credits: []Entry{
+			{AccountID: fee.ID, Amount: createDecimalFromString("5")},
+			{AccountID: fee.ID, Amount: createDecimalFromString("5")},
+		},
+	}, "")
+	if !errors.Is(err, ErrValidationFailed) {
+		t.Fatalf("expecting error %v but got %v", ErrValidationFailed, err)
+	}
+	checkAvailableAndPosted(t, testLedger, merchant.ID, "88.5", "88.5")
+	checkAvailableAndPosted(t, testLedger, fee.ID, "1.5", "1.5")
+}
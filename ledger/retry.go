@@ -0,0 +1,95 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/albertwidi/ftest/ledger/internal"
+	"github.com/albertwidi/ftest/logging"
+)
+
+// ErrTransactionConflict is returned when the transaction is aborted by the database because of a deadlock or a
+// serialization failure, and it still conflicts after all the retries.
+var ErrTransactionConflict = internal.ErrTransactionConflict
+
+// retryPolicy is the bounded exponential backoff with full jitter used to retry the conflicted transaction. The delay of
+// the n-th retry is a random duration between zero and min(maxDelay, baseDelay*2^n), so the conflicted transactions don't
+// retry at the same time and conflict again.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	maxAttempts: 5,
+	baseDelay:   5 * time.Millisecond,
+	maxDelay:    100 * time.Millisecond,
+}
+
+func (p retryPolicy) backoff(retry int) time.Duration {
+	delay := p.maxDelay
+	if retry < 32 {
+		if d := p.baseDelay << retry; d > 0 && d < p.maxDelay {
+			delay = d
+		}
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// RetryStats is the number of the conflicted transactions since the ledger is created.
+type RetryStats struct {
+	// Retries is the number of retries because of the transaction conflict.
+	Retries int64
+	// Exhausted is the number of operations that still conflict after all the retries.
+	Exhausted int64
+}
+
+type retryCounter struct {
+	retries   atomic.Int64
+	exhausted atomic.Int64
+}
+
+// RetryStats returns the number of retries because of the deadlock or the serialization failure.
+func (l *Ledger) RetryStats() RetryStats {
+	return RetryStats{
+		Retries:   l.retryCounter.retries.Load(),
+		Exhausted: l.retryCounter.exhausted.Load(),
+	}
+}
+
+// retryOnConflict calls fn until it succeeds, returns other error than ErrTransactionConflict, or the attempts of the
+// policy are exhausted. The retry stops when the context is done, and the last error is returned along with the error
+// of the context.
+func (l *Ledger) retryOnConflict(ctx context.Context, operation string, fn func() (string, error)) (string, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := fn()
+		if err == nil || !errors.Is(err, ErrTransactionConflict) {
+			if attempt > 1 {
+				logging.FromContext(ctx).Info("transaction succeeded after retry", "operation", operation, "attempts", attempt, "error", err)
+			}
+			return result, err
+		}
+		if attempt >= l.retryPolicy.maxAttempts {
+			l.retryCounter.exhausted.Add(1)
+			logging.FromContext(ctx).Error("transaction conflict retries exhausted", "operation", operation, "attempts", attempt, "error", err)
+			// The database error is only logged, so the detail of the database is not returned to the client.
+			return result, fmt.Errorf("%w: %s still conflicts after %d attempts", ErrTransactionConflict, operation, attempt)
+		}
+
+		l.retryCounter.retries.Add(1)
+		delay := l.retryPolicy.backoff(attempt - 1)
+		logging.FromContext(ctx).Warn("retrying conflicted transaction", "operation", operation, "attempt", attempt, "delay", delay, "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
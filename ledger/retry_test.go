@@ -0,0 +1,245 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/albertwidi/ftest/ledger/internal"
+	"github.com/shopspring/decimal"
+)
+
+func TestRetryOnConflict(t *testing.T) {
+	t.Parallel()
+
+	conflict := fmt.Errorf("%w: deadlock detected", ErrTransactionConflict)
+	tests := []struct {
+		name          string
+		errs          []error
+		expectErr     error
+		expectCalls   int
+		expectRetries int64
+	}{
+		{
+			name:          "succeed without retry",
+			errs:          []error{nil},
+			expectCalls:   1,
+			expectRetries: 0,
+		},
+		{
+			name:          "succeed after retries",
+			errs:          []error{conflict, conflict, nil},
+			expectCalls:   3,
+			expectRetries: 2,
+		},
+		{
+			name:          "other error is not retried",
+			errs:          []error{ErrInsufficientBalance},
+			expectErr:     ErrInsufficientBalance,
+			expectCalls:   1,
+			expectRetries: 0,
+		},
+		{
+			name:          "retries exhausted",
+			errs:          []error{conflict, conflict, conflict, conflict},
+			expectErr:     ErrTransactionConflict,
+			expectCalls:   3,
+			expectRetries: 2,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			testLedger := NewMemory()
+			testLedger.retryPolicy = retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: 2 * time.Millisecond}
+			calls := 0
+			_, err := testLedger.retryOnConflict(context.Background(), "test", func() (string, error) {
+				err := test.errs[calls]
+				calls++
+				return "", err
+			})
+			if !errors.Is(err, test.expectErr) {
+				t.Fatalf("expecting error %v but got %v", test.expectErr, err)
+			}
+			if calls != test.expectCalls {
+				t.Fatalf("expecting %d calls but got %d", test.expectCalls, calls)
+			}
+			if stats := testLedger.RetryStats(); stats.Retries != test.expectRetries {
+				t.Fatalf("expecting %d retries but got %d", test.expectRetries, stats.Retries)
+			}
+		})
+	}
+}
+
+func TestRetryOnConflictContextDone(t *testing.T) {
+	t.Parallel()
+
+	testLedger := NewMemory()
+	testLedger.retryPolicy = retryPolicy{maxAttempts: 3, baseDelay: time.Hour, maxDelay: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := testLedger.retryOnConflict(ctx, "test", func() (string, error) {
+		return "", ErrTransactionConflict
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expecting deadline exceeded but got %v", err)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	t.Parallel()
+
+	policy := retryPolicy{maxAttempts: 5, baseDelay: time.Millisecond, maxDelay: 4 * time.Millisecond}
+	for retry := 0; retry < 64; retry++ {
+		if delay := policy.backoff(retry); delay < 0 || delay > policy.maxDelay {
+			t.Fatalf("retry %d: delay %s is out of [0, %s]", retry, delay, policy.maxDelay)
+		}
+	}
+}
+
+// conflictFirst aborts the first posting, reversal and capture with ErrTransactionConflict, like a deadlock in Postgres.
+type conflictFirst struct {
+	internal.Storage
+	create, reverse, capture sync.Once
+}
+
+func conflictOn(once *sync.Once) error {
+	var err error
+	once.Do(func() {
+		err = fmt.Errorf("%w: deadlock detected", ErrTransactionConflict)
+	})
+	return err
+}
+
+func (c *conflictFirst) CreateTransaction(ctx context.Context, tx internal.CreateTransaction) error {
+	if err := conflictOn(&c.create); err != nil {
+		return err
+	}
+	return c.Storage.CreateTransaction(ctx, tx)
+}
+
+func (c *conflictFirst) ReverseTransaction(ctx context.Context, originalTransactionID string, amount decimal.Decimal, tx internal.CreateTransaction) error {
+	if err := conflictOn(&c.reverse); err != nil {
+		return err
+	}
+	return c.Storage.ReverseTransaction(ctx, originalTransactionID, amount, tx)
+}
+
+func (c *conflictFirst) CaptureHold(ctx context.Context, holdID string, amount decimal.Decimal, tx internal.CreateTransaction) error {
+	if err := conflictOn(&c.capture); err != nil {
+		return err
+	}
+	return c.Storage.CaptureHold(ctx, holdID, amount, tx)
+}
+
+// TestRetryPostReverseCapture tests the posting, reversal and capture are retried on conflict like the transfer.
+func TestRetryPostReverseCapture(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+	testLedger.retryPolicy = retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	accounts := make([]Account, 3)
+	for i := range accounts {
+		acc, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts[i] = acc
+	}
+	_, err := testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: fundingAccount.ID,
+		ToAccount:   accounts[0].ID,
+		Amount:      createDecimalFromString("100"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold, err := testLedger.Authorize(context.Background(), Authorization{
+		FromAccount: accounts[0].ID,
+		ToAccount:   accounts[1].ID,
+		Amount:      createDecimalFromString("30"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testLedger.storage = &conflictFirst{Storage: testLedger.storage}
+
+	transactionID, err := testLedger.Post(context.Background(), Posting{
+		Debits:  []Entry{{AccountID: accounts[0].ID, Amount: createDecimalFromString("20")}},
+		Credits: []Entry{{AccountID: accounts[2].ID, Amount: createDecimalFromString("20")}},
+	}, "")
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if _, err := testLedger.Reverse(context.Background(), transactionID, decimal.Zero); err != nil {
+		t.Fatalf("reverse: %v", err)
+	}
+	if _, err := testLedger.Capture(context.Background(), hold.ID, decimal.Zero); err != nil {
+		t.Fatalf("capture: %v", err)
+	}
+
+	if stats := testLedger.RetryStats(); stats.Retries != 3 {
+		t.Fatalf("expecting 3 retries but got %d", stats.Retries)
+	}
+	checkAvailableAndPosted(t, testLedger, accounts[0].ID, "70", "70")
+	checkAvailableAndPosted(t, testLedger, accounts[1].ID, "30", "30")
+	checkAvailableAndPosted(t, testLedger, accounts[2].ID, "0", "0")
+}
+
+// rolledBackKey fails the first transaction with ErrDuplicateIdempotencyKey while the transaction that took the key is
+// never committed, like the transactions with the same key that are rolled back together with their batch.
+type rolledBackKey struct {
+	internal.Storage
+	once sync.Once
+}
+
+func (r *rolledBackKey) CreateTransaction(ctx context.Context, tx internal.CreateTransaction) error {
+	var err error
+	r.once.Do(func() {
+		err = internal.ErrDuplicateIdempotencyKey
+	})
+	if err != nil {
+		return err
+	}
+	return r.Storage.CreateTransaction(ctx, tx)
+}
+
+// TestRetryUncommittedIdempotencyKey tests the transaction is retried instead of returning an empty transaction_id when the
+// idempotency key is taken by a transaction that is not committed.
+func TestRetryUncommittedIdempotencyKey(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+	testLedger.retryPolicy = retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testLedger.storage = &rolledBackKey{Storage: testLedger.storage}
+
+	txID, err := testLedger.Transfer(context.Background(), Transfer{
+		FromAccount:    fundingAccount.ID,
+		ToAccount:      acc.ID,
+		Amount:         createDecimalFromString("100"),
+		IdempotencyKey: acc.ID + "-topup",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txID == "" {
+		t.Fatal("expecting transaction_id but got empty")
+	}
+	if stats := testLedger.RetryStats(); stats.Retries != 1 {
+		t.Fatalf("expecting 1 retry but got %d", stats.Retries)
+	}
+	checkAvailableAndPosted(t, testLedger, acc.ID, "100", "100")
+}
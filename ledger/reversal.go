@@ -0,0 +1,164 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/albertwidi/ftest/ledger/internal"
+)
+
+// reversal is the builder of the reversal transaction. The entries are the mirror of the original transaction entries.
+type reversal struct {
+	entries []Entry
+	// conversion is true if the original transaction is a conversion, the reversal debits the FX position account that
+	// is credited by the conversion.
+	conversion bool
+}
+
+func (r reversal) Validate() error { return nil }
+
+func (r reversal) Entries() []Entry { return r.entries }
+
+func (r reversal) debitsFXPositions() bool { return r.conversion }
+
+// Reverse reverses the transaction fully or partially and returns the transaction_id of the reversal. A zero amount
+// reverses all the remaining amount of the transaction. The reversal is linked to the original transaction, and the
+// cumulative amount of the reversals cannot exceed the amount of the original transaction.
+//
+// The reversal that is aborted because of a deadlock or a serialization failure is retried from the start, so the remaining
+// amount of the transaction is read again.
+func (l *Ledger) Reverse(ctx context.Context, transactionID string, amount decimal.Decimal) (string, error) {
+	return l.retryOnConflict(ctx, "reversal", func() (string, error) {
+		return l.reverse(ctx, transactionID, amount)
+	})
+}
+
+// reverse is a single attempt of Reverse.
+func (l *Ledger) reverse(ctx context.Context, transactionID string, amount decimal.Decimal) (string, error) {
+	if amount.IsNegative() {
+		return "", validationError(errors.New("reversal amount cannot be negative"))
+	}
+	original, err := l.storage.GetTransaction(ctx, transactionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrTransactionNotFound
+		}
+		return "", err
+	}
+	if original.ReversalOf.Valid {
+		return "", ErrReverseReversal
+	}
+	if err := validateAmountScale(amount, currencyOrDefault(original.Currency)); err != nil {
+		return "", validationError(err)
+	}
+	remaining := original.Amount.Sub(original.ReversedAmount)
+	if amount.IsZero() {
+		amount = remaining
+	}
+	if amount.IsZero() || amount.GreaterThan(remaining) {
+		return "", ErrReversalExceedsAmount
+	}
+
+	legs, err := l.storage.GetLedgerByTransactionID(ctx, transactionID)
+	if err != nil {
+		return "", err
+	}
+	if len(legs) == 0 {
+		return "", ErrTransactionNotFound
+	}
+
+	txID := uuid.NewString()
+	builder := reversal{
+		entries:    reversalEntries(legs, original.Amount, amount),
+		conversion: original.TransactionType == TransactionTypeConversion,
+	}
+	tx, err := buildTransaction(txID, builder)
+	if err != nil {
+		return txID, err
+	}
+	debits := ledgerDebits(builder, tx.Summaries)
+	tx.TransactionType = TransactionTypeReversal
+	tx.CreatedBy = createdBy(ctx)
+	if err := l.checkDebitsAccess(ctx, tx.Summaries, debits); err != nil {
+		return txID, err
+	}
+	if err := l.checkBalances(ctx, tx.Summaries, tx.Currencies, debits); err != nil {
+		return txID, err
+	}
+	// The cumulative amount of the reversals is checked again inside the database transaction, as the transaction might
+	// be reversed concurrently.
+	if err := l.storage.ReverseTransaction(ctx, transactionID, amount, tx); err != nil {
+		return txID, err
+	}
+	return txID, nil
+}
+
+// reversalEntries creates the mirrored entries of the original legs for the reversal amount. For partial reversal, every leg
+// is scaled by amount/total and rounded down to the minor unit of its currency, then the rounding remainder is added to the
+// biggest leg on each side so both DEBIT and CREDIT side are still balanced for every currency.
+func reversalEntries(legs []internal.Ledger, total, amount decimal.Decimal) []Entry {
+	// side is the DEBIT or CREDIT side of the reversal entries in a currency.
+	type side struct {
+		currency string
+		sign     int
+	}
+
+	entries := make([]Entry, 0, len(legs))
+	// originals, sums and biggest are keyed by the side of the reversal entry, so we can fix the remainder per side.
+	originals := make(map[side]decimal.Decimal)
+	sums := make(map[side]decimal.Decimal)
+	biggest := make(map[side]int)
+	for _, leg := range legs {
+		currency := currencyOrDefault(leg.Currency)
+		// Mirror the leg, DEBIT becomes CREDIT and CREDIT becomes DEBIT.
+		mirrored := leg.Amount.Neg()
+		key := side{currency: currency, sign: mirrored.Sign()}
+		originals[key] = originals[key].Add(mirrored)
+		if !amount.Equal(total) {
+			mirrored = mirrored.Mul(amount).Div(total).Truncate(minorUnit(currency, leg.Amount))
+		}
+
+		sums[key] = sums[key].Add(mirrored)
+		if idx, ok := biggest[key]; !ok || mirrored.Abs().GreaterThan(entries[idx].Amount.Abs()) {
+			biggest[key] = len(entries)
+		}
+		entries = append(entries, Entry{
+			AccountID: leg.AccountID,
+			Amount:    mirrored,
+			Currency:  currency,
+		})
+	}
+
+	// Add the rounding remainder so every side is equal to its original total scaled by amount/total. Both sides of a
+	// currency have the same original total, so they are still balanced after the remainder is added. For the currency of
+	// the transaction, the CREDIT side is equal to amount and the DEBIT side is equal to -amount.
+	for key, sum := range sums {
+		target := originals[key]
+		if !amount.Equal(total) {
+			target = target.Mul(amount).Div(total).Truncate(minorUnit(key.currency, target))
+		}
+		entries[biggest[key]].Amount = entries[biggest[key]].Amount.Add(target.Sub(sum))
+	}
+
+	// Remove the entries that become zero because of the rounding.
+	result := entries[:0]
+	for _, entry := range entries {
+		if !entry.Amount.IsZero() {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// minorUnit returns the number of decimal places of the currency for rounding. The precision of the amount is used if the
+// currency is unknown.
+func minorUnit(currency string, amount decimal.Decimal) int32 {
+	if places, ok := currencyMinorUnits[currency]; ok {
+		return places
+	}
+	return -amount.Exponent()
+}
@@ -0,0 +1,144 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shopspring/decimal"
+
+	"github.com/albertwidi/ftest/ledger/internal"
+)
+
+func TestReversalEntries(t *testing.T) {
+	tests := []struct {
+		name   string
+		legs   []internal.Ledger
+		total  decimal.Decimal
+		amount decimal.Decimal
+		expect []Entry
+	}{
+		{
+			name: "full reversal",
+			legs: []internal.Ledger{
+				{AccountID: "acc-1", Amount: createDecimalFromString("-100")},
+				{AccountID: "acc-2", Amount: createDecimalFromString("100")},
+			},
+			total:  createDecimalFromString("100"),
+			amount: createDecimalFromString("100"),
+			expect: []Entry{
+				{AccountID: "acc-1", Amount: createDecimalFromString("100"), Currency: DefaultCurrency},
+				{AccountID: "acc-2", Amount: createDecimalFromString("-100"), Currency: DefaultCurrency},
+			},
+		},
+		{
+			name: "partial reversal",
+			legs: []internal.Ledger{
+				{AccountID: "acc-1", Amount: createDecimalFromString("-100")},
+				{AccountID: "acc-2", Amount: createDecimalFromString("100")},
+			},
+			total:  createDecimalFromString("100"),
+			amount: createDecimalFromString("30.5"),
+			expect: []Entry{
+				{AccountID: "acc-1", Amount: createDecimalFromString("30.5"), Currency: DefaultCurrency},
+				{AccountID: "acc-2", Amount: createDecimalFromString("-30.5"), Currency: DefaultCurrency},
+			},
+		},
+		{
+			name: "partial reversal of multi-leg with remainder",
+			legs: []internal.Ledger{
+				{AccountID: "payer", Amount: createDecimalFromString("-100")},
+				{AccountID: "merchant", Amount: createDecimalFromString("70")},
+				{AccountID: "fee", Amount: createDecimalFromString("20")},
+				{AccountID: "tax", Amount: createDecimalFromString("10")},
+			},
+			total:  createDecimalFromString("100"),
+			amount: createDecimalFromString("33.33"),
+			expect: []Entry{
+				{AccountID: "payer", Amount: createDecimalFromString("33.33"), Currency: DefaultCurrency},
+				// 70 * 33.33 / 100 = 23.331 is truncated to 23.33 and the remainder 0.01 is added to the biggest leg.
+				{AccountID: "merchant", Amount: createDecimalFromString("-23.34"), Currency: DefaultCurrency},
+				{AccountID: "fee", Amount: createDecimalFromString("-6.66"), Currency: DefaultCurrency},
+				{AccountID: "tax", Amount: createDecimalFromString("-3.33"), Currency: DefaultCurrency},
+			},
+		},
+		{
+			name: "partial reversal of fx transfer",
+			legs: []internal.Ledger{
+				{AccountID: "acc-usd", Amount: createDecimalFromString("-10"), Currency: "USD"},
+				{AccountID: "fx-usd", Amount: createDecimalFromString("10"), Currency: "USD"},
+				{AccountID: "fx-jpy", Amount: createDecimalFromString("-1495"), Currency: "JPY"},
+				{AccountID: "acc-jpy", Amount: createDecimalFromString("1495"), Currency: "JPY"},
+			},
+			total:  createDecimalFromString("10"),
+			amount: createDecimalFromString("3.33"),
+			expect: []Entry{
+				{AccountID: "acc-usd", Amount: createDecimalFromString("3.33"), Currency: "USD"},
+				{AccountID: "fx-usd", Amount: createDecimalFromString("-3.33"), Currency: "USD"},
+				// 1495 * 3.33 / 10 = 497.835 is truncated to 497 as JPY doesn't have minor unit.
+				{AccountID: "fx-jpy", Amount: createDecimalFromString("497"), Currency: "JPY"},
+				{AccountID: "acc-jpy", Amount: createDecimalFromString("-497"), Currency: "JPY"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			entries := reversalEntries(tt.legs, tt.total, tt.amount)
+			if diff := cmp.Diff(tt.expect, entries); diff != "" {
+				t.Fatalf("(-want/+got) Entries:\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestReverse tests the full and partial reversal of a transaction. The cumulative reversal amount cannot exceed the
+// amount of the original transaction.
+func TestReverse(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txID, err := testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: fundingAccount.ID,
+		ToAccount:   acc1.ID,
+		Amount:      createDecimalFromString("100"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reversalID, err := testLedger.Reverse(context.Background(), txID, createDecimalFromString("30"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAvailableAndPosted(t, testLedger, acc1.ID, "70", "70")
+
+	// The remaining amount of the transaction is 70, so reversing 80 should be rejected.
+	_, err = testLedger.Reverse(context.Background(), txID, createDecimalFromString("80"))
+	if !errors.Is(err, ErrReversalExceedsAmount) {
+		t.Fatalf("expecting error %v but got %v", ErrReversalExceedsAmount, err)
+	}
+	// A reversal cannot be reversed.
+	_, err = testLedger.Reverse(context.Background(), reversalID, decimal.Zero)
+	if !errors.Is(err, ErrReverseReversal) {
+		t.Fatalf("expecting error %v but got %v", ErrReverseReversal, err)
+	}
+
+	// Reverse all the remaining amount.
+	if _, err := testLedger.Reverse(context.Background(), txID, decimal.Zero); err != nil {
+		t.Fatal(err)
+	}
+	checkAvailableAndPosted(t, testLedger, acc1.ID, "0", "0")
+
+	_, err = testLedger.Reverse(context.Background(), txID, decimal.Zero)
+	if !errors.Is(err, ErrReversalExceedsAmount) {
+		t.Fatalf("expecting error %v but got %v", ErrReversalExceedsAmount, err)
+	}
+}
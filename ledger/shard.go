@@ -0,0 +1,113 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/albertwidi/ftest/ledger/internal"
+	"github.com/albertwidi/ftest/logging"
+)
+
+// MaxAccountShards is the maximum number of sub-balances of a sharded account.
+const MaxAccountShards = 64
+
+const (
+	// ShardStrategyRandom chooses a random sub-balance for every posting.
+	ShardStrategyRandom = internal.ShardStrategyRandom
+	// ShardStrategyRoundRobin chooses the sub-balances one by one. The order is kept per process, so the postings from
+	// different instances of the service might choose the same sub-balance.
+	ShardStrategyRoundRobin = internal.ShardStrategyRoundRobin
+)
+
+// AccountShards is the sharding setting of an account.
+type AccountShards struct {
+	AccountID string
+	// Shards is the number of sub-balances of the account, zero means the account is not sharded.
+	Shards   int
+	Strategy string
+}
+
+func (s AccountShards) validate() error {
+	if s.AccountID == "" {
+		return errors.New("account_id cannot be empty")
+	}
+	if s.Shards < 0 || s.Shards > MaxAccountShards {
+		return fmt.Errorf("shards must be between 0 and %d", MaxAccountShards)
+	}
+	if s.Strategy != ShardStrategyRandom && s.Strategy != ShardStrategyRoundRobin {
+		return fmt.Errorf("shard strategy must be either %s or %s", ShardStrategyRandom, ShardStrategyRoundRobin)
+	}
+	return nil
+}
+
+// SetAccountShards splits the balance of a high-traffic account into multiple sub-balances. Every posting to a sharded
+// account only locks one of the sub-balances, so the postings to the account don't have to wait for each other. The
+// account still looks like one account, the balance is the SUM of the sub-balances and all ledger entries are recorded
+// under the account.
+//
+// Sharding is opt-in and mostly useful for the accounts that allow negative balance, like the funding account. A debit
+// from a sharded account that doesn't allow negative balance still locks the whole account, so the total balance can be
+// checked. Set the shards to zero to move the balance back into a single balance. ShardStrategyRandom is used if the
+// strategy is empty.
+//
+// ShardStrategyRoundRobin is rejected with WritePathFunction, because the order is kept by the service and the
+// ledger_create_transaction function always chooses the sub-balance randomly.
+func (l *Ledger) SetAccountShards(ctx context.Context, shards AccountShards) (AccountShards, error) {
+	if shards.Strategy == "" {
+		shards.Strategy = ShardStrategyRandom
+	}
+	if err := shards.validate(); err != nil {
+		return AccountShards{}, validationError(err)
+	}
+	if l.singleRoundTrip && shards.Strategy == ShardStrategyRoundRobin {
+		return AccountShards{}, validationError(fmt.Errorf("shard strategy %s is not supported by the %s write path", ShardStrategyRoundRobin, WritePathFunction))
+	}
+	if err := l.checkAccountsAccess(ctx, shards.AccountID); err != nil {
+		return AccountShards{}, err
+	}
+	err := l.storage.SetAccountShards(ctx, shards.AccountID, shards.Shards, shards.Strategy, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return AccountShards{}, fmt.Errorf("%w: account_id %s", ErrAccountNotFound, shards.AccountID)
+	}
+	if err != nil {
+		return AccountShards{}, err
+	}
+	return shards, nil
+}
+
+// RebalanceShards spreads the balance of every sharded account evenly across its sub-balances. The postings change the
+// sub-balances unevenly, for example the sub-balances of a funding account go negative at different speed, so the
+// sub-balances need to be rebalanced periodically.
+func (l *Ledger) RebalanceShards(ctx context.Context) error {
+	accountIDs, err := l.storage.GetShardedAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, accountID := range accountIDs {
+		if err := l.storage.RebalanceShards(ctx, accountID, time.Now()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to rebalance account_id %s: %w", accountID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RunShardRebalancer rebalances the sharded accounts every interval until the context is done.
+func (l *Ledger) RunShardRebalancer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.RebalanceShards(ctx); err != nil {
+				logging.FromContext(ctx).Error("failed to rebalance sharded accounts", "error", err)
+			}
+		}
+	}
+}
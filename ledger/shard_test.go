@@ -0,0 +1,208 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// TestShardedFundingAccount tests whether the sharded account still looks like one account. The balance is aggregated from
+// all the sub-balances and all ledger entries are recorded under the account.
+func TestShardedFundingAccount(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	if _, err := testLedger.SetAccountShards(context.Background(), AccountShards{
+		AccountID: fundingAccount.ID,
+		Shards:    4,
+		Strategy:  ShardStrategyRoundRobin,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errC := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			acc, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+			if err == nil {
+				_, err = testLedger.Transfer(context.Background(), Transfer{
+					FromAccount: fundingAccount.ID,
+					ToAccount:   acc.ID,
+					Amount:      createDecimalFromString("10"),
+				})
+			}
+			errC <- err
+		}()
+	}
+	wg.Wait()
+	close(errC)
+	for err := range errC {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkAvailableAndPosted(t, testLedger, fundingAccount.ID, "-80", "-80")
+
+	page, err := testLedger.GetAccountLedgerEntries(context.Background(), fundingAccount.ID, LedgerEntriesFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 8 {
+		t.Fatalf("expecting 8 ledger entries but got %d", len(page.Entries))
+	}
+	// The entries of a sharded account don't have the balances, as there is no running balance of the account.
+	for _, entry := range page.Entries {
+		if entry.PreviousBalance.Valid || entry.CurrentBalance.Valid {
+			t.Fatalf("expecting no balances in the entry of a sharded account but got %+v", entry)
+		}
+	}
+
+	// Unsharding the account moves the balance back into a single balance.
+	if _, err := testLedger.SetAccountShards(context.Background(), AccountShards{AccountID: fundingAccount.ID}); err != nil {
+		t.Fatal(err)
+	}
+	checkAvailableAndPosted(t, testLedger, fundingAccount.ID, "-80", "-80")
+}
+
+// TestShardedAccountInsufficientBalance tests whether the debit of a sharded account that doesn't allow negative balance
+// is checked against the total balance of the account, not the balance of a single sub-balance.
+func TestShardedAccountInsufficientBalance(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc2, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testLedger.SetAccountShards(context.Background(), AccountShards{AccountID: acc1.ID, Shards: 3}); err != nil {
+		t.Fatal(err)
+	}
+	transferAndCheck(t, testLedger, Transfer{
+		FromAccount: fundingAccount.ID,
+		ToAccount:   acc1.ID,
+		Amount:      createDecimalFromString("100"),
+	})
+
+	// The whole balance is in one of the sub-balances, but the debit can use the total balance of the account.
+	if _, err := testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: acc1.ID,
+		ToAccount:   acc2.ID,
+		Amount:      createDecimalFromString("60"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: acc1.ID,
+		ToAccount:   acc2.ID,
+		Amount:      createDecimalFromString("40.01"),
+	})
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expecting error %v but got %v", ErrInsufficientBalance, err)
+	}
+	checkAvailableAndPosted(t, testLedger, acc1.ID, "40", "40")
+
+	// The held balance cannot be used by the debit.
+	if _, err := testLedger.Authorize(context.Background(), Authorization{
+		FromAccount: acc1.ID,
+		ToAccount:   acc2.ID,
+		Amount:      createDecimalFromString("30"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: acc1.ID,
+		ToAccount:   acc2.ID,
+		Amount:      createDecimalFromString("11"),
+	})
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expecting error %v but got %v", ErrInsufficientBalance, err)
+	}
+	checkAvailableAndPosted(t, testLedger, acc1.ID, "10", "40")
+}
+
+func TestRebalanceShards(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testLedger.SetAccountShards(context.Background(), AccountShards{AccountID: acc.ID, Shards: 3}); err != nil {
+		t.Fatal(err)
+	}
+	transferAndCheck(t, testLedger, Transfer{
+		FromAccount: fundingAccount.ID,
+		ToAccount:   acc.ID,
+		Amount:      createDecimalFromString("100.01"),
+	})
+	if err := testLedger.RebalanceShards(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkAvailableAndPosted(t, testLedger, acc.ID, "100.01", "100.01")
+}
+
+func TestSetAccountShardsValidation(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	tests := []struct {
+		name      string
+		shards    AccountShards
+		expectErr error
+	}{
+		{
+			name:      "too many shards",
+			shards:    AccountShards{AccountID: "a", Shards: MaxAccountShards + 1},
+			expectErr: ErrValidationFailed,
+		},
+		{
+			name:      "negative shards",
+			shards:    AccountShards{AccountID: "a", Shards: -1},
+			expectErr: ErrValidationFailed,
+		},
+		{
+			name:      "unknown strategy",
+			shards:    AccountShards{AccountID: "a", Shards: 2, Strategy: "weighted"},
+			expectErr: ErrValidationFailed,
+		},
+		{
+			name:      "account not found",
+			shards:    AccountShards{AccountID: "a", Shards: 2},
+			expectErr: ErrAccountNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := testLedger.SetAccountShards(context.Background(), test.shards); !errors.Is(err, test.expectErr) {
+				t.Fatalf("expecting error %v but got %v", test.expectErr, err)
+			}
+		})
+	}
+
+	// The function write path always chooses the sub-balance randomly, so the round-robin strategy is rejected.
+	functionLedger := NewTest(t)
+	functionLedger.singleRoundTrip = true
+	fundingAccount := createFundingAccount(t, functionLedger)
+	_, err := functionLedger.SetAccountShards(context.Background(), AccountShards{AccountID: fundingAccount.ID, Shards: 2, Strategy: ShardStrategyRoundRobin})
+	if !errors.Is(err, ErrValidationFailed) {
+		t.Fatalf("expecting error %v but got %v", ErrValidationFailed, err)
+	}
+	if _, err := functionLedger.SetAccountShards(context.Background(), AccountShards{AccountID: fundingAccount.ID, Shards: 2}); err != nil {
+		t.Fatal(err)
+	}
+}
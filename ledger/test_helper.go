@@ -2,26 +2,51 @@ package ledger
 
 import (
 	"context"
+	"os"
 	"testing"
 
 	"github.com/albertwidi/ftest/ledger/internal"
 )
 
+// TestPostgresDSNEnv is the environment variable of the Postgres DSN that is used by the tests, the tests use the
+// in-memory storage if it is not set.
+const TestPostgresDSNEnv = "TEST_POSTGRES_DSN"
+
+// NewTest creates the ledger for a test. The ledger is backed by a new schema of the Postgres from TEST_POSTGRES_DSN if
+// it is set, so the queries are tested against the real database, otherwise the in-memory storage is used. Every test
+// has its own records in both cases, so the tests can run in parallel.
+func NewTest(t *testing.T) *Ledger {
+	if !testing.Testing() {
+		panic("cannot create test ledger outside of go test")
+	}
+	t.Helper()
+
+	dsn := os.Getenv(TestPostgresDSNEnv)
+	if dsn == "" {
+		return NewMemory()
+	}
+	ld, err := New(internal.NewTestDB(t, dsn), Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ld
+}
+
 func BootstrapTest(t *testing.T, ld *Ledger) {
 	if !testing.Testing() {
 		panic("cannot use bootstrap test outside of go test")
 	}
 	t.Helper()
 
-	_, err := ld.CreateAccount(context.Background(), "b-fund", AccountTypeFunding)
+	_, err := ld.CreateAccount(context.Background(), "b-fund", AccountTypeFunding, DefaultCurrency)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = ld.CreateAccount(context.Background(), "b-acc-1", AccountTypeUser)
+	_, err = ld.CreateAccount(context.Background(), "b-acc-1", AccountTypeUser, DefaultCurrency)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = ld.CreateAccount(context.Background(), "b-acc-2", AccountTypeUser)
+	_, err = ld.CreateAccount(context.Background(), "b-acc-2", AccountTypeUser, DefaultCurrency)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -33,10 +58,20 @@ func RestLedger(t *testing.T, ld *Ledger) {
 	}
 	t.Helper()
 
-	internal.TruncateTables(t, ld.pg, []string{
-		"transaction",
-		"accounts",
-		"accounts_balance",
-		"accounts_ledger",
-	}...)
+	switch storage := ld.storage.(type) {
+	case *internal.Postgres:
+		internal.TruncateTables(t, storage, []string{
+			"transaction",
+			"accounts",
+			"accounts_balance",
+			"accounts_balance_shards",
+			"accounts_ledger",
+			"idempotency_keys",
+			"holds",
+			"fx_rates",
+			"account_grants",
+		}...)
+	case *internal.Memory:
+		storage.Reset()
+	}
 }
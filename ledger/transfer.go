@@ -3,21 +3,43 @@ package ledger
 import (
 	"context"
 	"errors"
-	"time"
+	"fmt"
 
-	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
-
-	"github.com/albertwidi/ftest/ledger/internal"
 )
 
 type Transfer struct {
 	FromAccount string
 	ToAccount   string
 	Amount      decimal.Decimal
+	// Currency is the currency of the Amount, DefaultCurrency is used if the currency is empty. Both FromAccount and
+	// ToAccount must be in the same currency unless the FX leg is supplied.
+	Currency string
+	// FX is optional. It converts the money into other currency, so the transfer can be done to an account that has
+	// different currency from the FromAccount.
+	FX *FXLeg
+	// IdempotencyKey is optional. When set, replaying the transfer with the same key returns the original transaction_id
+	// instead of creating a new transaction.
+	IdempotencyKey string
+}
+
+// FXLeg is the explicit conversion of a cross-currency transfer. The money from the FromAccount goes into the FromPosition
+// account in the currency of the transfer, and the ToPosition account pays the Amount in the FX currency to the ToAccount.
+// This way the entries are still balanced per currency, and the position accounts hold the exposure of the conversion.
+//
+// The Amount is not checked against the FX rates, so the explicit FX leg requires the funding:admin scope. Use
+// Ledger.Convert to convert the money with the stored FX rates.
+type FXLeg struct {
+	// FromPosition is the position account in the currency of the transfer, see FXPositionAccountID.
+	FromPosition string
+	// ToPosition is the position account in the FX currency, see FXPositionAccountID.
+	ToPosition string
+	// Amount is the converted amount received by the ToAccount.
+	Amount   decimal.Decimal
+	Currency string
 }
 
-func (t Transfer) validate() error {
+func (t Transfer) Validate() error {
 	if t.FromAccount == "" {
 		return errors.New("from account cannot be empty")
 	}
@@ -27,42 +49,95 @@ func (t Transfer) validate() error {
 	if t.Amount.IsZero() {
 		return errors.New("amount cannot be zero/empty")
 	}
+	if err := validateCurrency(currencyOrDefault(t.Currency)); err != nil {
+		return err
+	}
+	if t.FX != nil {
+		if t.FX.FromPosition == "" || t.FX.ToPosition == "" {
+			return errors.New("fx position accounts cannot be empty")
+		}
+		if !t.FX.Amount.IsPositive() {
+			return errors.New("fx amount must be positive")
+		}
+		if err := validateCurrency(t.FX.Currency); err != nil {
+			return err
+		}
+		if t.FX.Currency == currencyOrDefault(t.Currency) {
+			return errors.New("fx currency cannot be the same with the transfer currency")
+		}
+		if t.FX.FromPosition != FXPositionAccountID(currencyOrDefault(t.Currency)) {
+			return fmt.Errorf("fx from position must be the position account %s", FXPositionAccountID(currencyOrDefault(t.Currency)))
+		}
+		if t.FX.ToPosition != FXPositionAccountID(t.FX.Currency) {
+			return fmt.Errorf("fx to position must be the position account %s", FXPositionAccountID(t.FX.Currency))
+		}
+	}
 	return nil
 }
 
-func (t Transfer) buildTransaction(transactionID string) internal.CreateTransaction {
-	txTime := time.Now()
-	tx := internal.CreateTransaction{
-		TransactionID: transactionID,
-		Amount:        t.Amount,
-		CreatedAt:     txTime,
-		LedgerEntries: []internal.Ledger{
-			// Create the first entry of DEBIT to dedcut user's money.
+func (t Transfer) Entries() []Entry {
+	if t.FX != nil {
+		return []Entry{
+			// DEBIT the user's money in the currency of the transfer into the position account.
 			{
 				AccountID: t.FromAccount,
-				Amount:    t.Amount.Mul(decimal.NewFromInt(-1)),
-				CreatedAt: txTime,
+				Amount:    t.Amount.Neg(),
+				Currency:  t.Currency,
 			},
-			// Create the second etry of CREDIT to add user's money.
 			{
-				AccountID: t.ToAccount,
+				AccountID: t.FX.FromPosition,
 				Amount:    t.Amount,
-				CreatedAt: txTime,
+				Currency:  t.Currency,
+			},
+			// CREDIT the converted money from the position account of the FX currency.
+			{
+				AccountID: t.FX.ToPosition,
+				Amount:    t.FX.Amount.Neg(),
+				Currency:  t.FX.Currency,
 			},
+			{
+				AccountID: t.ToAccount,
+				Amount:    t.FX.Amount,
+				Currency:  t.FX.Currency,
+			},
+		}
+	}
+	return []Entry{
+		// Create the first entry of DEBIT to dedcut user's money.
+		{
+			AccountID: t.FromAccount,
+			Amount:    t.Amount.Mul(decimal.NewFromInt(-1)),
+			Currency:  t.Currency,
+		},
+		// Create the second etry of CREDIT to add user's money.
+		{
+			AccountID: t.ToAccount,
+			Amount:    t.Amount,
+			Currency:  t.Currency,
 		},
 	}
-	return tx
 }
 
+func (t Transfer) transactionType() string {
+	return TransactionTypeTransfer
+}
+
+// Transfer moves the money between two accounts and returns the transaction_id. The transfer is retried when it is aborted
+// by the database because of a deadlock or a serialization failure, ErrTransactionConflict is returned if the transfer
+// still conflicts after all the retries.
+//
+// The transfer with the FX leg returns ErrForbidden if the principal is not a funding admin, as the converted amount is
+// chosen by the client.
 func (l *Ledger) Transfer(ctx context.Context, request Transfer) (string, error) {
-	txID := uuid.NewString()
-	tx, err := buildTransaction(txID, request)
-	if err != nil {
-		return txID, err
-	}
-	if err := l.checkBalances(ctx, tx.Summaries); err != nil {
-		return txID, err
-	}
-	err = l.pg.CreateTransaction(ctx, tx)
-	return txID, err
+	ctx, span := tracer.Start(ctx, "Ledger.Transfer")
+	transactionID, err := l.retryOnConflict(ctx, "transfer", func() (string, error) {
+		transactionID, err := l.post(ctx, request, request.IdempotencyKey)
+		if errors.Is(err, ErrTransactionConflict) {
+			l.observer.ObserveTransfer(TransferOutcomeDeadlockRetry)
+		}
+		return transactionID, err
+	})
+	l.observer.ObserveTransfer(transferOutcome(err))
+	endSpan(span, err)
+	return transactionID, err
 }
@@ -2,17 +2,23 @@ package ledger
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/shopspring/decimal"
 
+	"github.com/albertwidi/ftest/auth"
 	"github.com/albertwidi/ftest/ledger/internal"
 )
 
 // TestTransfer tests transfer between accounts and its ledger validity.
 func TestTransfer(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
 	// Create four(4) different accounts. We will cover these cases:
 	// 1. 'one' transfer to 'two'.
 	// 2. 'two' transfer to 'three'.
@@ -20,19 +26,19 @@ func TestTransfer(t *testing.T) {
 	// 4. 'four' transfer to 'one'.
 
 	fundingAccount := createFundingAccount(t, testLedger)
-	acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser)
+	acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
 	if err != nil {
 		t.Fatal(err)
 	}
-	acc2, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser)
+	acc2, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
 	if err != nil {
 		t.Fatal(err)
 	}
-	acc3, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser)
+	acc3, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
 	if err != nil {
 		t.Fatal(err)
 	}
-	acc4, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser)
+	acc4, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -72,29 +78,32 @@ func TestTransfer(t *testing.T) {
 		},
 	}
 	for _, tf := range transfers {
-		transferAndCheck(t, tf)
+		transferAndCheck(t, testLedger, tf)
 	}
 	expectEntries := []internal.Ledger{
 		{
 			AccountID:       acc1.ID,
 			Amount:          createDecimalFromString("100"),
-			PreviousBalance: createDecimalFromString("0"),
-			CurrentBalance:  createDecimalFromString("100"),
+			Currency:        DefaultCurrency,
+			PreviousBalance: decimal.NewNullDecimal(createDecimalFromString("0")),
+			CurrentBalance:  decimal.NewNullDecimal(createDecimalFromString("100")),
 		},
 		{
 			AccountID:       acc1.ID,
 			Amount:          createDecimalFromString("-100"),
-			PreviousBalance: createDecimalFromString("100"),
-			CurrentBalance:  createDecimalFromString("0"),
+			Currency:        DefaultCurrency,
+			PreviousBalance: decimal.NewNullDecimal(createDecimalFromString("100")),
+			CurrentBalance:  decimal.NewNullDecimal(createDecimalFromString("0")),
 		},
 		{
 			AccountID:       acc1.ID,
 			Amount:          createDecimalFromString("100"),
-			PreviousBalance: createDecimalFromString("0"),
-			CurrentBalance:  createDecimalFromString("100"),
+			Currency:        DefaultCurrency,
+			PreviousBalance: decimal.NewNullDecimal(createDecimalFromString("0")),
+			CurrentBalance:  decimal.NewNullDecimal(createDecimalFromString("100")),
 		},
 	}
-	entries, err := testLedger.pg.GetLedgerByAccountID(context.Background(), acc1.ID)
+	entries, err := testLedger.storage.GetLedgerByAccountID(context.Background(), acc1.ID, internal.LedgerFilter{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -105,10 +114,163 @@ func TestTransfer(t *testing.T) {
 	}
 }
 
-func transferAndCheck(t *testing.T, transfer Transfer) {
+// TestTransferIdempotency tests whether replaying a transfer with the same idempotency key only moves the money once.
+func TestTransferIdempotency(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer := Transfer{
+		FromAccount:    fundingAccount.ID,
+		ToAccount:      acc1.ID,
+		Amount:         createDecimalFromString("100"),
+		IdempotencyKey: acc1.ID + "-topup",
+	}
+
+	// Replay the same transfer concurrently, all of them should return the same transaction_id.
+	var (
+		txIDs = make([]string, 10)
+		errs  = make([]error, 10)
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			txIDs[i], errs[i] = testLedger.Transfer(context.Background(), transfer)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if txIDs[i] != txIDs[0] {
+			t.Fatalf("expecting transaction_id %s but got %s", txIDs[0], txIDs[i])
+		}
+	}
+
+	balance, err := testLedger.GetAccountBalance(context.Background(), acc1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Balance.Cmp(createDecimalFromString("100")) != 0 {
+		t.Fatalf("expecting balance 100 but got %s", balance.Balance.String())
+	}
+
+	// Using the same key for different request should be rejected.
+	transfer.Amount = createDecimalFromString("200")
+	_, err = testLedger.Transfer(context.Background(), transfer)
+	if !errors.Is(err, ErrIdempotencyKeyMismatch) {
+		t.Fatalf("expecting error %v but got %v", ErrIdempotencyKeyMismatch, err)
+	}
+}
+
+// TestTransferFX tests the cross-currency transfer. The transfer is rejected without the FX leg, and with the FX leg the
+// money is converted through the position accounts of both currencies.
+func TestTransferFX(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	usdFunding, err := testLedger.CreateAccount(context.Background(), "", AccountTypeFunding, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usdAccount, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idrAccount, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, "IDR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testLedger.ensureFXPositions(context.Background(), "USD", "IDR"); err != nil {
+		t.Fatal(err)
+	}
+	usdPosition, idrPosition := FXPositionAccountID("USD"), FXPositionAccountID("IDR")
+
+	if _, err := testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: usdFunding.ID,
+		ToAccount:   usdAccount.ID,
+		Amount:      createDecimalFromString("100"),
+		Currency:    "USD",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Transfer without the FX leg is rejected because the to account is in IDR.
+	_, err = testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: usdAccount.ID,
+		ToAccount:   idrAccount.ID,
+		Amount:      createDecimalFromString("10"),
+		Currency:    "USD",
+	})
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Fatalf("expecting error %v but got %v", ErrCurrencyMismatch, err)
+	}
+
+	transfer := Transfer{
+		FromAccount: usdAccount.ID,
+		ToAccount:   idrAccount.ID,
+		Amount:      createDecimalFromString("10"),
+		Currency:    "USD",
+		FX: &FXLeg{
+			FromPosition: usdPosition,
+			ToPosition:   idrPosition,
+			Amount:       createDecimalFromString("155000"),
+			Currency:     "IDR",
+		},
+	}
+	// The FX leg can only go through the position accounts of the currencies.
+	for _, fx := range []FXLeg{
+		{FromPosition: usdFunding.ID, ToPosition: idrPosition, Amount: transfer.FX.Amount, Currency: "IDR"},
+		{FromPosition: usdPosition, ToPosition: FXPositionAccountID("USD"), Amount: transfer.FX.Amount, Currency: "IDR"},
+	} {
+		invalid := transfer
+		invalid.FX = &fx
+		if _, err := testLedger.Transfer(context.Background(), invalid); !errors.Is(err, ErrValidationFailed) {
+			t.Fatalf("expecting error %v but got %v", ErrValidationFailed, err)
+		}
+	}
+	// The converted amount is chosen by the client, so the FX leg requires the funding admin.
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{
+		ID:     "key-1",
+		Scopes: []string{auth.ScopeTransfersWrite},
+	})
+	if _, err := testLedger.Transfer(ctx, transfer); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expecting error %v but got %v", ErrForbidden, err)
+	}
+
+	if _, err := testLedger.Transfer(context.Background(), transfer); err != nil {
+		t.Fatal(err)
+	}
+
+	for accountID, expect := range map[string]string{
+		usdAccount.ID: "90",
+		usdPosition:   "10",
+		idrPosition:   "-155000",
+		idrAccount.ID: "155000",
+	} {
+		balance, err := testLedger.GetAccountBalance(context.Background(), accountID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !balance.Balance.Equal(createDecimalFromString(expect)) {
+			t.Fatalf("expecting balance of %s to be %s but got %s", accountID, expect, balance.Balance)
+		}
+	}
+}
+
+func transferAndCheck(t *testing.T, testLedger *Ledger, transfer Transfer) {
 	t.Helper()
 
-	previousBalances, err := testLedger.pg.GetAccountsBalance(context.Background(), transfer.FromAccount, transfer.ToAccount)
+	previousBalances, err := testLedger.storage.GetAccountsBalance(context.Background(), transfer.FromAccount, transfer.ToAccount)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -124,7 +286,7 @@ func transferAndCheck(t *testing.T, transfer Transfer) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	balances, err := testLedger.pg.GetAccountsBalance(context.Background(), transfer.FromAccount, transfer.ToAccount)
+	balances, err := testLedger.storage.GetAccountsBalance(context.Background(), transfer.FromAccount, transfer.ToAccount)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -142,3 +304,66 @@ func transferAndCheck(t *testing.T, transfer Transfer) {
 		t.Fatalf("expecting to balance %s but got %s", expectTo.String(), mapBalances[transfer.ToAccount].Balance.String())
 	}
 }
+
+// TestGetTransaction tests whether the transaction is returned along with all of its ledger entries.
+func TestGetTransaction(t *testing.T) {
+	t.Parallel()
+	testLedger := NewTest(t)
+
+	fundingAccount := createFundingAccount(t, testLedger)
+	acc1, err := testLedger.CreateAccount(context.Background(), "", AccountTypeUser, DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txID, err := testLedger.Transfer(context.Background(), Transfer{
+		FromAccount: fundingAccount.ID,
+		ToAccount:   acc1.ID,
+		Amount:      createDecimalFromString("100"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := testLedger.GetTransaction(context.Background(), txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := Transaction{
+		ID:             txID,
+		Type:           TransactionTypeTransfer,
+		Amount:         createDecimalFromString("100"),
+		Currency:       DefaultCurrency,
+		ReversedAmount: decimal.Zero,
+		Entries: []LedgerEntry{
+			{
+				TransactionID:   txID,
+				AccountID:       fundingAccount.ID,
+				Amount:          createDecimalFromString("-100"),
+				Currency:        DefaultCurrency,
+				PreviousBalance: decimal.NewNullDecimal(decimal.Zero),
+				CurrentBalance:  decimal.NewNullDecimal(createDecimalFromString("-100")),
+			},
+			{
+				TransactionID:   txID,
+				AccountID:       acc1.ID,
+				Amount:          createDecimalFromString("100"),
+				Currency:        DefaultCurrency,
+				PreviousBalance: decimal.NewNullDecimal(decimal.Zero),
+				CurrentBalance:  decimal.NewNullDecimal(createDecimalFromString("100")),
+			},
+		},
+	}
+	opts := []cmp.Option{
+		cmpopts.IgnoreFields(Transaction{}, "CreatedAt"),
+		cmpopts.IgnoreFields(LedgerEntry{}, "CreatedAt"),
+		cmpopts.SortSlices(func(a, b LedgerEntry) bool { return a.AccountID < b.AccountID }),
+	}
+	if diff := cmp.Diff(expect, tx, opts...); diff != "" {
+		t.Fatalf("(-want/+got) Transaction:\n%s", diff)
+	}
+
+	_, err = testLedger.GetTransaction(context.Background(), "unknown")
+	if !errors.Is(err, ErrTransactionNotFound) {
+		t.Fatalf("expecting error %v but got %v", ErrTransactionNotFound, err)
+	}
+}
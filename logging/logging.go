@@ -0,0 +1,124 @@
+// logging passes the request scoped slog.Logger through the context, and writes the access log of the requests.
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader is the header of the request id. The request id from the client or the load balancer is used if it is
+// valid, otherwise a new request id is generated. The request id is always returned in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of the request id from the client, so the client cannot flood the logs.
+const maxRequestIDLength = 128
+
+type loggerKey struct{}
+
+// request is the state of a request that is shared by all contexts of the request.
+type request struct {
+	mu    sync.Mutex
+	attrs []any
+}
+
+type requestKey struct{}
+
+// FromContext returns the logger of the context, or the default logger if the context doesn't have one.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// WithLogger returns a copy of the context with the logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// With returns a copy of the context with the logger that has the given attributes. The attributes are also added to the
+// access log of the request, if the context belongs to a request.
+//
+// The arguments are the same with slog.Logger.With, for example With(ctx, "account_id", accountID).
+func With(ctx context.Context, args ...any) context.Context {
+	Annotate(ctx, args...)
+	return WithLogger(ctx, FromContext(ctx).With(args...))
+}
+
+// Annotate adds the attributes to the access log of the request, for the attributes that are only known at the end of the
+// request, for example the id of the created transaction. Nothing is added if the context doesn't belong to a request.
+func Annotate(ctx context.Context, args ...any) {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		req.mu.Lock()
+		req.attrs = append(req.attrs, args...)
+		req.mu.Unlock()
+	}
+}
+
+// RequestID returns the request id of the request, or the generated request id if the request doesn't have a valid one.
+func RequestID(r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength {
+		return uuid.NewString()
+	}
+	for _, c := range id {
+		if c > unicode.MaxASCII || !unicode.IsPrint(c) {
+			return uuid.NewString()
+		}
+	}
+	return id
+}
+
+// Middleware sets the request id of the request, and passes the logger with the request id to the context of the request.
+// The trace id is added to the logger as well if the request is traced, so the middleware must be used after the tracing
+// middleware.
+//
+// One access log line is written after the request is done, with the attributes that are added by With during the request.
+// The route is the chi route pattern, so the middleware must be used by the root router for the route pattern to be
+// complete after the request is routed.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := RequestID(r)
+		w.Header().Set(RequestIDHeader, requestID)
+
+		logger := FromContext(r.Context()).With("request_id", requestID)
+		if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+			logger = logger.With("trace_id", spanContext.TraceID().String())
+		}
+		req := &request{}
+		ctx := context.WithValue(WithLogger(r.Context(), logger), requestKey{}, req)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		req.mu.Lock()
+		attrs := append([]any{
+			"method", r.Method,
+			"route", route,
+			"status", status,
+			"latency", time.Since(start),
+			"bytes", ww.BytesWritten(),
+		}, req.attrs...)
+		req.mu.Unlock()
+		logger.Info("access", attrs...)
+	})
+}
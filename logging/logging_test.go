@@ -0,0 +1,125 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+// newRouter returns the router with the middleware, and the buffer of the JSON logs of the router.
+func newRouter(handler http.HandlerFunc) (http.Handler, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithLogger(r.Context(), logger)))
+		})
+	})
+	r.Use(Middleware)
+	r.Post("/v1/ledger/transfer", handler)
+	return r, buf
+}
+
+func decodeLogs(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var logs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		log := make(map[string]any)
+		if err := json.Unmarshal([]byte(line), &log); err != nil {
+			t.Fatal(err)
+		}
+		// The time and the latency are different on every run.
+		delete(log, "time")
+		delete(log, "latency")
+		logs = append(logs, log)
+	}
+	return logs
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	r, buf := newRouter(func(w http.ResponseWriter, r *http.Request) {
+		ctx := With(r.Context(), "from_account", "a", "to_account", "b")
+		FromContext(ctx).Warn("insufficient balance")
+		Annotate(ctx, "transaction_id", "tx")
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/ledger/transfer", nil)
+	req.Header.Set(RequestIDHeader, "request-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if id := w.Header().Get(RequestIDHeader); id != "request-1" {
+		t.Fatalf("expecting request id request-1 but got %s", id)
+	}
+
+	expect := []map[string]any{
+		{
+			"level":        "WARN",
+			"msg":          "insufficient balance",
+			"request_id":   "request-1",
+			"from_account": "a",
+			"to_account":   "b",
+		},
+		{
+			"level":          "INFO",
+			"msg":            "access",
+			"request_id":     "request-1",
+			"method":         http.MethodPost,
+			"route":          "/v1/ledger/transfer",
+			"status":         float64(http.StatusCreated),
+			"bytes":          float64(0),
+			"from_account":   "a",
+			"to_account":     "b",
+			"transaction_id": "tx",
+		},
+	}
+	if diff := cmp.Diff(expect, decodeLogs(t, buf)); diff != "" {
+		t.Fatalf("(-want/+got) logs:\n%s", diff)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		requestID string
+		generated bool
+	}{
+		{name: "valid", requestID: "2f1c7a9e-request"},
+		{name: "empty", requestID: "", generated: true},
+		{name: "too long", requestID: strings.Repeat("a", maxRequestIDLength+1), generated: true},
+		{name: "control character", requestID: "id\x1b[31m", generated: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(RequestIDHeader, test.requestID)
+			id := RequestID(req)
+			if !test.generated {
+				if id != test.requestID {
+					t.Fatalf("expecting request id %s but got %s", test.requestID, id)
+				}
+				return
+			}
+			if _, err := uuid.Parse(id); err != nil {
+				t.Fatalf("expecting generated request id but got %s", id)
+			}
+		})
+	}
+}
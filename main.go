@@ -2,29 +2,73 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	_ "github.com/lib/pq"
 
+	"github.com/albertwidi/ftest/auth"
+	"github.com/albertwidi/ftest/database"
+	"github.com/albertwidi/ftest/database/migrate"
 	"github.com/albertwidi/ftest/handler"
 	"github.com/albertwidi/ftest/ledger"
+	"github.com/albertwidi/ftest/logging"
+	"github.com/albertwidi/ftest/metrics"
+	"github.com/albertwidi/ftest/tracing"
 	"github.com/go-chi/chi/v5"
 )
 
 type config struct {
 	postgresDSN string
 	servicePort string
-	// delayStart is used as a quick hack to pause before starting the service. This is useful when we want
-	// to wait for other service in docker-compose.
-	delayStart string
+	// connectTimeout is the maximum time to wait for the database to be reachable when the service starts.
+	connectTimeout time.Duration
+	// migrateOnStart applies the pending migrations before the service starts serving requests.
+	migrateOnStart bool
+	// shardRebalanceInterval is the interval to rebalance the sub-balances of the sharded accounts, zero disables it.
+	shardRebalanceInterval time.Duration
+	// writePath is the way the ledger writes the transactions to the database, see ledger.WritePathStatements and
+	// ledger.WritePathFunction.
+	writePath string
+	// batchSize and batchLatency are the group commit of the transactions, the batching is disabled if the size is zero.
+	batchSize    int
+	batchLatency time.Duration
+	// readTimeout and writeTimeout are the maximum duration of the read and write requests, zero disables the timeout.
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	// shutdownDelay is the time between the readiness flips to failing and the server starts draining, so the load
+	// balancer has the time to stop routing the requests to the service.
+	shutdownDelay time.Duration
+	// drainTimeout is the maximum time to wait for the in-flight requests during the shutdown.
+	drainTimeout time.Duration
+	// tracingExporter and tracingFile configure the export of the spans, see tracing.Config.
+	tracingExporter string
+	tracingFile     string
+	// jwks is the file path or the URL of the JWKS to verify the JWTs of the end users, the JWTs are not accepted if it is
+	// empty. The JWKS is loaded again after jwksRefreshInterval.
+	jwks                string
+	jwksRefreshInterval time.Duration
+	jwt                 auth.JWTConfig
+}
+
+// durationFromEnv returns the duration of the environment variable, or the fallback if the variable is empty.
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	dur, err := time.ParseDuration(value)
+	if err != nil {
+		panic(fmt.Errorf("invalid %s: %w", key, err))
+	}
+	return dur
 }
 
 func loadConfig() config {
@@ -36,37 +80,124 @@ func loadConfig() config {
 	if servicePort == "" {
 		servicePort = "8080"
 	}
-	delayStart := os.Getenv("DELAY_START")
-	if delayStart == "" {
-		delayStart = "0s"
+	migrateOnStart, _ := strconv.ParseBool(os.Getenv("MIGRATE_ON_START"))
+	var batchSize int
+	if size := os.Getenv("LEDGER_BATCH_SIZE"); size != "" {
+		var err error
+		batchSize, err = strconv.Atoi(size)
+		if err != nil {
+			panic(err)
+		}
 	}
 	return config{
-		postgresDSN: dsn,
-		servicePort: servicePort,
-		delayStart:  delayStart,
+		postgresDSN:            dsn,
+		servicePort:            servicePort,
+		connectTimeout:         durationFromEnv("DB_CONNECT_TIMEOUT", time.Minute),
+		migrateOnStart:         migrateOnStart,
+		shardRebalanceInterval: durationFromEnv("SHARD_REBALANCE_INTERVAL", time.Minute),
+		writePath:              os.Getenv("LEDGER_WRITE_PATH"),
+		batchSize:              batchSize,
+		batchLatency:           durationFromEnv("LEDGER_BATCH_LATENCY", 0),
+		readTimeout:            durationFromEnv("READ_TIMEOUT", 5*time.Second),
+		writeTimeout:           durationFromEnv("WRITE_TIMEOUT", 10*time.Second),
+		shutdownDelay:          durationFromEnv("SHUTDOWN_DELAY", 5*time.Second),
+		drainTimeout:           durationFromEnv("DRAIN_TIMEOUT", 20*time.Second),
+		tracingExporter:        os.Getenv("TRACING_EXPORTER"),
+		tracingFile:            os.Getenv("TRACING_FILE"),
+		jwks:                   os.Getenv("JWT_JWKS"),
+		jwksRefreshInterval:    durationFromEnv("JWT_JWKS_REFRESH_INTERVAL", 5*time.Minute),
+		jwt: auth.JWTConfig{
+			Issuer:     os.Getenv("JWT_ISSUER"),
+			Audience:   os.Getenv("JWT_AUDIENCE"),
+			OwnerClaim: os.Getenv("JWT_OWNER_CLAIM"),
+			Leeway:     durationFromEnv("JWT_LEEWAY", 30*time.Second),
+		},
 	}
 }
 
 func main() {
+	// The logs are written as JSON, so the logs of all replicas can be collected and searched by the request id.
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
 	config := loadConfig()
 
-	dur, err := time.ParseDuration(config.delayStart)
+	ctxSignal, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	shutdownTracing, err := tracing.Setup(ctxSignal, tracing.Config{
+		ServiceName: "ledger_service",
+		Exporter:    config.tracingExporter,
+		File:        config.tracingFile,
+	})
 	if err != nil {
 		panic(err)
 	}
-	time.Sleep(dur)
 
-	ctxSignal, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM)
-	defer cancel()
+	db, err := connectDB(ctxSignal, config.postgresDSN, config.connectTimeout)
+	if err != nil {
+		panic(err)
+	}
 
-	db, err := sql.Open("postgres", config.postgresDSN)
+	keys := auth.NewKeys(db)
+	// ledger_service migrate [up|down|status] only runs the migration and exits.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(ctxSignal, db, os.Args[2:]); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if config.migrateOnStart {
+		if err := runMigrate(ctxSignal, db, nil); err != nil {
+			panic(err)
+		}
+	}
+	// ledger_service apikey create <name> <scopes...> creates an API key and exits, after the migrations are applied, so the first admin key can be created.
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		if err := runAPIKey(ctxSignal, keys, os.Args[2:]); err != nil {
+			panic(err)
+		}
+		return
+	}
+	authenticator, err := newAuthenticator(ctxSignal, keys, config)
 	if err != nil {
 		panic(err)
 	}
 
-	ld := ledger.New(db)
+	m := metrics.New(db)
+	ld, err := ledger.New(db, ledger.Config{
+		Observer:  m,
+		WritePath: config.writePath,
+		Batch: ledger.BatchConfig{
+			MaxSize:    config.batchSize,
+			MaxLatency: config.batchLatency,
+			Timeout:    config.writeTimeout,
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	wk := newWorkers()
+	if config.shardRebalanceInterval > 0 {
+		wk.run(func(ctx context.Context) {
+			ld.RunShardRebalancer(ctx, config.shardRebalanceInterval)
+		})
+	}
+	migrator, err := migrate.New(db, database.LedgerMigrations, database.LedgerMigrationsDir)
+	if err != nil {
+		panic(err)
+	}
+	ready := newReadiness(databaseCheck(db), migrationCheck(migrator))
 	r := chi.NewRouter()
-	handle(ld, r)
+	r.Use(tracing.Middleware)
+	r.Use(logging.Middleware)
+	r.Use(m.Middleware)
+	r.Use(handler.Timeout(handler.Timeouts{
+		Read:  config.readTimeout,
+		Write: config.writeTimeout,
+	}))
+	r.Get("/healthz", liveness)
+	r.Get("/readyz", ready.ServeHTTP)
+	r.Handle("/metrics", m.Handler())
+	handle(ld, keys, authenticator, r)
 
 	listener, err := net.Listen("tcp", ":"+config.servicePort)
 	if err != nil {
@@ -82,23 +213,86 @@ func main() {
 		slog.Info(fmt.Sprintf("listening to %s", listener.Addr().String()))
 		errC <- server.Serve(listener)
 	}()
+	ready.set(true)
 
 	select {
 	case <-ctxSignal.Done():
+		// Stop listening to the signal, so the second signal kills the service without waiting for the shutdown.
+		cancel()
 	case err := <-errC:
 		if err != nil {
 			panic(err)
 		}
 	}
+	shutdown(config, ready, server, ld, wk, db, shutdownTracing)
 	slog.Info("ledger service shutdown")
 }
 
-func handle(ld *ledger.Ledger, r chi.Router) {
+// newAuthenticator returns the authenticator of the requests. The clients are authenticated by their API keys, and also by
+// the JWTs of the gateway if the JWKS is configured.
+func newAuthenticator(ctx context.Context, keys *auth.Keys, config config) (auth.Authenticator, error) {
+	if config.jwks == "" {
+		return keys, nil
+	}
+	jwks, err := auth.LoadJWKS(ctx, config.jwks, config.jwksRefreshInterval)
+	if err != nil {
+		return nil, err
+	}
+	jwt, err := auth.NewJWTAuthenticator(jwks, config.jwt)
+	if err != nil {
+		return nil, err
+	}
+	return auth.Chain{keys, jwt}, nil
+}
+
+func handle(ld *ledger.Ledger, keys *auth.Keys, authenticator auth.Authenticator, r chi.Router) {
+	authenticate := handler.Authenticate(authenticator)
+	// The API keys can only be managed with an API key, so an end user cannot create a key that has no owner.
+	authenticateKey := handler.Authenticate(keys)
+	read := handler.RequireScope(auth.ScopeRead)
+	accountsWrite := handler.RequireScope(auth.ScopeAccountsWrite)
+	transfersWrite := handler.RequireScope(auth.ScopeTransfersWrite)
+	fundingAdmin := handler.RequireScope(auth.ScopeFundingAdmin)
+	keysAdmin := handler.RequireScope(auth.ScopeKeysAdmin)
+
+	apiKeys := handler.NewAPIKeys(keys)
 	handler := handler.New(ld)
 	r.Route("/v1/ledger", func(r chi.Router) {
-		r.Post("/transfer", handler.LedgerTransfer)
-		r.Post("/account", handler.LedgerCreateAccount)
-		r.Get("/balance", handler.LedgerGetBalance)
-		r.Get("/", handler.LedgerGetTransactionsByAccountID)
+		r.Use(authenticate)
+		r.With(transfersWrite).Post("/transfer", handler.LedgerTransfer)
+		r.Route("/transactions", func(r chi.Router) {
+			r.With(transfersWrite).Post("/", handler.LedgerCreateTransaction)
+			r.With(read).Get("/{transaction_id}", handler.LedgerGetTransaction)
+			r.With(transfersWrite).Post("/{transaction_id}/reverse", handler.LedgerReverseTransaction)
+		})
+		r.Route("/authorizations", func(r chi.Router) {
+			r.With(transfersWrite).Post("/", handler.LedgerAuthorize)
+			r.With(read).Get("/{hold_id}", handler.LedgerGetHold)
+			r.With(transfersWrite).Post("/{hold_id}/capture", handler.LedgerCapture)
+			r.With(transfersWrite).Post("/{hold_id}/void", handler.LedgerVoid)
+		})
+		r.With(transfersWrite).Post("/conversions", handler.LedgerConvert)
+		// Creating a funding account also requires auth.ScopeFundingAdmin, it is checked by the ledger.
+		r.With(accountsWrite).Post("/account", handler.LedgerCreateAccount)
+		r.With(accountsWrite).Put("/account/shards", handler.LedgerSetAccountShards)
+		// The grants of an account can only be managed by the owner of the account, it is checked by the ledger.
+		r.Route("/account/{account_id}/grants", func(r chi.Router) {
+			r.With(read).Get("/", handler.LedgerGetAccountGrants)
+			r.With(accountsWrite).Post("/", handler.LedgerGrantAccess)
+			r.With(accountsWrite).Delete("/{grantee_id}", handler.LedgerRevokeAccess)
+		})
+		r.With(read).Get("/balance", handler.LedgerGetBalance)
+		r.With(read).Get("/", handler.LedgerGetTransactionsByAccountID)
+	})
+	r.Route("/v1/fx", func(r chi.Router) {
+		r.Use(authenticate)
+		r.With(fundingAdmin).Post("/rates", handler.FXCreateRate)
+		r.With(read).Get("/rates", handler.FXGetRates)
+	})
+	r.Route("/v1/admin/api-keys", func(r chi.Router) {
+		r.Use(authenticateKey, keysAdmin)
+		r.Post("/", apiKeys.CreateAPIKey)
+		r.Get("/", apiKeys.ListAPIKeys)
+		r.Delete("/{api_key_id}", apiKeys.RevokeAPIKey)
 	})
 }
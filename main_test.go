@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestConnectDBTimeout tests the connection is retried until the timeout, and connectDB gives up at the timeout.
+func TestConnectDBTimeout(t *testing.T) {
+	t.Parallel()
+
+	// The listener closes all connections right away, so every attempt fails like a database that is not ready yet.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var connections atomic.Int64
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			conn.Close()
+		}
+	}()
+
+	const timeout = 500 * time.Millisecond
+	start := time.Now()
+	db, err := connectDB(context.Background(), "postgres://"+listener.Addr().String()+"/ledger?sslmode=disable", timeout)
+	elapsed := time.Since(start)
+	if err == nil {
+		db.Close()
+		t.Fatal("expecting error but got nil")
+	}
+	if elapsed < timeout || elapsed > timeout+time.Second {
+		t.Fatalf("expecting connectDB to give up after %s but it took %s", timeout, elapsed)
+	}
+	if count := connections.Load(); count < 2 {
+		t.Fatalf("expecting the connection to be retried but got %d connections", count)
+	}
+}
@@ -0,0 +1,115 @@
+// metrics exposes the metrics of the ledger service in the Prometheus text format.
+
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/albertwidi/ftest/ledger"
+)
+
+const namespace = "ledger"
+
+// unmatchedRoute is the route label of the requests that don't match any route, so the unknown paths don't create new
+// time series.
+const unmatchedRoute = "unmatched"
+
+// Metrics collects the metrics of the HTTP requests, the ledger and the database pool. Metrics implements
+// ledger.Observer, so it can be passed to the ledger to collect the ledger metrics.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests        *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+	transfers           *prometheus.CounterVec
+	phaseDuration       *prometheus.HistogramVec
+}
+
+var _ ledger.Observer = (*Metrics)(nil)
+
+// New creates the metrics and registers the collectors of the Go runtime, the process and the stats of the database pool.
+func New(db *sql.DB) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Total number of HTTP requests by chi route pattern, method and status code.",
+		}, []string{"route", "method", "code"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by chi route pattern and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		transfers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transfers_total",
+			Help:      "Total number of transfers by outcome. deadlock_retry counts the attempts that are aborted by a conflict and retried.",
+		}, []string{"outcome"}),
+		phaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "create_transaction_phase_duration_seconds",
+			Help:      "Duration of every phase of creating a transaction in the database. The lock phase includes the lock wait time.",
+			Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"phase"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.transfers,
+		m.phaseDuration,
+	)
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+	}
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware records the count and the latency of the HTTP requests. The requests are labeled with the chi route pattern
+// instead of the path, so the ids in the path don't create new time series. The middleware must be used by the root
+// router, so the route pattern is complete after the request is routed.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		m.httpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObserveTransfer implements ledger.Observer.
+func (m *Metrics) ObserveTransfer(outcome string) {
+	m.transfers.WithLabelValues(outcome).Inc()
+}
+
+// ObservePhase implements ledger.Observer.
+func (m *Metrics) ObservePhase(phase string, duration time.Duration) {
+	m.phaseDuration.WithLabelValues(phase).Observe(duration.Seconds())
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/albertwidi/ftest/ledger"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	m := New(nil)
+	r := chi.NewRouter()
+	r.Use(m.Middleware)
+	r.Route("/v1/ledger", func(r chi.Router) {
+		r.Get("/transactions/{transaction_id}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+	})
+	r.Handle("/metrics", m.Handler())
+
+	for _, path := range []string{"/v1/ledger/transactions/a", "/v1/ledger/transactions/b", "/unknown"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	m.ObserveTransfer(ledger.TransferOutcomeOK)
+	m.ObserveTransfer(ledger.TransferOutcomeInsufficientBalance)
+	m.ObservePhase(ledger.PhaseLock, time.Millisecond)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := []string{
+		`ledger_http_requests_total{code="404",method="GET",route="/v1/ledger/transactions/{transaction_id}"} 2`,
+		`ledger_http_requests_total{code="404",method="GET",route="unmatched"} 1`,
+		`ledger_http_request_duration_seconds_count{method="GET",route="/v1/ledger/transactions/{transaction_id}"} 2`,
+		`ledger_transfers_total{outcome="ok"} 1`,
+		`ledger_transfers_total{outcome="insufficient_balance"} 1`,
+		`ledger_create_transaction_phase_duration_seconds_count{phase="lock"} 1`,
+	}
+	for _, expect := range expects {
+		if !strings.Contains(string(out), expect) {
+			t.Fatalf("expecting %s in the metrics but got:\n%s", expect, out)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/albertwidi/ftest/database"
+	"github.com/albertwidi/ftest/database/migrate"
+)
+
+// runMigrate runs the migrate subcommand. The supported commands are:
+//
+//	migrate [up]         applies all pending migrations.
+//	migrate down [steps] rolls back the latest applied migrations, one migration by default.
+//	migrate status       prints the status of all migrations.
+func runMigrate(ctx context.Context, db *sql.DB, args []string) error {
+	migrator, err := migrate.New(db, database.LedgerMigrations, database.LedgerMigrationsDir)
+	if err != nil {
+		return err
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		migrations, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			slog.Info(fmt.Sprintf("applied migration %d_%s", migration.Version, migration.Name))
+		}
+		slog.Info(fmt.Sprintf("%d migrations applied", len(migrations)))
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid steps %q, must be a positive number", args[1])
+			}
+		}
+		migrations, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			slog.Info(fmt.Sprintf("rolled back migration %d_%s", migration.Version, migration.Name))
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.String()
+			}
+			fmt.Printf("%d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+	default:
+		return errors.New("unknown migrate command, must be one of up, down or status")
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/albertwidi/ftest/ledger"
+)
+
+// workers runs the background workers of the service, the workers are stopped together during the shutdown.
+type workers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newWorkers() *workers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workers{ctx: ctx, cancel: cancel}
+}
+
+func (wk *workers) run(fn func(ctx context.Context)) {
+	wk.wg.Add(1)
+	go func() {
+		defer wk.wg.Done()
+		fn(wk.ctx)
+	}()
+}
+
+// stop stops all workers and waits until they return.
+func (wk *workers) stop() {
+	wk.cancel()
+	wk.wg.Wait()
+}
+
+// shutdown stops the service gracefully in order:
+//  1. Flip the readiness to failing, and wait for shutdownDelay so the load balancer notices it and stops routing the
+//     new requests to the service.
+//  2. Stop accepting new connections and wait for the in-flight requests until the drainTimeout. The connections that
+//     are still active after the timeout are closed.
+//  3. Stop the ledger, the pending batch of transactions is created and the batches that are being created are waited.
+//  4. Stop the background workers.
+//  5. Close the database pool, after nothing uses it anymore.
+//  6. Flush the remaining spans to the tracing exporter.
+func shutdown(config config, ready *readiness, server *http.Server, ld *ledger.Ledger, wk *workers, db *sql.DB, shutdownTracing func(context.Context) error) {
+	ready.set(false)
+	slog.Info("service is not ready, waiting before draining", "delay", config.shutdownDelay)
+	time.Sleep(config.shutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.drainTimeout)
+	defer cancel()
+	slog.Info("draining in-flight requests", "timeout", config.drainTimeout)
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("failed to drain in-flight requests, closing the remaining connections", "error", err)
+		if err := server.Close(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("failed to close the server", "error", err)
+		}
+	}
+
+	ld.Stop()
+	wk.stop()
+	if err := db.Close(); err != nil {
+		slog.Error("failed to close the database", "error", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("failed to flush the spans", "error", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/albertwidi/ftest/ledger"
+)
+
+// TestShutdownReadiness tests the readiness fails as soon as the shutdown begins, while the server still serves the
+// requests during the shutdown delay, and the server is closed after the drain.
+func TestShutdownReadiness(t *testing.T) {
+	t.Parallel()
+
+	ready := newReadiness()
+	mux := http.NewServeMux()
+	mux.Handle("/readyz", ready)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: mux}
+	errC := make(chan error, 1)
+	go func() {
+		errC <- server.Serve(listener)
+	}()
+	ready.set(true)
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	readyz := func() (int, error) {
+		resp, err := client.Get("http://" + listener.Addr().String() + "/readyz")
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	if status, err := readyz(); err != nil || status != http.StatusOK {
+		t.Fatalf("expecting status %d before the shutdown but got %d: %v", http.StatusOK, status, err)
+	}
+
+	// The database is never connected, closing it only releases the pool.
+	db, err := sql.Open("postgres", "postgres://127.0.0.1:1/ledger?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		shutdown(config{shutdownDelay: 500 * time.Millisecond, drainTimeout: time.Second}, ready, server, ledger.NewMemory(),
+			newWorkers(), db, func(context.Context) error { return nil })
+	}()
+
+	deadline := time.Now().Add(400 * time.Millisecond)
+	for {
+		status, err := readyz()
+		if err != nil {
+			t.Fatalf("expecting the server to serve during the shutdown delay but got %v", err)
+		}
+		if status == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expecting status %d after the shutdown begins but got %d", http.StatusServiceUnavailable, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown doesn't return")
+	}
+	if err := <-errC; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("expecting error %v but got %v", http.ErrServerClosed, err)
+	}
+	if _, err := readyz(); err == nil {
+		t.Fatal("expecting the server to be closed after the shutdown")
+	}
+}
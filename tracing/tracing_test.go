@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestMiddleware tests whether the span of the request continues the trace from the traceparent header, and is named
+// after the route pattern.
+func TestMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	if _, err := Setup(context.Background(), Config{Exporter: ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Route("/v1/ledger", func(r chi.Router) {
+		r.Get("/transactions/{transaction_id}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/ledger/transactions/a", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expecting 1 span but got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "GET /v1/ledger/transactions/{transaction_id}" {
+		t.Fatalf("unexpected span name %s", span.Name())
+	}
+	if traceID := span.SpanContext().TraceID().String(); traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expecting the trace id from the traceparent but got %s", traceID)
+	}
+	if parentID := span.Parent().SpanID().String(); parentID != "00f067aa0ba902b7" {
+		t.Fatalf("expecting the parent span id from the traceparent but got %s", parentID)
+	}
+	if span.Status().Code != codes.Error {
+		t.Fatalf("expecting error status but got %v", span.Status().Code)
+	}
+}
+
+func TestSetupStdoutFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(context.Background(), Config{ServiceName: "test", Exporter: ExporterStdout, File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "test")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) == 0 {
+		t.Fatal("expecting the span to be written into the file")
+	}
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), Config{Exporter: "jaeger"}); err == nil {
+		t.Fatal("expecting error for unknown exporter")
+	}
+}